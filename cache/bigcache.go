@@ -0,0 +1,134 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/allegro/bigcache/v3"
+)
+
+// bigCache is the Cache on top of bigcache. Bigcache only has a single life
+// window for every entry, so the TTLs are kept on the side, and the entries
+// never outlive the life window no matter their TTL.
+type bigCache struct {
+	db *bigcache.BigCache
+	// mu serializes the writes, so Update is atomic, and guards deadlines.
+	mu        sync.Mutex
+	deadlines deadlines
+}
+
+// NewBigCache wraps the bigcache instance. Closing it is left to the caller.
+func NewBigCache(db *bigcache.BigCache) (*bigCache, error) {
+	if db == nil {
+		return nil, fmt.Errorf("nil db")
+	}
+
+	return &bigCache{db: db, deadlines: newDeadlines()}, nil
+}
+
+func (b *bigCache) Get(key string) ([]byte, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.get(key)
+}
+
+func (b *bigCache) Set(key string, value []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	err := b.db.Set(key, value)
+	if err != nil {
+		return err
+	}
+
+	b.deadlines.delete(key)
+	return nil
+}
+
+func (b *bigCache) SetWithTTL(key string, value []byte, ttl time.Duration) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	err := b.db.Set(key, value)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, expired := range b.deadlines.set(key, now.Add(ttl), now) {
+		err := b.delete(expired)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (b *bigCache) Delete(key string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.deadlines.delete(key)
+	return b.delete(key)
+}
+
+func (b *bigCache) Update(key string, fn func(value []byte, found bool) ([]byte, error)) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	value, err := b.get(key)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+
+	value, err = fn(value, err == nil)
+	if err != nil {
+		return err
+	}
+
+	if value == nil {
+		b.deadlines.delete(key)
+		return b.delete(key)
+	}
+
+	return b.db.Set(key, value)
+}
+
+func (b *bigCache) get(key string) ([]byte, error) {
+	if b.deadlines.expired(key, time.Now()) {
+		b.deadlines.delete(key)
+
+		err := b.delete(key)
+		if err != nil {
+			return nil, err
+		}
+
+		return nil, ErrNotFound
+	}
+
+	value, err := b.db.Get(key)
+	if err != nil {
+		if errors.Is(err, bigcache.ErrEntryNotFound) {
+			// Evicted by bigcache, the deadline is of no use anymore.
+			b.deadlines.delete(key)
+			return nil, ErrNotFound
+		}
+
+		return nil, err
+	}
+
+	return value, nil
+}
+
+func (b *bigCache) delete(key string) error {
+	err := b.db.Delete(key)
+	if err != nil && !errors.Is(err, bigcache.ErrEntryNotFound) {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,81 @@
+// Package cache is the key-value cache that the handlers keep their
+// short-lived state on, with an implementation on top of bigcache for
+// production and one on top of a map for the tests.
+package cache
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrNotFound is returned by Get when the key is missing or has expired.
+var ErrNotFound = errors.New("cache: key not found")
+
+// Cache is a key-value cache that is safe for concurrent use.
+type Cache interface {
+	// Get returns the value of the key, or ErrNotFound.
+	Get(key string) ([]byte, error)
+	// Set keeps the value until the cache evicts it.
+	Set(key string, value []byte) error
+	// SetWithTTL keeps the value until the TTL has passed, or until the
+	// cache evicts it, whichever is first.
+	SetWithTTL(key string, value []byte, ttl time.Duration) error
+	// Delete drops the key. A missing key is not an error.
+	Delete(key string) error
+	// Update replaces the value of the key with the one returned by fn,
+	// without letting any other write on the cache in between. fn is given
+	// false if the key is missing. Returning a nil value drops the key,
+	// returning an error leaves it as is. The TTL of the key is kept.
+	Update(key string, fn func(value []byte, found bool) ([]byte, error)) error
+}
+
+// sweepThreshold is the least number of keys with a TTL that
+// triggers a sweep of the expired ones.
+const sweepThreshold = 1024
+
+// deadlines keeps when the keys that were set with a TTL expire. The
+// expired keys are dropped when they are accessed, and swept once the
+// number of keys has doubled since the last sweep, so the keys that are
+// never accessed again don't pile up.
+type deadlines struct {
+	expiresAt map[string]time.Time
+	sweepAt   int
+}
+
+func newDeadlines() deadlines {
+	return deadlines{expiresAt: make(map[string]time.Time), sweepAt: sweepThreshold}
+}
+
+// expired reports whether the key was set with a TTL that has passed.
+func (d *deadlines) expired(key string, now time.Time) bool {
+	expiresAt, ok := d.expiresAt[key]
+	return ok && !now.Before(expiresAt)
+}
+
+// set records the deadline of the key, and returns the
+// keys that have expired if it's time for a sweep.
+func (d *deadlines) set(key string, expiresAt time.Time, now time.Time) []string {
+	d.expiresAt[key] = expiresAt
+	if len(d.expiresAt) < d.sweepAt {
+		return nil
+	}
+
+	var expired []string
+	for key, expiresAt := range d.expiresAt {
+		if !now.Before(expiresAt) {
+			expired = append(expired, key)
+			delete(d.expiresAt, key)
+		}
+	}
+
+	d.sweepAt = len(d.expiresAt) * 2
+	if d.sweepAt < sweepThreshold {
+		d.sweepAt = sweepThreshold
+	}
+
+	return expired
+}
+
+func (d *deadlines) delete(key string) {
+	delete(d.expiresAt, key)
+}
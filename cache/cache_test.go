@@ -0,0 +1,212 @@
+package cache_test
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"captcha-lite/cache"
+
+	"github.com/allegro/bigcache/v3"
+)
+
+func implementations() map[string]func(t *testing.T) cache.Cache {
+	return map[string]func(t *testing.T) cache.Cache{
+		"BigCache": func(t *testing.T) cache.Cache {
+			db, err := bigcache.New(context.Background(), bigcache.DefaultConfig(time.Hour))
+			if err != nil {
+				t.Fatalf("creating bigcache instance: %s", err.Error())
+			}
+
+			t.Cleanup(func() {
+				_ = db.Close()
+			})
+
+			c, err := cache.NewBigCache(db)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			return c
+		},
+		"Map": func(t *testing.T) cache.Cache {
+			return cache.NewMap()
+		},
+	}
+}
+
+func TestNewBigCache(t *testing.T) {
+	_, err := cache.NewBigCache(nil)
+	if err == nil || err.Error() != "nil db" {
+		t.Errorf("expecting an error of 'nil db', instead got %v", err)
+	}
+}
+
+func TestCache_GetSetDelete(t *testing.T) {
+	for name, newCache := range implementations() {
+		t.Run(name, func(t *testing.T) {
+			c := newCache(t)
+
+			_, err := c.Get("key")
+			if !errors.Is(err, cache.ErrNotFound) {
+				t.Errorf("expecting ErrNotFound, got %v", err)
+			}
+
+			err = c.Set("key", []byte("value"))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			value, err := c.Get("key")
+			if err != nil || string(value) != "value" {
+				t.Errorf("expecting %q, got %q (%v)", "value", value, err)
+			}
+
+			err = c.Delete("key")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			_, err = c.Get("key")
+			if !errors.Is(err, cache.ErrNotFound) {
+				t.Errorf("expecting the key to be deleted, got %v", err)
+			}
+
+			err = c.Delete("key")
+			if err != nil {
+				t.Errorf("expecting deleting a missing key not to be an error, got %v", err)
+			}
+		})
+	}
+}
+
+func TestCache_SetWithTTL(t *testing.T) {
+	for name, newCache := range implementations() {
+		t.Run(name, func(t *testing.T) {
+			c := newCache(t)
+
+			for _, key := range []string{"short", "updated", "overwritten"} {
+				err := c.SetWithTTL(key, []byte("value"), time.Millisecond*50)
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			}
+
+			err := c.SetWithTTL("long", []byte("value"), time.Hour)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			// Updating the key keeps its TTL, setting it again drops it.
+			err = c.Update("updated", func(value []byte, found bool) ([]byte, error) {
+				return append(value, '!'), nil
+			})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			err = c.Set("overwritten", []byte("value"))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			time.Sleep(time.Millisecond * 100)
+
+			for _, key := range []string{"short", "updated"} {
+				_, err = c.Get(key)
+				if !errors.Is(err, cache.ErrNotFound) {
+					t.Errorf("expecting %q to have expired, got %v", key, err)
+				}
+			}
+
+			for _, key := range []string{"long", "overwritten"} {
+				_, err = c.Get(key)
+				if err != nil {
+					t.Errorf("expecting %q to be kept, got %v", key, err)
+				}
+			}
+		})
+	}
+}
+
+func TestCache_Update(t *testing.T) {
+	for name, newCache := range implementations() {
+		t.Run(name, func(t *testing.T) {
+			c := newCache(t)
+
+			err := c.Update("key", func(value []byte, found bool) ([]byte, error) {
+				if found {
+					t.Error("expecting the key to be missing")
+				}
+
+				return []byte("created"), nil
+			})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			failure := errors.New("failure")
+			err = c.Update("key", func(value []byte, found bool) ([]byte, error) {
+				if !found || string(value) != "created" {
+					t.Errorf("expecting the current value, got %q (%t)", value, found)
+				}
+
+				return []byte("lost"), failure
+			})
+			if !errors.Is(err, failure) {
+				t.Errorf("expecting the error of fn, got %v", err)
+			}
+
+			value, err := c.Get("key")
+			if err != nil || string(value) != "created" {
+				t.Errorf("expecting a failed update to leave the value, got %q (%v)", value, err)
+			}
+
+			err = c.Update("key", func(value []byte, found bool) ([]byte, error) {
+				return nil, nil
+			})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			_, err = c.Get("key")
+			if !errors.Is(err, cache.ErrNotFound) {
+				t.Errorf("expecting a nil value to drop the key, got %v", err)
+			}
+		})
+	}
+}
+
+func TestCache_ConcurrentUpdates(t *testing.T) {
+	for name, newCache := range implementations() {
+		t.Run(name, func(t *testing.T) {
+			c := newCache(t)
+			const writers = 50
+
+			var wg sync.WaitGroup
+			for i := 0; i < writers; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					err := c.Update("counter", func(value []byte, found bool) ([]byte, error) {
+						count, _ := strconv.Atoi(string(value))
+						return []byte(strconv.Itoa(count + 1)), nil
+					})
+					if err != nil {
+						t.Errorf("unexpected error: %v", err)
+					}
+				}()
+			}
+
+			wg.Wait()
+
+			value, err := c.Get("counter")
+			if err != nil || string(value) != strconv.Itoa(writers) {
+				t.Errorf("expecting none of the updates to be lost, got %q (%v)", value, err)
+			}
+		})
+	}
+}
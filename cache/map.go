@@ -0,0 +1,103 @@
+package cache
+
+import (
+	"sync"
+	"time"
+)
+
+// mapCache is the Cache on top of a map. It never evicts the keys without
+// a TTL, so it's meant for the tests rather than for production.
+type mapCache struct {
+	mu        sync.Mutex
+	values    map[string][]byte
+	deadlines deadlines
+}
+
+// NewMap returns an empty Cache on top of a map.
+func NewMap() *mapCache {
+	return &mapCache{values: make(map[string][]byte), deadlines: newDeadlines()}
+}
+
+func (m *mapCache) Get(key string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	value, ok := m.get(key)
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return clone(value), nil
+}
+
+func (m *mapCache) Set(key string, value []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.values[key] = clone(value)
+	m.deadlines.delete(key)
+	return nil
+}
+
+func (m *mapCache) SetWithTTL(key string, value []byte, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.values[key] = clone(value)
+
+	now := time.Now()
+	for _, expired := range m.deadlines.set(key, now.Add(ttl), now) {
+		delete(m.values, expired)
+	}
+
+	return nil
+}
+
+func (m *mapCache) Delete(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.values, key)
+	m.deadlines.delete(key)
+	return nil
+}
+
+func (m *mapCache) Update(key string, fn func(value []byte, found bool) ([]byte, error)) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	value, ok := m.get(key)
+	value, err := fn(clone(value), ok)
+	if err != nil {
+		return err
+	}
+
+	if value == nil {
+		delete(m.values, key)
+		m.deadlines.delete(key)
+		return nil
+	}
+
+	m.values[key] = clone(value)
+	return nil
+}
+
+func (m *mapCache) get(key string) ([]byte, bool) {
+	if m.deadlines.expired(key, time.Now()) {
+		delete(m.values, key)
+		m.deadlines.delete(key)
+		return nil, false
+	}
+
+	value, ok := m.values[key]
+	return value, ok
+}
+
+// clone copies the value, so the caller can't change what's on the cache.
+func clone(value []byte) []byte {
+	if value == nil {
+		return nil
+	}
+
+	return append([]byte{}, value...)
+}
@@ -1,7 +1,6 @@
 package captcha
 
 import (
-	"encoding/json"
 	"strconv"
 
 	tb "gopkg.in/telebot.v3"
@@ -16,17 +15,15 @@ func (d *Dependencies) collectAdditionalAndCache(captcha *Captcha, m *tb.Message
 	// Because the wrongMsg is another message sent by us, which correlates to the
 	// captcha message, we need to put the message ID into the cache.
 	// So that we can delete it later.
-	captcha.AdditionalMessages = append(captcha.AdditionalMessages, strconv.Itoa(wrongMsg.ID))
-
-	// Update the cache with the added AdditionalMessages
-	data, err := json.Marshal(captcha)
+	updated, ok, err := d.Store.Update(m.Sender.ID, func(captcha *Captcha) {
+		captcha.AdditionalMessages = append(captcha.AdditionalMessages, strconv.Itoa(wrongMsg.ID))
+	})
 	if err != nil {
 		return err
 	}
 
-	err = d.Memory.Set(strconv.FormatInt(m.Sender.ID, 10), data)
-	if err != nil {
-		return err
+	if ok {
+		*captcha = updated
 	}
 
 	return nil
@@ -34,17 +31,15 @@ func (d *Dependencies) collectAdditionalAndCache(captcha *Captcha, m *tb.Message
 
 func (d *Dependencies) collectUserMessageAndCache(captcha *Captcha, m *tb.Message) error {
 	// We store directly the message ID that was sent by the user into the UserMessages slices.
-	captcha.UserMessages = append(captcha.UserMessages, strconv.Itoa(m.ID))
-
-	// Update the cache with the added UserMessages
-	data, err := json.Marshal(captcha)
+	updated, ok, err := d.Store.Update(m.Sender.ID, func(captcha *Captcha) {
+		captcha.UserMessages = append(captcha.UserMessages, strconv.Itoa(m.ID))
+	})
 	if err != nil {
 		return err
 	}
 
-	err = d.Memory.Set(strconv.FormatInt(m.Sender.ID, 10), data)
-	if err != nil {
-		return err
+	if ok {
+		*captcha = updated
 	}
 
 	return nil
@@ -1,12 +1,12 @@
 package captcha
 
 import (
-	"encoding/json"
 	"strconv"
 	"strings"
 	"time"
 
 	"captcha-lite/locale"
+	"captcha-lite/logger"
 
 	"github.com/pkg/errors"
 	tb "gopkg.in/telebot.v3"
@@ -18,7 +18,7 @@ func (d *Dependencies) WaitForAnswer(m *tb.Message) {
 	// Check if the message author is in the captcha:users list or not
 	// If not, return
 	// If yes, check if the answer is correct or not
-	exists, err := userExists(d.Memory, strconv.FormatInt(m.Sender.ID, 10))
+	exists, err := d.Store.IsPending(m.Sender.ID)
 	if err != nil {
 		d.Log.HandleBotError(err, d.Bot, m)
 		return
@@ -34,16 +34,14 @@ func (d *Dependencies) WaitForAnswer(m *tb.Message) {
 	//
 	// Get the answer and all the data surrounding captcha from
 	// this specific user ID from the cache.
-	data, err := d.Memory.Get(strconv.FormatInt(m.Sender.ID, 10))
+	captcha, ok, err := d.Store.Get(m.Sender.ID)
 	if err != nil {
 		d.Log.HandleBotError(err, d.Bot, m)
 		return
 	}
 
-	var captcha Captcha
-	err = json.Unmarshal(data, &captcha)
-	if err != nil {
-		d.Log.HandleBotError(err, d.Bot, m)
+	if !ok {
+		// They have just answered, left, or been kicked.
 		return
 	}
 
@@ -61,6 +59,8 @@ func (d *Dependencies) WaitForAnswer(m *tb.Message) {
 
 	// Check if the answer is not a number
 	if _, err := strconv.Atoi(answer); errors.Is(err, strconv.ErrSyntax) {
+		d.Log.Debug("captcha answer is not a number", logger.ChatID(m.Chat.ID), logger.UserID(m.Sender.ID))
+
 		remainingTime := time.Until(captcha.Expiry)
 		wrongMsg, err := d.Bot.Send(
 			m.Chat,
@@ -89,6 +89,8 @@ func (d *Dependencies) WaitForAnswer(m *tb.Message) {
 
 	// Check if the answer is correct or not
 	if answer != captcha.Answer {
+		d.Log.Debug("wrong captcha answer", logger.ChatID(m.Chat.ID), logger.UserID(m.Sender.ID))
+
 		remainingTime := time.Until(captcha.Expiry)
 		wrongMsg, err := d.Bot.Send(
 			m.Chat,
@@ -116,12 +118,14 @@ func (d *Dependencies) WaitForAnswer(m *tb.Message) {
 		return
 	}
 
-	err = d.removeUserFromCache(strconv.FormatInt(m.Sender.ID, 10))
+	err = d.Store.Remove(m.Sender.ID)
 	if err != nil {
 		d.Log.HandleBotError(err, d.Bot, m)
 		return
 	}
 
+	d.Log.Info("user passed the captcha", logger.ChatID(m.Chat.ID), logger.UserID(m.Sender.ID))
+
 	// Congratulate the user, delete the message, then delete user from captcha:users
 	// Send the welcome message to the user.
 	err = d.sendWelcomeMessage(m)
@@ -171,27 +175,6 @@ func (d *Dependencies) WaitForAnswer(m *tb.Message) {
 	}
 }
 
-// It... remove the user from cache. What else do you expect?
-func (d *Dependencies) removeUserFromCache(key string) error {
-	users, err := d.Memory.Get("captcha:users")
-	if err != nil {
-		return err
-	}
-
-	str := strings.Replace(string(users), ";"+key, "", 1)
-	err = d.Memory.Set("captcha:users", []byte(str))
-	if err != nil {
-		return err
-	}
-
-	err = d.Memory.Delete(key)
-	if err != nil {
-		return err
-	}
-
-	return nil
-}
-
 // Uh… You should understand what this function does.
 // It's pretty self-explanatory.
 func removeSpaces(text string) string {
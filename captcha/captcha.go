@@ -1,18 +1,32 @@
 package captcha
 
 import (
+	"sync"
+	"time"
+
 	"captcha-lite/locale"
 	"captcha-lite/logger"
-
-	"github.com/allegro/bigcache/v3"
-	tb "gopkg.in/telebot.v3"
+	"captcha-lite/telegram"
 )
 
 // Dependencies contains the dependency injection struct for
 // methods in the captcha package.
 type Dependencies struct {
-	Memory *bigcache.BigCache
-	Bot    *tb.Bot
+	// Store keeps the pending captchas.
+	Store  Store
+	Bot    telegram.Client
 	Log    logger.Logger
 	Locale map[locale.Message]string
+
+	// CatchUpTimeout is how long the stale joiners have to press the
+	// button on the catch up challenge. Defaults to DefaultCatchUpTimeout.
+	CatchUpTimeout time.Duration
+
+	// answerTimeout is how long the new members have to answer the
+	// captcha before they are kicked. Defaults to Timeout, the join
+	// message says so. Only the tests change it.
+	answerTimeout time.Duration
+
+	catchUpMu     sync.Mutex
+	catchUpGroups map[int64]*catchUpGroup
 }
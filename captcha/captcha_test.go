@@ -0,0 +1,329 @@
+package captcha_test
+
+import (
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"captcha-lite/cache"
+	"captcha-lite/captcha"
+	memorystore "captcha-lite/captcha/store/memory"
+	"captcha-lite/locale"
+	"captcha-lite/logger"
+	"captcha-lite/telegram"
+	"captcha-lite/telegram/telegramtest"
+
+	tb "gopkg.in/telebot.v3"
+)
+
+const (
+	chatID int64 = -100123
+	userID int64 = 42
+)
+
+// recorder is a logger.Logger that keeps the errors, as the handlers
+// report them instead of returning them.
+type recorder struct {
+	mu     sync.Mutex
+	errors []error
+}
+
+func (r *recorder) HandleError(e error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.errors = append(r.errors, e)
+}
+
+func (r *recorder) HandleBotError(e error, bot telegram.Client, m *tb.Message) { r.HandleError(e) }
+
+func (r *recorder) Debug(msg string, fields ...logger.Field) {}
+
+func (r *recorder) Info(msg string, fields ...logger.Field) {}
+
+func (r *recorder) Warn(msg string, fields ...logger.Field) {}
+
+func (r *recorder) Error(msg string, fields ...logger.Field) {}
+
+func (r *recorder) Errors() []error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]error(nil), r.errors...)
+}
+
+func newMessage(id int, text string) *tb.Message {
+	return &tb.Message{
+		ID:     id,
+		Chat:   &tb.Chat{ID: chatID, Type: tb.ChatSuperGroup, FirstName: "Gophers"},
+		Sender: &tb.User{ID: userID, FirstName: "Gopher"},
+		Text:   text,
+	}
+}
+
+// describe formats the call as the method, along with the message or
+// the user it was made on when that matters.
+func describe(call telegramtest.Call) string {
+	switch call.Method {
+	case "Delete":
+		return "Delete " + call.MessageID
+	case "Ban":
+		return "Ban " + strconv.FormatInt(call.UserID, 10)
+	default:
+		return call.Method
+	}
+}
+
+func TestHandlers(t *testing.T) {
+	tests := []struct {
+		name string
+		// pending is the captcha that the sender has before the
+		// message comes in, if any.
+		pending       *captcha.Captcha
+		admins        []tb.ChatMember
+		answerTimeout time.Duration
+		message       func() *tb.Message
+		handle        func(d *captcha.Dependencies, m *tb.Message)
+		// calls are the calls that are made on the bot, in order.
+		calls []string
+		// stillPending is whether the sender still has a captcha after.
+		stillPending bool
+		check        func(t *testing.T, c captcha.Captcha)
+	}{
+		{
+			name: "Join",
+			message: func() *tb.Message {
+				m := newMessage(20, "")
+				m.UserJoined = m.Sender
+				return m
+			},
+			handle:       (*captcha.Dependencies).CaptchaUserJoin,
+			calls:        []string{"AdminsOf", "Send"},
+			stillPending: true,
+			check: func(t *testing.T, c captcha.Captcha) {
+				if c.ChatID != chatID || c.QuestionID != "1" {
+					t.Errorf("expecting the question 1 on chat %d, got %s on chat %d", chatID, c.QuestionID, c.ChatID)
+				}
+
+				if _, err := strconv.Atoi(c.Answer); err != nil || len(c.Answer) != 3 {
+					t.Errorf("expecting a 3 digit answer, got %q", c.Answer)
+				}
+
+				if remaining := time.Until(c.Expiry); remaining <= 0 || remaining > time.Hour {
+					t.Errorf("expecting the captcha to expire within the answer timeout, got %s", remaining)
+				}
+			},
+		},
+		{
+			name: "Join by an admin",
+			admins: []tb.ChatMember{
+				{User: &tb.User{ID: userID}, Role: tb.Administrator},
+			},
+			message: func() *tb.Message {
+				m := newMessage(20, "")
+				m.UserJoined = m.Sender
+				return m
+			},
+			handle: (*captcha.Dependencies).CaptchaUserJoin,
+			calls:  []string{"AdminsOf"},
+		},
+		{
+			name: "Right answer",
+			pending: &captcha.Captcha{
+				Answer:             "123",
+				QuestionID:         "10",
+				AdditionalMessages: []string{"11"},
+			},
+			message: func() *tb.Message { return newMessage(20, "1 2 3") },
+			handle:  (*captcha.Dependencies).WaitForAnswer,
+			calls:   []string{"Send", "Delete 20", "Delete 11", "Delete 10"},
+		},
+		{
+			name: "Wrong answer",
+			pending: &captcha.Captcha{
+				Answer:             "123",
+				QuestionID:         "10",
+				AdditionalMessages: []string{"11"},
+			},
+			message:      func() *tb.Message { return newMessage(20, "321") },
+			handle:       (*captcha.Dependencies).WaitForAnswer,
+			calls:        []string{"Send"},
+			stillPending: true,
+			check: func(t *testing.T, c captcha.Captcha) {
+				if !reflect.DeepEqual(c.UserMessages, []string{"20"}) {
+					t.Errorf("expecting the answer to be collected, got %v", c.UserMessages)
+				}
+
+				if !reflect.DeepEqual(c.AdditionalMessages, []string{"11", "1"}) {
+					t.Errorf("expecting the reply to be collected, got %v", c.AdditionalMessages)
+				}
+			},
+		},
+		{
+			name: "Answer with letters",
+			pending: &captcha.Captcha{
+				Answer:     "123",
+				QuestionID: "10",
+			},
+			message:      func() *tb.Message { return newMessage(20, "abc") },
+			handle:       (*captcha.Dependencies).WaitForAnswer,
+			calls:        []string{"Send"},
+			stillPending: true,
+			check: func(t *testing.T, c captcha.Captcha) {
+				if !reflect.DeepEqual(c.AdditionalMessages, []string{"1"}) {
+					t.Errorf("expecting the reply to be collected, got %v", c.AdditionalMessages)
+				}
+			},
+		},
+		{
+			name:    "Text without a pending captcha",
+			message: func() *tb.Message { return newMessage(20, "123") },
+			handle:  (*captcha.Dependencies).WaitForAnswer,
+		},
+		{
+			name: "Non-text",
+			pending: &captcha.Captcha{
+				Answer:             "123",
+				QuestionID:         "10",
+				AdditionalMessages: []string{"11"},
+			},
+			message: func() *tb.Message {
+				m := newMessage(20, "")
+				m.Sticker = &tb.Sticker{}
+				return m
+			},
+			handle:       (*captcha.Dependencies).NonTextListener,
+			calls:        []string{"Send", "Delete 20"},
+			stillPending: true,
+			check: func(t *testing.T, c captcha.Captcha) {
+				if !reflect.DeepEqual(c.AdditionalMessages, []string{"11", "1"}) {
+					t.Errorf("expecting the reply to be collected, got %v", c.AdditionalMessages)
+				}
+			},
+		},
+		{
+			name: "Leave",
+			pending: &captcha.Captcha{
+				Answer:             "123",
+				QuestionID:         "10",
+				AdditionalMessages: []string{"11"},
+				UserMessages:       []string{"19"},
+			},
+			message: func() *tb.Message {
+				m := newMessage(20, "")
+				m.UserLeft = m.Sender
+				return m
+			},
+			handle: (*captcha.Dependencies).CaptchaUserLeave,
+			calls:  []string{"AdminsOf", "Delete 10", "Delete 19", "Delete 11"},
+		},
+		{
+			name:          "Timeout",
+			answerTimeout: time.Millisecond * 10,
+			message: func() *tb.Message {
+				m := newMessage(20, "")
+				m.UserJoined = m.Sender
+				return m
+			},
+			handle: (*captcha.Dependencies).CaptchaUserJoin,
+			// The kick message is deleted a minute later.
+			calls: []string{"AdminsOf", "Send", "Send", "Ban 42", "Delete 1"},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			store, err := memorystore.NewMemoryStore(cache.NewMap())
+			if err != nil {
+				t.Fatalf("creating store: %s", err.Error())
+			}
+
+			if test.pending != nil {
+				pending := *test.pending
+				pending.ChatID = chatID
+				pending.Expiry = time.Now().Add(time.Minute)
+				err = store.Put(userID, pending)
+				if err != nil {
+					t.Fatalf("putting pending captcha: %s", err.Error())
+				}
+			}
+
+			bot := telegramtest.NewFake()
+			bot.Admins[chatID] = test.admins
+
+			answerTimeout := test.answerTimeout
+			if answerTimeout == 0 {
+				// Long enough for the user to not be kicked during the test.
+				answerTimeout = time.Hour
+			}
+
+			log := &recorder{}
+			d := &captcha.Dependencies{
+				Store:  store,
+				Bot:    bot,
+				Log:    log,
+				Locale: locale.EN,
+			}
+			captcha.SetAnswerTimeout(d, answerTimeout)
+
+			test.handle(d, test.message())
+
+			// The user is kicked on a goroutine, so we wait for the
+			// calls and the store to settle.
+			var calls []string
+			var pending bool
+			deadline := time.Now().Add(time.Second * 5)
+			for {
+				calls = calls[:0]
+				for _, call := range bot.Calls() {
+					calls = append(calls, describe(call))
+				}
+
+				pending, err = store.IsPending(userID)
+				if err != nil {
+					t.Fatalf("checking pending: %s", err.Error())
+				}
+
+				if (len(calls) >= len(test.calls) && pending == test.stillPending) || time.Now().After(deadline) {
+					break
+				}
+
+				time.Sleep(time.Millisecond * 10)
+			}
+
+			if errs := log.Errors(); len(errs) != 0 {
+				t.Errorf("expecting no errors, got %v", errs)
+			}
+
+			if len(calls) != 0 || len(test.calls) != 0 {
+				if !reflect.DeepEqual(calls, test.calls) {
+					t.Errorf("expecting calls %v, got %v", test.calls, calls)
+				}
+			}
+
+			for _, call := range bot.Calls() {
+				if call.ChatID != chatID {
+					t.Errorf("expecting %s to be made on chat %d, got %d", call.Method, chatID, call.ChatID)
+				}
+			}
+
+			if pending != test.stillPending {
+				t.Fatalf("expecting pending to be %t, got %t", test.stillPending, pending)
+			}
+
+			c, ok, err := store.Get(userID)
+			if err != nil {
+				t.Fatalf("getting captcha: %s", err.Error())
+			}
+
+			if ok != test.stillPending {
+				t.Fatalf("expecting the captcha to be kept to be %t, got %t", test.stillPending, ok)
+			}
+
+			if test.check != nil {
+				test.check(t, c)
+			}
+		})
+	}
+}
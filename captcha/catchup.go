@@ -0,0 +1,282 @@
+package captcha
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"captcha-lite/locale"
+	"captcha-lite/logger"
+	"captcha-lite/utils"
+
+	tb "gopkg.in/telebot.v3"
+)
+
+// CatchUpButtonUnique is the unique identifier of the inline button
+// on the catch up challenge message.
+const CatchUpButtonUnique = "captcha_catchup"
+
+const (
+	// CatchUpDebounce is how long we wait for more stale joins on the
+	// same group before posting the consolidated challenge message.
+	CatchUpDebounce = 5 * time.Second
+	// DefaultCatchUpTimeout is used when Dependencies.CatchUpTimeout is zero.
+	DefaultCatchUpTimeout = 10 * time.Minute
+)
+
+// catchUpGroup holds the stale joiners of a single group that
+// haven't pressed the button yet.
+type catchUpGroup struct {
+	chat      *tb.Chat
+	users     []*tb.User
+	messageID int
+}
+
+func (g *catchUpGroup) indexOf(userID int64) int {
+	for i, user := range g.users {
+		if user.ID == userID {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// CatchUpUserJoin handles a user join that we received way too late,
+// usually because the bot was down for a while.
+//
+// Instead of giving them a one minute captcha, the user is restricted
+// to read-only, and every stale joiner of the group is collected into one
+// consolidated challenge message with a button. The ones that don't
+// press the button before the catch up timeout will be kicked.
+func (d *Dependencies) CatchUpUserJoin(m *tb.Message) {
+	admins, err := d.Bot.AdminsOf(m.Chat)
+	if err != nil {
+		if !strings.Contains(err.Error(), "Gateway Timeout (504)") && !strings.Contains(err.Error(), "retry after") {
+			d.Log.HandleBotError(err, d.Bot, m)
+			return
+		}
+	}
+
+	if m.UserJoined.ID != 0 {
+		m.Sender = m.UserJoined
+	}
+
+	if m.Sender.IsBot || m.Private() || utils.IsAdmin(admins, m.Sender) {
+		return
+	}
+
+	err = d.Bot.Restrict(m.Chat, &tb.ChatMember{
+		User:            m.Sender,
+		Rights:          tb.NoRights(),
+		RestrictedUntil: tb.Forever(),
+	})
+	if err != nil {
+		d.Log.HandleBotError(err, d.Bot, m)
+		return
+	}
+
+	d.catchUpMu.Lock()
+	defer d.catchUpMu.Unlock()
+
+	if d.catchUpGroups == nil {
+		d.catchUpGroups = make(map[int64]*catchUpGroup)
+	}
+
+	group, ok := d.catchUpGroups[m.Chat.ID]
+	if !ok {
+		group = &catchUpGroup{chat: m.Chat}
+		d.catchUpGroups[m.Chat.ID] = group
+
+		// Give the backlog some time to arrive, so we only post one message.
+		time.AfterFunc(CatchUpDebounce, func() {
+			d.sendCatchUpChallenge(m.Chat.ID)
+		})
+	}
+
+	if group.indexOf(m.Sender.ID) == -1 {
+		group.users = append(group.users, m.Sender)
+	}
+
+	d.Log.Info("restricted a stale joiner", logger.ChatID(m.Chat.ID), logger.UserID(m.Sender.ID))
+
+	// The challenge is already out there, add the new user to it.
+	if group.messageID != 0 {
+		d.editCatchUpChallenge(group)
+	}
+}
+
+// CatchUpCallback handles the button press on the catch up challenge message.
+func (d *Dependencies) CatchUpCallback(c tb.Context) error {
+	if c.Callback() == nil || c.Chat() == nil {
+		return nil
+	}
+
+	d.catchUpMu.Lock()
+	defer d.catchUpMu.Unlock()
+
+	group, ok := d.catchUpGroups[c.Chat().ID]
+	if !ok || group.indexOf(c.Sender().ID) == -1 {
+		err := c.Respond(&tb.CallbackResponse{Text: d.Locale[locale.MessageCatchUpNotForYou]})
+		if err != nil {
+			d.Log.HandleError(err)
+		}
+		return nil
+	}
+
+	// Give them back the default permissions of the group.
+	rights := tb.NoRestrictions()
+	chat, err := d.Bot.ChatByID(c.Chat().ID)
+	if err != nil {
+		d.Log.HandleError(err)
+	} else if chat.Permissions != nil {
+		rights = *chat.Permissions
+	}
+
+	err = d.Bot.Restrict(c.Chat(), &tb.ChatMember{
+		User:            c.Sender(),
+		Rights:          rights,
+		RestrictedUntil: tb.Forever(),
+	})
+	if err != nil {
+		d.Log.HandleError(err)
+		return nil
+	}
+
+	i := group.indexOf(c.Sender().ID)
+	group.users = append(group.users[:i], group.users[i+1:]...)
+
+	d.Log.Info("stale joiner passed the catch up challenge", logger.ChatID(c.Chat().ID), logger.UserID(c.Sender().ID))
+
+	err = c.Respond(&tb.CallbackResponse{Text: d.Locale[locale.MessageCatchUpVerified]})
+	if err != nil {
+		d.Log.HandleError(err)
+	}
+
+	if len(group.users) == 0 {
+		if group.messageID != 0 {
+			err = d.deleteMessageBlocking(&tb.StoredMessage{
+				ChatID:    group.chat.ID,
+				MessageID: strconv.Itoa(group.messageID),
+			})
+			if err != nil {
+				d.Log.HandleError(err)
+			}
+		}
+
+		delete(d.catchUpGroups, c.Chat().ID)
+		return nil
+	}
+
+	d.editCatchUpChallenge(group)
+	return nil
+}
+
+func (d *Dependencies) catchUpTimeout() time.Duration {
+	if d.CatchUpTimeout <= 0 {
+		return DefaultCatchUpTimeout
+	}
+
+	return d.CatchUpTimeout
+}
+
+func (d *Dependencies) renderCatchUpChallenge(group *catchUpGroup) (string, *tb.ReplyMarkup) {
+	var mentions []string
+	for _, user := range group.users {
+		mentions = append(mentions, "<a href=\"tg://user?id="+strconv.FormatInt(user.ID, 10)+"\">"+
+			sanitizeInput(user.FirstName)+utils.ShouldAddSpace(user)+sanitizeInput(user.LastName)+
+			"</a>")
+	}
+
+	text := strings.NewReplacer(
+		"{{users}}", strings.Join(mentions, ", "),
+		"{{minutes}}", strconv.Itoa(int(d.catchUpTimeout().Minutes())),
+	).Replace(d.Locale[locale.MessageCatchUp])
+
+	markup := &tb.ReplyMarkup{}
+	markup.Inline(markup.Row(markup.Data(d.Locale[locale.MessageCatchUpButton], CatchUpButtonUnique)))
+
+	return text, markup
+}
+
+func (d *Dependencies) sendCatchUpChallenge(chatID int64) {
+	d.catchUpMu.Lock()
+	defer d.catchUpMu.Unlock()
+
+	group, ok := d.catchUpGroups[chatID]
+	if !ok || len(group.users) == 0 {
+		delete(d.catchUpGroups, chatID)
+		return
+	}
+
+	text, markup := d.renderCatchUpChallenge(group)
+	msg, err := d.Bot.Send(
+		group.chat,
+		text,
+		&tb.SendOptions{
+			ParseMode:             tb.ModeHTML,
+			DisableWebPagePreview: true,
+			ReplyMarkup:           markup,
+		},
+	)
+	if err != nil {
+		d.Log.HandleError(err)
+		return
+	}
+
+	group.messageID = msg.ID
+
+	time.AfterFunc(d.catchUpTimeout(), func() {
+		d.expireCatchUpChallenge(chatID)
+	})
+}
+
+func (d *Dependencies) editCatchUpChallenge(group *catchUpGroup) {
+	text, markup := d.renderCatchUpChallenge(group)
+	_, err := d.Bot.Edit(
+		&tb.StoredMessage{ChatID: group.chat.ID, MessageID: strconv.Itoa(group.messageID)},
+		text,
+		&tb.SendOptions{
+			ParseMode:             tb.ModeHTML,
+			DisableWebPagePreview: true,
+			ReplyMarkup:           markup,
+		},
+	)
+	if err != nil && !strings.Contains(err.Error(), "message is not modified") {
+		d.Log.HandleError(err)
+	}
+}
+
+// expireCatchUpChallenge kicks every stale joiner that didn't press
+// the button in time.
+func (d *Dependencies) expireCatchUpChallenge(chatID int64) {
+	d.catchUpMu.Lock()
+	defer d.catchUpMu.Unlock()
+
+	group, ok := d.catchUpGroups[chatID]
+	if !ok {
+		return
+	}
+	delete(d.catchUpGroups, chatID)
+
+	for _, user := range group.users {
+		err := d.Bot.Ban(group.chat, &tb.ChatMember{
+			RestrictedUntil: time.Now().Unix() + int64(BanDuration),
+			User:            user,
+		}, true)
+		if err != nil {
+			d.Log.HandleError(err)
+			continue
+		}
+
+		d.Log.Info("stale joiner did not complete the catch up challenge, kicked", logger.ChatID(chatID), logger.UserID(user.ID))
+	}
+
+	err := d.deleteMessageBlocking(&tb.StoredMessage{
+		ChatID:    group.chat.ID,
+		MessageID: strconv.Itoa(group.messageID),
+	})
+	if err != nil {
+		d.Log.HandleError(err)
+	}
+}
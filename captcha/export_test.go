@@ -0,0 +1,9 @@
+package captcha
+
+import "time"
+
+// SetAnswerTimeout lets the tests kick the new members sooner, or later,
+// than Timeout.
+func SetAnswerTimeout(d *Dependencies, timeout time.Duration) {
+	d.answerTimeout = timeout
+}
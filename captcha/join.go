@@ -1,7 +1,6 @@
 package captcha
 
 import (
-	"encoding/json"
 	"html"
 	"strconv"
 	"strings"
@@ -9,6 +8,7 @@ import (
 	"time"
 
 	"captcha-lite/locale"
+	"captcha-lite/logger"
 	"captcha-lite/utils"
 
 	tb "gopkg.in/telebot.v3"
@@ -65,6 +65,7 @@ func (d *Dependencies) CaptchaUserJoin(m *tb.Message) {
 	}
 
 	if m.Sender.IsBot || m.Private() || utils.IsAdmin(admins, m.Sender) {
+		d.Log.Debug("skipping captcha for a bot, an admin or a private chat", logger.ChatID(m.Chat.ID), logger.UserID(m.Sender.ID))
 		return
 	}
 
@@ -116,12 +117,12 @@ SENDMSG_RETRY:
 	}
 
 	// OK. We've sent the question. Now we are going to prepare the data that will
-	// be kept on the in-memory store.
+	// be kept on the store.
 	//
 	// The AdditionalMessages key will be added later when there is an additional message
 	// sent by the bot.
-	captchaData, err := json.Marshal(Captcha{
-		Expiry:     time.Now().Add(Timeout),
+	err = d.Store.Put(m.Sender.ID, Captcha{
+		Expiry:     time.Now().Add(d.answerWithin()),
 		ChatID:     m.Chat.ID,
 		Answer:     randNum,
 		QuestionID: strconv.Itoa(msgQuestion.ID),
@@ -131,21 +132,23 @@ SENDMSG_RETRY:
 		return
 	}
 
-	// Yes, the cache key is their User ID in string format.
-	err = d.Memory.Set(strconv.FormatInt(m.Sender.ID, 10), captchaData)
-	if err != nil {
-		d.Log.HandleBotError(err, d.Bot, m)
-		return
-	}
+	d.Log.Info(
+		"captcha sent to a new member",
+		logger.ChatID(m.Chat.ID),
+		logger.UserID(m.Sender.ID),
+		logger.F("question_id", msgQuestion.ID),
+	)
 
-	err = d.Memory.Append("captcha:users", []byte(";"+strconv.FormatInt(m.Sender.ID, 10)))
-	if err != nil {
-		d.Log.HandleBotError(err, d.Bot, m)
-		return
+	cond := sync.NewCond(&sync.Mutex{})
+	go d.waitOrDelete(m, cond, d.answerWithin())
+}
+
+func (d *Dependencies) answerWithin() time.Duration {
+	if d.answerTimeout <= 0 {
+		return Timeout
 	}
 
-	cond := sync.NewCond(&sync.Mutex{})
-	go d.waitOrDelete(m, cond)
+	return d.answerTimeout
 }
 
 func sanitizeInput(inp string) string {
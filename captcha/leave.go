@@ -1,9 +1,7 @@
 package captcha
 
 import (
-	"encoding/json"
-	"strconv"
-
+	"captcha-lite/logger"
 	"captcha-lite/utils"
 
 	tb "gopkg.in/telebot.v3"
@@ -30,7 +28,7 @@ func (d *Dependencies) CaptchaUserLeave(m *tb.Message) {
 
 	// We need to check if the user is in the captcha:users cache
 	// or not.
-	check, err := userExists(d.Memory, strconv.FormatInt(m.Sender.ID, 10))
+	check, err := d.Store.IsPending(m.Sender.ID)
 	if err != nil {
 		d.Log.HandleBotError(err, d.Bot, m)
 		return
@@ -40,22 +38,22 @@ func (d *Dependencies) CaptchaUserLeave(m *tb.Message) {
 		return
 	}
 
+	d.Log.Info("pending user left the group", logger.ChatID(m.Chat.ID), logger.UserID(m.Sender.ID))
+
 	// OK, they exist in the cache. Now we've got to delete
 	// all the message that we've sent before.
-	data, err := d.Memory.Get(strconv.FormatInt(m.Sender.ID, 10))
+	captcha, ok, err := d.Store.Get(m.Sender.ID)
 	if err != nil {
 		d.Log.HandleBotError(err, d.Bot, m)
 		return
 	}
 
-	var captcha Captcha
-	err = json.Unmarshal(data, &captcha)
-	if err != nil {
-		d.Log.HandleBotError(err, d.Bot, m)
+	if !ok {
+		// They have just answered, left, or been kicked.
 		return
 	}
 
-	err = d.removeUserFromCache(strconv.FormatInt(m.Sender.ID, 10))
+	err = d.Store.Remove(m.Sender.ID)
 	if err != nil {
 		d.Log.HandleBotError(err, d.Bot, m)
 		return
@@ -1,12 +1,12 @@
 package captcha
 
 import (
-	"encoding/json"
 	"strconv"
 	"strings"
 	"time"
 
 	"captcha-lite/locale"
+	"captcha-lite/logger"
 	"captcha-lite/utils"
 
 	tb "gopkg.in/telebot.v3"
@@ -18,7 +18,7 @@ func (d *Dependencies) NonTextListener(m *tb.Message) {
 	// Check if the message author is in the captcha:users list or not
 	// If not, return
 	// If yes, check if the answer is correct or not
-	exists, err := userExists(d.Memory, strconv.FormatInt(m.Sender.ID, 10))
+	exists, err := d.Store.IsPending(m.Sender.ID)
 	if err != nil {
 		d.Log.HandleBotError(err, d.Bot, m)
 		return
@@ -34,19 +34,19 @@ func (d *Dependencies) NonTextListener(m *tb.Message) {
 	//
 	// Get the answer and all the data surrounding captcha from
 	// this specific user ID from the cache.
-	data, err := d.Memory.Get(strconv.FormatInt(m.Sender.ID, 10))
+	captcha, ok, err := d.Store.Get(m.Sender.ID)
 	if err != nil {
 		d.Log.HandleBotError(err, d.Bot, m)
 		return
 	}
 
-	var captcha Captcha
-	err = json.Unmarshal(data, &captcha)
-	if err != nil {
-		d.Log.HandleBotError(err, d.Bot, m)
+	if !ok {
+		// They have just answered, left, or been kicked.
 		return
 	}
 
+	d.Log.Debug("non-text message from a pending user", logger.ChatID(m.Chat.ID), logger.UserID(m.Sender.ID))
+
 	// Check if the answer is a media
 	remainingTime := time.Until(captcha.Expiry)
 	message := strings.NewReplacer(
@@ -0,0 +1,58 @@
+package captcha
+
+import (
+	"sync"
+	"time"
+
+	"captcha-lite/logger"
+
+	tb "gopkg.in/telebot.v3"
+)
+
+// ResumePending starts the timers of the pending captchas that were
+// restored from a snapshot, or left on a shared store by the previous
+// leader, as the timers died with the previous process.
+// The captchas that expired in the meantime are timed out right away.
+func (d *Dependencies) ResumePending() {
+	userIDs, err := d.Store.Pending()
+	if err != nil {
+		d.Log.HandleError(err)
+		return
+	}
+
+	for _, userID := range userIDs {
+		captcha, ok, err := d.Store.Get(userID)
+		if err != nil {
+			d.Log.HandleError(err)
+			continue
+		}
+
+		if !ok {
+			// They have answered, left, or been kicked.
+			continue
+		}
+
+		d.Log.Info(
+			"resuming a restored captcha",
+			logger.ChatID(captcha.ChatID),
+			logger.UserID(userID),
+			logger.F("expiry", captcha.Expiry),
+		)
+
+		go func(captcha Captcha, userID int64) {
+			msgUser := &tb.Message{
+				Chat:   &tb.Chat{ID: captcha.ChatID},
+				Sender: &tb.User{ID: userID},
+			}
+
+			// The kick message needs their name, which is not on the cache.
+			member, err := d.Bot.ChatMemberOf(msgUser.Chat, msgUser.Sender)
+			if err == nil && member.User != nil {
+				msgUser.Sender = member.User
+			}
+
+			cond := sync.NewCond(&sync.Mutex{})
+			d.waitOrDelete(msgUser, cond, time.Until(captcha.Expiry))
+		}(captcha, userID)
+	}
+}
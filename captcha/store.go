@@ -0,0 +1,29 @@
+package captcha
+
+import "time"
+
+// ExpiryGrace is how long the stores keep a pending captcha after it
+// expires, so the timer that kicks the user still finds it when it fires.
+const ExpiryGrace = time.Minute
+
+// Store keeps the pending captchas by the ID of the user that has to solve
+// them, and the index of the users that have one pending.
+type Store interface {
+	// Get returns the pending captcha of the user. The bool is false if
+	// the user has none.
+	Get(userID int64) (Captcha, bool, error)
+	// Put keeps the pending captcha of the user until a while after it
+	// expires, and adds the user to the index.
+	Put(userID int64, captcha Captcha) error
+	// Update changes the pending captcha of the user without losing the
+	// changes that were made at the same time. It returns the updated
+	// captcha, and false if the user has none.
+	Update(userID int64, fn func(captcha *Captcha)) (Captcha, bool, error)
+	// Remove drops the pending captcha of the user, and removes
+	// the user from the index.
+	Remove(userID int64) error
+	// IsPending reports whether the user is on the index.
+	IsPending(userID int64) (bool, error)
+	// Pending returns the users on the index.
+	Pending() ([]int64, error)
+}
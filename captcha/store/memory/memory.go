@@ -0,0 +1,167 @@
+package memory
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"captcha-lite/cache"
+	"captcha-lite/captcha"
+)
+
+// usersKey keeps the index of the users that have a pending captcha,
+// as their IDs each prefixed by a semicolon.
+const usersKey = "captcha:users"
+
+// memoryStore keeps the pending captchas on the in memory cache, keyed by
+// the user ID. It's only shared by the handlers of this process.
+type memoryStore struct {
+	cache cache.Cache
+}
+
+func NewMemoryStore(c cache.Cache) (*memoryStore, error) {
+	if c == nil {
+		return nil, fmt.Errorf("nil cache")
+	}
+
+	return &memoryStore{cache: c}, nil
+}
+
+func (m *memoryStore) Get(userID int64) (captcha.Captcha, bool, error) {
+	value, err := m.cache.Get(strconv.FormatInt(userID, 10))
+	if err != nil {
+		if errors.Is(err, cache.ErrNotFound) {
+			return captcha.Captcha{}, false, nil
+		}
+
+		return captcha.Captcha{}, false, err
+	}
+
+	var c captcha.Captcha
+	err = json.Unmarshal(value, &c)
+	if err != nil {
+		return captcha.Captcha{}, false, err
+	}
+
+	return c, true, nil
+}
+
+func (m *memoryStore) Put(userID int64, c captcha.Captcha) error {
+	value, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+
+	// A captcha that has already expired is still kept for
+	// the grace period, so it can be timed out.
+	ttl := time.Until(c.Expiry)
+	if ttl < 0 {
+		ttl = 0
+	}
+
+	err = m.cache.SetWithTTL(strconv.FormatInt(userID, 10), value, ttl+captcha.ExpiryGrace)
+	if err != nil {
+		return err
+	}
+
+	return m.cache.Update(usersKey, func(users []byte, found bool) ([]byte, error) {
+		if indexed(users, userID) {
+			return users, nil
+		}
+
+		return append(users, []byte(";"+strconv.FormatInt(userID, 10))...), nil
+	})
+}
+
+func (m *memoryStore) Update(userID int64, fn func(captcha *captcha.Captcha)) (captcha.Captcha, bool, error) {
+	var updated captcha.Captcha
+	var ok bool
+
+	err := m.cache.Update(strconv.FormatInt(userID, 10), func(value []byte, found bool) ([]byte, error) {
+		if !found {
+			return nil, nil
+		}
+
+		err := json.Unmarshal(value, &updated)
+		if err != nil {
+			return nil, err
+		}
+
+		fn(&updated)
+		ok = true
+
+		return json.Marshal(updated)
+	})
+	if err != nil || !ok {
+		return captcha.Captcha{}, false, err
+	}
+
+	return updated, true, nil
+}
+
+func (m *memoryStore) Remove(userID int64) error {
+	err := m.cache.Update(usersKey, func(users []byte, found bool) ([]byte, error) {
+		if !found {
+			return nil, nil
+		}
+
+		return []byte(strings.Replace(string(users), ";"+strconv.FormatInt(userID, 10), "", 1)), nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return m.cache.Delete(strconv.FormatInt(userID, 10))
+}
+
+func (m *memoryStore) IsPending(userID int64) (bool, error) {
+	users, err := m.cache.Get(usersKey)
+	if err != nil {
+		if errors.Is(err, cache.ErrNotFound) {
+			return false, nil
+		}
+
+		return false, err
+	}
+
+	return indexed(users, userID), nil
+}
+
+func (m *memoryStore) Pending() ([]int64, error) {
+	users, err := m.cache.Get(usersKey)
+	if err != nil {
+		if errors.Is(err, cache.ErrNotFound) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	var userIDs []int64
+	for _, key := range strings.Split(string(users), ";") {
+		userID, err := strconv.ParseInt(key, 10, 64)
+		if err != nil {
+			// The first one is an empty string.
+			continue
+		}
+
+		userIDs = append(userIDs, userID)
+	}
+
+	return userIDs, nil
+}
+
+// indexed reports whether the user is on the index.
+func indexed(users []byte, userID int64) bool {
+	key := strconv.FormatInt(userID, 10)
+	for _, v := range strings.Split(string(users), ";") {
+		if v == key {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,73 @@
+package memory_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"captcha-lite/cache"
+	"captcha-lite/captcha"
+	"captcha-lite/captcha/store/memory"
+	"captcha-lite/captcha/storetest"
+
+	"github.com/allegro/bigcache/v3"
+)
+
+func TestNewMemoryStore(t *testing.T) {
+	_, err := memory.NewMemoryStore(nil)
+	if err == nil || err.Error() != "nil cache" {
+		t.Errorf("expecting an error of 'nil cache', instead got %v", err)
+	}
+}
+
+func TestStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) captcha.Store {
+		store, err := memory.NewMemoryStore(cache.NewMap())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		return store
+	})
+}
+
+// The cache might be restored from a snapshot of a previous version.
+func TestStore_ExistingKeys(t *testing.T) {
+	db, err := bigcache.New(context.Background(), bigcache.DefaultConfig(time.Hour))
+	if err != nil {
+		t.Fatalf("creating bigcache instance: %s", err.Error())
+	}
+	defer func() {
+		_ = db.Close()
+	}()
+
+	err = db.Set("captcha:users", []byte(";1;2"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	err = db.Set("1", []byte(`{"answer":"1234","chat_id":-100}`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	c, err := cache.NewBigCache(db)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	store, err := memory.NewMemoryStore(c)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	userIDs, err := store.Pending()
+	if err != nil || len(userIDs) != 2 || userIDs[0] != 1 || userIDs[1] != 2 {
+		t.Errorf("expecting users 1 and 2 to be pending, got %v (%v)", userIDs, err)
+	}
+
+	got, ok, err := store.Get(1)
+	if err != nil || !ok || got.Answer != "1234" || got.ChatID != -100 {
+		t.Errorf("unexpected captcha: %+v, %t (%v)", got, ok, err)
+	}
+}
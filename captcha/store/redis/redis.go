@@ -0,0 +1,213 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"captcha-lite/captcha"
+
+	goredis "github.com/redis/go-redis/v9"
+)
+
+const (
+	// keyPrefix prefixes the user ID on the key of their pending captcha.
+	keyPrefix = "captcha:"
+	// pendingKey keeps the index of the users that have a pending captcha,
+	// scored by the time their captcha is dropped.
+	pendingKey = "captcha:pending"
+)
+
+// callTimeout bounds every call to the server, as the handlers
+// have no context of their own.
+const callTimeout = time.Second * 10
+
+// maxUpdateAttempts is how many times Update retries when the captcha
+// is changed by someone else in the middle of it.
+const maxUpdateAttempts = 10
+
+// redisStore keeps the pending captchas on a Redis compatible server, so
+// they are shared by every instance of the bot. The keys expire a while
+// after the captcha does.
+type redisStore struct {
+	client goredis.UniversalClient
+}
+
+func NewRedisStore(client goredis.UniversalClient) (*redisStore, error) {
+	if client == nil {
+		return nil, fmt.Errorf("nil client")
+	}
+
+	return &redisStore{client: client}, nil
+}
+
+func (r *redisStore) Get(userID int64) (captcha.Captcha, bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), callTimeout)
+	defer cancel()
+
+	value, err := r.client.Get(ctx, key(userID)).Bytes()
+	if err != nil {
+		if errors.Is(err, goredis.Nil) {
+			return captcha.Captcha{}, false, nil
+		}
+
+		return captcha.Captcha{}, false, err
+	}
+
+	var c captcha.Captcha
+	err = json.Unmarshal(value, &c)
+	if err != nil {
+		return captcha.Captcha{}, false, err
+	}
+
+	return c, true, nil
+}
+
+func (r *redisStore) Put(userID int64, c captcha.Captcha) error {
+	ctx, cancel := context.WithTimeout(context.Background(), callTimeout)
+	defer cancel()
+
+	value, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+
+	// A captcha that has already expired is still kept for
+	// the grace period, so it can be timed out.
+	ttl := time.Until(c.Expiry)
+	if ttl < 0 {
+		ttl = 0
+	}
+	ttl += captcha.ExpiryGrace
+
+	now := time.Now()
+	_, err = r.client.TxPipelined(ctx, func(pipe goredis.Pipeliner) error {
+		pipe.Set(ctx, key(userID), value, ttl)
+		pipe.ZAdd(ctx, pendingKey, goredis.Z{Score: float64(now.Add(ttl).Unix() + 1), Member: userID})
+		// The index has no expiry of its own, the users whose
+		// captcha was dropped are pruned along the way.
+		pipe.ZRemRangeByScore(ctx, pendingKey, "-inf", "("+strconv.FormatInt(now.Unix(), 10))
+		return nil
+	})
+
+	return err
+}
+
+func (r *redisStore) Update(userID int64, fn func(captcha *captcha.Captcha)) (captcha.Captcha, bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), callTimeout)
+	defer cancel()
+
+	for attempt := 0; attempt < maxUpdateAttempts; attempt++ {
+		var updated captcha.Captcha
+		var ok bool
+
+		// The transaction fails if the captcha was
+		// changed after it was read.
+		err := r.client.Watch(ctx, func(tx *goredis.Tx) error {
+			value, err := tx.Get(ctx, key(userID)).Bytes()
+			if err != nil {
+				if errors.Is(err, goredis.Nil) {
+					return nil
+				}
+
+				return err
+			}
+
+			err = json.Unmarshal(value, &updated)
+			if err != nil {
+				return err
+			}
+
+			fn(&updated)
+
+			value, err = json.Marshal(updated)
+			if err != nil {
+				return err
+			}
+
+			_, err = tx.TxPipelined(ctx, func(pipe goredis.Pipeliner) error {
+				pipe.Set(ctx, key(userID), value, goredis.KeepTTL)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+
+			ok = true
+			return nil
+		}, key(userID))
+		if errors.Is(err, goredis.TxFailedErr) {
+			continue
+		}
+
+		if err != nil || !ok {
+			return captcha.Captcha{}, false, err
+		}
+
+		return updated, true, nil
+	}
+
+	return captcha.Captcha{}, false, fmt.Errorf("updating captcha of user %d: too many concurrent changes", userID)
+}
+
+func (r *redisStore) Remove(userID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), callTimeout)
+	defer cancel()
+
+	_, err := r.client.TxPipelined(ctx, func(pipe goredis.Pipeliner) error {
+		pipe.Del(ctx, key(userID))
+		pipe.ZRem(ctx, pendingKey, userID)
+		return nil
+	})
+
+	return err
+}
+
+func (r *redisStore) IsPending(userID int64) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), callTimeout)
+	defer cancel()
+
+	score, err := r.client.ZScore(ctx, pendingKey, strconv.FormatInt(userID, 10)).Result()
+	if err != nil {
+		if errors.Is(err, goredis.Nil) {
+			return false, nil
+		}
+
+		return false, err
+	}
+
+	// Their captcha was dropped, but the index was not pruned yet.
+	return int64(score) >= time.Now().Unix(), nil
+}
+
+func (r *redisStore) Pending() ([]int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), callTimeout)
+	defer cancel()
+
+	members, err := r.client.ZRangeByScore(ctx, pendingKey, &goredis.ZRangeBy{
+		Min: strconv.FormatInt(time.Now().Unix(), 10),
+		Max: "+inf",
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	userIDs := make([]int64, 0, len(members))
+	for _, member := range members {
+		userID, err := strconv.ParseInt(member, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+
+		userIDs = append(userIDs, userID)
+	}
+
+	return userIDs, nil
+}
+
+func key(userID int64) string {
+	return keyPrefix + strconv.FormatInt(userID, 10)
+}
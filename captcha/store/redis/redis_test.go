@@ -0,0 +1,87 @@
+package redis_test
+
+import (
+	"testing"
+	"time"
+
+	"captcha-lite/captcha"
+	"captcha-lite/captcha/store/redis"
+	"captcha-lite/captcha/storetest"
+
+	"github.com/alicebob/miniredis/v2"
+	goredis "github.com/redis/go-redis/v9"
+)
+
+func newStore(t *testing.T) (captcha.Store, *miniredis.Miniredis) {
+	server := miniredis.RunT(t)
+
+	client := goredis.NewClient(&goredis.Options{Addr: server.Addr()})
+	t.Cleanup(func() {
+		_ = client.Close()
+	})
+
+	store, err := redis.NewRedisStore(client)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	return store, server
+}
+
+func TestNewRedisStore(t *testing.T) {
+	_, err := redis.NewRedisStore(nil)
+	if err == nil || err.Error() != "nil client" {
+		t.Errorf("expecting an error of 'nil client', instead got %v", err)
+	}
+}
+
+func TestStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) captcha.Store {
+		store, _ := newStore(t)
+		return store
+	})
+}
+
+func TestStore_Expiry(t *testing.T) {
+	store, server := newStore(t)
+
+	err := store.Put(1, captcha.Captcha{Answer: "1234", Expiry: time.Now().Add(time.Minute)})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The key lives as long as the captcha, and the grace period.
+	ttl := server.TTL("captcha:1")
+	if ttl <= time.Minute || ttl > time.Minute+captcha.ExpiryGrace {
+		t.Errorf("expecting the key to expire after the captcha, got %s", ttl)
+	}
+
+	// Updating the captcha must not extend it.
+	_, _, err = store.Update(1, func(captcha *captcha.Captcha) {
+		captcha.UserMessages = append(captcha.UserMessages, "11")
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if updated := server.TTL("captcha:1"); updated != ttl {
+		t.Errorf("expecting the TTL to be kept at %s, got %s", ttl, updated)
+	}
+
+	server.FastForward(time.Minute + captcha.ExpiryGrace)
+
+	_, ok, err := store.Get(1)
+	if err != nil || ok {
+		t.Errorf("expecting the captcha to have expired, got %t (%v)", ok, err)
+	}
+
+	// An expired captcha is still kept for the grace period.
+	err = store.Put(2, captcha.Captcha{Answer: "1234", Expiry: time.Now().Add(-time.Hour)})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if ttl := server.TTL("captcha:2"); ttl <= 0 || ttl > captcha.ExpiryGrace {
+		t.Errorf("expecting the key to be kept for the grace period, got %s", ttl)
+	}
+}
@@ -0,0 +1,218 @@
+// Package storetest is the behavioral contract of captcha.Store. Every
+// store runs it from its own tests:
+//
+//	func TestStore(t *testing.T) {
+//		storetest.Run(t, func(t *testing.T) captcha.Store {
+//			// Create an empty store.
+//		})
+//	}
+package storetest
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"captcha-lite/captcha"
+)
+
+// Run runs every test of the contract against the stores created by
+// newStore. It's called once for every test, and must return an empty
+// store.
+func Run(t *testing.T, newStore func(t *testing.T) captcha.Store) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, store captcha.Store)
+	}{
+		{name: "Missing", test: testMissing},
+		{name: "PutAndGet", test: testPutAndGet},
+		{name: "Update", test: testUpdate},
+		{name: "Remove", test: testRemove},
+		{name: "ConcurrentUpdates", test: testConcurrentUpdates},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			test.test(t, newStore(t))
+		})
+	}
+}
+
+// newCaptcha returns a captcha that expires in a minute, in the precision
+// that survives the round trip.
+func newCaptcha(chatID int64) captcha.Captcha {
+	return captcha.Captcha{
+		Answer:     "1234",
+		Expiry:     time.Now().Add(time.Minute).UTC().Truncate(time.Second),
+		ChatID:     chatID,
+		QuestionID: "10",
+	}
+}
+
+func testMissing(t *testing.T, store captcha.Store) {
+	_, ok, err := store.Get(1)
+	if err != nil || ok {
+		t.Errorf("expecting no captcha, got %t (%v)", ok, err)
+	}
+
+	pending, err := store.IsPending(1)
+	if err != nil || pending {
+		t.Errorf("expecting the user not to be pending, got %t (%v)", pending, err)
+	}
+
+	userIDs, err := store.Pending()
+	if err != nil || len(userIDs) != 0 {
+		t.Errorf("expecting no pending users, got %v (%v)", userIDs, err)
+	}
+
+	_, ok, err = store.Update(1, func(captcha *captcha.Captcha) {
+		t.Error("must not be called without a captcha")
+	})
+	if err != nil || ok {
+		t.Errorf("expecting nothing to be updated, got %t (%v)", ok, err)
+	}
+
+	err = store.Remove(1)
+	if err != nil {
+		t.Errorf("expecting removing a missing captcha not to be an error, got %v", err)
+	}
+}
+
+func testPutAndGet(t *testing.T, store captcha.Store) {
+	want := newCaptcha(-100)
+
+	// Putting it twice must not index the user twice.
+	for i := 0; i < 2; i++ {
+		err := store.Put(1, want)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	got, ok, err := store.Get(1)
+	if err != nil || !ok {
+		t.Fatalf("expecting the captcha, got %t (%v)", ok, err)
+	}
+
+	if got.Answer != want.Answer || !got.Expiry.Equal(want.Expiry) || got.ChatID != want.ChatID || got.QuestionID != want.QuestionID {
+		t.Errorf("expecting %+v, got %+v", want, got)
+	}
+
+	pending, err := store.IsPending(1)
+	if err != nil || !pending {
+		t.Errorf("expecting the user to be pending, got %t (%v)", pending, err)
+	}
+
+	err = store.Put(2, newCaptcha(-100))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	userIDs, err := store.Pending()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(userIDs) != 2 {
+		t.Errorf("expecting users 1 and 2 to be pending, got %v", userIDs)
+	}
+}
+
+func testUpdate(t *testing.T, store captcha.Store) {
+	err := store.Put(1, newCaptcha(-100))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	updated, ok, err := store.Update(1, func(captcha *captcha.Captcha) {
+		captcha.UserMessages = append(captcha.UserMessages, "11")
+	})
+	if err != nil || !ok {
+		t.Fatalf("expecting the captcha to be updated, got %t (%v)", ok, err)
+	}
+
+	if len(updated.UserMessages) != 1 || updated.UserMessages[0] != "11" {
+		t.Errorf("expecting the updated captcha to be returned, got %+v", updated)
+	}
+
+	got, _, err := store.Get(1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(got.UserMessages) != 1 || got.Answer != "1234" {
+		t.Errorf("expecting the update to be kept, got %+v", got)
+	}
+}
+
+func testRemove(t *testing.T, store captcha.Store) {
+	for _, userID := range []int64{1, 2} {
+		err := store.Put(userID, newCaptcha(-100))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	err := store.Remove(1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	_, ok, err := store.Get(1)
+	if err != nil || ok {
+		t.Errorf("expecting the captcha to be removed, got %t (%v)", ok, err)
+	}
+
+	pending, err := store.IsPending(1)
+	if err != nil || pending {
+		t.Errorf("expecting the user to be removed from the index, got %t (%v)", pending, err)
+	}
+
+	userIDs, err := store.Pending()
+	if err != nil || len(userIDs) != 1 || userIDs[0] != 2 {
+		t.Errorf("expecting only user 2 to be pending, got %v (%v)", userIDs, err)
+	}
+}
+
+func testConcurrentUpdates(t *testing.T, store captcha.Store) {
+	err := store.Put(1, newCaptcha(-100))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	const writers = 5
+
+	var wg sync.WaitGroup
+	errs := make(chan error, writers)
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(messageID int) {
+			defer wg.Done()
+			_, _, err := store.Update(1, func(captcha *captcha.Captcha) {
+				captcha.AdditionalMessages = append(captcha.AdditionalMessages, strconv.Itoa(messageID))
+			})
+			errs <- err
+		}(i)
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+
+	got, _, err := store.Get(1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// None of the updates may be lost.
+	if len(got.AdditionalMessages) != writers {
+		t.Errorf("expecting %d additional messages, got %v", writers, got.AdditionalMessages)
+	}
+}
@@ -1,23 +1,21 @@
 package captcha
 
 import (
-	"encoding/json"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"captcha-lite/logger"
 	"captcha-lite/utils"
 
-	"github.com/allegro/bigcache/v3"
-	"github.com/pkg/errors"
 	tb "gopkg.in/telebot.v3"
 )
 
 // waitOrDelete will start a timer. If the timer is expired, it will kick the user from the group.
-func (d *Dependencies) waitOrDelete(msgUser *tb.Message, cond *sync.Cond) {
+func (d *Dependencies) waitOrDelete(msgUser *tb.Message, cond *sync.Cond, timeout time.Duration) {
 	// Let's start the timer, shall we?
-	t := time.NewTimer(Timeout)
+	t := time.NewTimer(timeout)
 
 	// We need to wait for the timer to expire.
 	cond.L.Lock()
@@ -28,22 +26,18 @@ func (d *Dependencies) waitOrDelete(msgUser *tb.Message, cond *sync.Cond) {
 		//
 		// If they're still in the cache, we will say goodbye and
 		// kick them from the group.
-		check := cacheExists(d.Memory, strconv.FormatInt(msgUser.Sender.ID, 10))
+		captcha, check, err := d.Store.Get(msgUser.Sender.ID)
+		if err != nil {
+			d.Log.HandleBotError(err, d.Bot, msgUser)
+			break
+		}
 
 		if check {
-			// Fetch the captcha data first
-			var captcha Captcha
-			user, err := d.Memory.Get(strconv.FormatInt(msgUser.Sender.ID, 10))
-			if err != nil {
-				d.Log.HandleBotError(err, d.Bot, msgUser)
-				break
-			}
-
-			err = json.Unmarshal(user, &captcha)
-			if err != nil {
-				d.Log.HandleBotError(err, d.Bot, msgUser)
-				break
-			}
+			d.Log.Info(
+				"user did not complete the captcha, kicking",
+				logger.ChatID(msgUser.Chat.ID),
+				logger.UserID(msgUser.Sender.ID),
+			)
 
 		KICKMSG_RETRY:
 			// Goodbye, user!
@@ -141,8 +135,8 @@ func (d *Dependencies) waitOrDelete(msgUser *tb.Message, cond *sync.Cond) {
 				},
 			)
 
-			err = d.Memory.Delete(strconv.FormatInt(msgUser.Sender.ID, 10))
-			if err != nil && !errors.Is(err, bigcache.ErrEntryNotFound) {
+			err = d.Store.Remove(msgUser.Sender.ID)
+			if err != nil {
 				d.Log.HandleBotError(err, d.Bot, msgUser)
 				break
 			}
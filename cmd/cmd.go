@@ -4,12 +4,15 @@ import (
 	"context"
 	"time"
 
+	"captcha-lite/cache"
 	"captcha-lite/captcha"
+	"captcha-lite/config"
 	"captcha-lite/locale"
 	"captcha-lite/logger"
+	"captcha-lite/notifier"
+	"captcha-lite/telegram"
 	"captcha-lite/underattack"
 
-	"github.com/allegro/bigcache/v3"
 	tb "gopkg.in/telebot.v3"
 )
 
@@ -19,11 +22,13 @@ import (
 // It will spread and use the correct dependencies for
 // each packages on the captcha project.
 type Dependency struct {
-	Memory   *bigcache.BigCache
-	Bot      *tb.Bot
-	Logger   logger.Logger
-	Language string
-	captcha  *captcha.Dependencies
+	Memory cache.Cache
+	// CaptchaStore keeps the pending captchas.
+	CaptchaStore captcha.Store
+	Bot          telegram.Client
+	Logger       logger.Logger
+	Config       config.Config
+	captcha      *captcha.Dependencies
 
 	UnderAttack *underattack.Dependency
 }
@@ -33,34 +38,67 @@ type Dependency struct {
 // into what's needed by each domain.
 func New(deps Dependency) *Dependency {
 	var localeLanguage map[locale.Message]string
-	switch deps.Language {
+	switch deps.Config.Language {
 	case "id":
 		localeLanguage = locale.ID
 	default:
 		localeLanguage = locale.EN
 	}
 
+	// Every HandleBotError will also notify the chat (or the log channel),
+	// with a rate limit so we don't spam the group.
+	log := notifier.New(deps.Bot, localeLanguage, notifier.Config{
+		Target:       notifier.Target(deps.Config.ErrorNotification.Target),
+		LogChannelID: deps.Config.ErrorNotification.LogChannelID,
+		Cooldown:     deps.Config.ErrorNotification.Cooldown,
+		DedupWindow:  deps.Config.ErrorNotification.DedupWindow,
+	}).Wrap(deps.Logger)
+
 	var underAttackDependency *underattack.Dependency = nil
 	if deps.UnderAttack != nil {
 		underAttackDependency = &underattack.Dependency{
 			Datastore: deps.UnderAttack.Datastore,
 			Memory:    deps.Memory,
 			Bot:       deps.Bot,
-			Logger:    deps.Logger,
+			Logger:    log,
 			Locale:    localeLanguage,
+			// The configuration is validated, so it must be a valid action.
+			DefaultAction:      underattack.Action(deps.Config.UnderAttack.Action),
+			RestoreInviteLinks: deps.Config.UnderAttack.RestoreInviteLinks,
+			DefaultTimezone:    deps.Config.UnderAttack.Timezone,
+		}
+
+		if deps.Config.UnderAttack.Auto.Enabled {
+			underAttackDependency.Detector = underattack.NewJoinRateDetector(
+				deps.Config.UnderAttack.Auto.Threshold,
+				deps.Config.UnderAttack.Auto.Window,
+				deps.Config.UnderAttack.Auto.Cooldown,
+			)
 		}
 	}
 	return &Dependency{
+		Memory:       deps.Memory,
+		CaptchaStore: deps.CaptchaStore,
+		Bot:          deps.Bot,
+		Logger:       log,
+		Config:       deps.Config,
 		captcha: &captcha.Dependencies{
-			Memory: deps.Memory,
-			Bot:    deps.Bot,
-			Locale: localeLanguage,
-			Log:    deps.Logger,
+			Store:          deps.CaptchaStore,
+			Bot:            deps.Bot,
+			Locale:         localeLanguage,
+			Log:            log,
+			CatchUpTimeout: deps.Config.StaleUpdate.CatchUpTimeout,
 		},
 		UnderAttack: underAttackDependency,
 	}
 }
 
+// ResumeCaptchas starts the timers of the pending captchas
+// that were restored from a snapshot, or left on a shared store.
+func (d *Dependency) ResumeCaptchas() {
+	d.captcha.ResumePending()
+}
+
 // OnTextHandler handle any incoming text from the group
 func (d *Dependency) OnTextHandler(c tb.Context) error {
 	d.captcha.WaitForAnswer(c.Message())
@@ -72,29 +110,111 @@ func (d *Dependency) OnTextHandler(c tb.Context) error {
 // added by someone else into the group), or they join
 // the group all by themselves.
 func (d *Dependency) OnUserJoinHandler(c tb.Context) error {
-	if d.UnderAttack != nil {
-		// This block will be executed only if the UnderAttack struct is not nil
-		ctx, cancel := context.WithTimeout(context.Background(), time.Second*15)
-		defer cancel()
-
-		underAttack, err := d.UnderAttack.AreWe(ctx, c.Chat().ID)
-		if err != nil {
-			d.Logger.HandleError(err)
-		}
+	if d.actIfUnderAttack(c) {
+		return nil
+	}
 
-		if underAttack {
-			err := c.Bot().Ban(c.Chat(), &tb.ChatMember{User: c.Sender(), RestrictedUntil: tb.Forever()})
-			if err != nil {
-				d.Logger.HandleBotError(err, d.Bot, c.Message())
-			}
-			return nil
-		}
+	// This join might be the one that trips the join rate detector.
+	if d.recordJoin(c) && d.actIfUnderAttack(c) {
+		return nil
 	}
 
 	d.captcha.CaptchaUserJoin(c.Message())
 	return nil
 }
 
+// OnStaleUserJoinHandler handles a user join that arrived way too late,
+// usually after the bot was down. It is called by the stale update
+// middleware instead of OnUserJoinHandler.
+func (d *Dependency) OnStaleUserJoinHandler(c tb.Context) error {
+	if d.actIfUnderAttack(c) {
+		return nil
+	}
+
+	d.captcha.CatchUpUserJoin(c.Message())
+	return nil
+}
+
+// OnCatchUpCallbackHandler handles the button press on the
+// catch up challenge message.
+func (d *Dependency) OnCatchUpCallbackHandler(c tb.Context) error {
+	return d.captcha.CatchUpCallback(c)
+}
+
+// recordJoin feeds the join to the join rate detector of the under attack
+// module. It returns true if the under attack mode has just been turned on.
+func (d *Dependency) recordJoin(c tb.Context) bool {
+	if d.UnderAttack == nil {
+		return false
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*15)
+	defer cancel()
+
+	activated, err := d.UnderAttack.RecordJoin(ctx, c.Chat())
+	if err != nil {
+		d.Logger.HandleError(err)
+	}
+
+	return activated
+}
+
+// actIfUnderAttack applies the under attack action of the group to the
+// new member, if the group is on under attack mode. It returns true if
+// the join has been dealt with.
+func (d *Dependency) actIfUnderAttack(c tb.Context) bool {
+	if d.UnderAttack == nil {
+		return false
+	}
+
+	// This block will be executed only if the UnderAttack struct is not nil
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*15)
+	defer cancel()
+
+	acted, err := d.UnderAttack.ActOnJoin(ctx, c.Chat(), joinedUser(c))
+	if err != nil {
+		d.Logger.HandleBotError(err, d.Bot, c.Message())
+	}
+
+	return acted
+}
+
+// joinedUser returns the member that joined. The sender is whoever added
+// them to the group, which is only the same user if they joined by
+// themselves.
+func joinedUser(c tb.Context) *tb.User {
+	if m := c.Message(); m != nil && m.UserJoined != nil {
+		return m.UserJoined
+	}
+
+	return c.Sender()
+}
+
+// OnChatJoinRequestHandler handles the join requests on the groups
+// that require the admin approval. The request will be declined if
+// the group is on under attack mode, otherwise it's left to the admins.
+func (d *Dependency) OnChatJoinRequestHandler(c tb.Context) error {
+	if d.UnderAttack == nil {
+		return nil
+	}
+
+	request := c.ChatJoinRequest()
+	if request == nil || request.Chat == nil || request.Sender == nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*15)
+	defer cancel()
+
+	_, err := d.UnderAttack.ActOnJoinRequest(ctx, request.Chat, request.Sender)
+	if err != nil {
+		// There is no message to reply to.
+		d.Logger.HandleError(err)
+	}
+
+	return nil
+}
+
 // OnNonTextHandler meant to handle anything else
 // than an incoming text message.
 func (d *Dependency) OnNonTextHandler(c tb.Context) error {
@@ -0,0 +1,133 @@
+package cmd_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"captcha-lite/cache"
+	memorystore "captcha-lite/captcha/store/memory"
+	"captcha-lite/cmd"
+	"captcha-lite/config"
+	"captcha-lite/logger"
+	"captcha-lite/telegram"
+	"captcha-lite/telegram/telegramtest"
+	"captcha-lite/underattack"
+	"captcha-lite/underattack/datastore/memory"
+
+	"github.com/allegro/bigcache/v3"
+	tb "gopkg.in/telebot.v3"
+)
+
+const chatID int64 = -100123
+
+// recorder is a logger.Logger that keeps the errors, as the handlers
+// report them instead of returning them.
+type recorder struct {
+	mu     sync.Mutex
+	errors []error
+}
+
+func (r *recorder) HandleError(e error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.errors = append(r.errors, e)
+}
+
+func (r *recorder) HandleBotError(e error, bot telegram.Client, m *tb.Message) { r.HandleError(e) }
+
+func (r *recorder) Debug(msg string, fields ...logger.Field) {}
+
+func (r *recorder) Info(msg string, fields ...logger.Field) {}
+
+func (r *recorder) Warn(msg string, fields ...logger.Field) {}
+
+func (r *recorder) Error(msg string, fields ...logger.Field) {}
+
+func (r *recorder) Errors() []error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]error(nil), r.errors...)
+}
+
+func TestOnUserJoinHandler_AddedByAnotherUser(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	db, err := bigcache.New(context.Background(), bigcache.DefaultConfig(time.Hour))
+	if err != nil {
+		t.Fatalf("creating bigcache: %s", err.Error())
+	}
+	t.Cleanup(func() { _ = db.Close() })
+
+	log := &recorder{}
+	datastore, err := memory.NewInMemoryDatastore(db, log)
+	if err != nil {
+		t.Fatalf("creating datastore: %s", err.Error())
+	}
+
+	err = datastore.SetUnderAttackStatus(ctx, chatID, true, time.Now().Add(time.Hour), 1, underattack.ActionBan)
+	if err != nil {
+		t.Fatalf("setting under attack status: %s", err.Error())
+	}
+
+	store, err := memorystore.NewMemoryStore(cache.NewMap())
+	if err != nil {
+		t.Fatalf("creating store: %s", err.Error())
+	}
+
+	bot := telegramtest.NewFake()
+	deps := cmd.New(cmd.Dependency{
+		Memory:       cache.NewMap(),
+		CaptchaStore: store,
+		Bot:          bot,
+		Logger:       log,
+		Config:       config.Default(),
+		UnderAttack:  &underattack.Dependency{Datastore: datastore},
+	})
+
+	offline, err := tb.NewBot(tb.Settings{Offline: true})
+	if err != nil {
+		t.Fatalf("creating offline bot: %s", err.Error())
+	}
+
+	adder := &tb.User{ID: 7, FirstName: "Admin"}
+	joiner := &tb.User{ID: 100, FirstName: "Spam"}
+
+	err = deps.OnUserJoinHandler(offline.NewContext(tb.Update{Message: &tb.Message{
+		ID:         1000,
+		Chat:       &tb.Chat{ID: chatID, Type: tb.ChatSuperGroup},
+		Sender:     adder,
+		UserJoined: joiner,
+	}}))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	bans := bot.CallsOf("Ban")
+	if len(bans) != 1 || bans[0].UserID != joiner.ID {
+		t.Fatalf("expecting only the user that joined to be banned, got %v", bot.Calls())
+	}
+
+	for _, user := range []struct {
+		id     int64
+		banned bool
+	}{
+		{id: joiner.ID, banned: true},
+		{id: adder.ID, banned: false},
+	} {
+		_, ok, err := datastore.GetBan(ctx, chatID, user.id)
+		if err != nil {
+			t.Fatalf("getting ban: %s", err.Error())
+		}
+
+		if ok != user.banned {
+			t.Errorf("expecting the ban of %d to be recorded to be %t, got %t", user.id, user.banned, ok)
+		}
+	}
+
+	if errs := log.Errors(); len(errs) != 0 {
+		t.Errorf("expecting no errors, got %v", errs)
+	}
+}
@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"captcha-lite/underattack"
+	"captcha-lite/underattack/datastore/migration"
+)
+
+// MigrateUsage is the usage of the migrate subcommand.
+const MigrateUsage = "usage: captcha-lite migrate up|down|status"
+
+// Migrate runs the migrate subcommand on the datastore of the under attack
+// module. "up" applies every pending migration, "down" rolls back the latest
+// applied migration, and "status" lists the migrations. The progress is
+// written to out.
+func Migrate(ctx context.Context, datastore underattack.Datastore, args []string, out io.Writer) error {
+	if len(args) != 1 {
+		return errors.New(MigrateUsage)
+	}
+
+	migratable, ok := datastore.(interface{ Migrator() *migration.Migrator })
+	if !ok {
+		return errors.New("the datastore has no schema to migrate")
+	}
+
+	migrator := migratable.Migrator()
+
+	switch args[0] {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		for _, m := range applied {
+			fmt.Fprintf(out, "Applied %d: %s\n", m.Version, m.Name)
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if len(applied) == 0 {
+			fmt.Fprintln(out, "The schema is up to date")
+		}
+	case "down":
+		m, ok, err := migrator.Down(ctx)
+		if err != nil {
+			return err
+		}
+
+		if !ok {
+			fmt.Fprintln(out, "There is no applied migration to roll back")
+			return nil
+		}
+
+		fmt.Fprintf(out, "Rolled back %d: %s\n", m.Version, m.Name)
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+		for _, status := range statuses {
+			appliedAt := "pending"
+			if status.Applied {
+				appliedAt = status.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+
+			fmt.Fprintf(w, "%d\t%s\t%s\n", status.Version, status.Name, appliedAt)
+		}
+
+		return w.Flush()
+	default:
+		return errors.New(MigrateUsage)
+	}
+
+	return nil
+}
@@ -0,0 +1,162 @@
+// Package leader elects a single leader among the instances of the bot
+// that share a datastore. Only the leader polls the updates and runs the
+// timers, the others stand by until its lease expires.
+package leader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"captcha-lite/logger"
+)
+
+// ErrLeaseLost is returned by Keep when the lease was taken over, or could
+// not be renewed before it expired.
+var ErrLeaseLost = errors.New("leader lease lost")
+
+// Lease is implemented by the datastores that can hold a lease.
+type Lease interface {
+	// AcquireLease takes the lease with the given name for the holder, if
+	// it's free or expired, or extends it if the holder already has it.
+	// It returns true if the holder has the lease afterwards.
+	AcquireLease(ctx context.Context, name string, holder string, duration time.Duration) (bool, error)
+	// ReleaseLease gives up the lease, if the holder has it.
+	ReleaseLease(ctx context.Context, name string, holder string) error
+}
+
+// Elector campaigns for, and keeps, a single lease.
+type Elector struct {
+	lease    Lease
+	name     string
+	holder   string
+	duration time.Duration
+	logger   logger.Logger
+	// renewedAt is when the lease was last acquired or renewed.
+	renewedAt time.Time
+}
+
+// New creates an Elector for the lease with the given name. The holder
+// must be unique to this instance, and the duration is how long a standby
+// waits for a leader that stopped renewing.
+func New(lease Lease, name string, holder string, duration time.Duration, log logger.Logger) (*Elector, error) {
+	if lease == nil {
+		return nil, fmt.Errorf("nil lease")
+	}
+
+	if log == nil {
+		return nil, fmt.Errorf("nil logger")
+	}
+
+	if name == "" || holder == "" {
+		return nil, fmt.Errorf("empty lease name or holder")
+	}
+
+	if duration <= 0 {
+		return nil, fmt.Errorf("lease duration must be positive")
+	}
+
+	return &Elector{lease: lease, name: name, holder: holder, duration: duration, logger: log}, nil
+}
+
+// Holder returns who this instance is on the lease.
+func (e *Elector) Holder() string {
+	return e.holder
+}
+
+// Campaign blocks until this instance has the lease, or the context is
+// cancelled.
+func (e *Elector) Campaign(ctx context.Context) error {
+	ticker := time.NewTicker(e.interval())
+	defer ticker.Stop()
+
+	for {
+		attemptedAt := time.Now()
+		ok, err := e.lease.AcquireLease(ctx, e.name, e.holder, e.duration)
+		if err != nil {
+			e.logger.HandleError(fmt.Errorf("acquiring leader lease: %w", err))
+		}
+
+		if ok {
+			e.renewedAt = attemptedAt
+			e.logger.Info("became the leader", logger.F("holder", e.holder))
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Keep renews the lease that was acquired by Campaign until the context is
+// cancelled, then releases it so a standby takes over right away. It
+// returns ErrLeaseLost if another instance has taken over, or if the lease
+// could not be renewed before it expired, as another instance might have
+// taken over by then.
+func (e *Elector) Keep(ctx context.Context) error {
+	ticker := time.NewTicker(e.interval())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			// The context is done, but the lease still has to be released.
+			releaseCtx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+			defer cancel()
+
+			err := e.lease.ReleaseLease(releaseCtx, e.name, e.holder)
+			if err != nil {
+				return fmt.Errorf("releasing leader lease: %w", err)
+			}
+
+			return nil
+		case <-ticker.C:
+		}
+
+		// The lease is counted from before the attempt.
+		attemptedAt := time.Now()
+		ok, err := e.lease.AcquireLease(ctx, e.name, e.holder, e.duration)
+		if err != nil {
+			if ctx.Err() != nil {
+				continue
+			}
+
+			e.logger.HandleError(fmt.Errorf("renewing leader lease: %w", err))
+
+			if time.Since(e.renewedAt) >= e.duration {
+				return ErrLeaseLost
+			}
+
+			continue
+		}
+
+		if !ok {
+			return ErrLeaseLost
+		}
+
+		e.renewedAt = attemptedAt
+	}
+}
+
+// interval is how often the lease is renewed, or tried to be acquired.
+// It leaves room for a couple of failed renewals before the lease expires.
+func (e *Elector) interval() time.Duration {
+	return e.duration / 3
+}
+
+// DefaultHolder identifies this instance by the hostname and the process
+// ID, which is unique enough among the replicas.
+func DefaultHolder() string {
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "unknown"
+	}
+
+	return hostname + "-" + strconv.Itoa(os.Getpid())
+}
@@ -0,0 +1,191 @@
+package leader_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"captcha-lite/leader"
+	"captcha-lite/logger/noop"
+)
+
+// lease is a Lease that lives in memory.
+type lease struct {
+	mu        sync.Mutex
+	holder    string
+	expiresAt time.Time
+	// err fails every call if it's set.
+	err error
+}
+
+func (l *lease) AcquireLease(ctx context.Context, name string, holder string, duration time.Duration) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.err != nil {
+		return false, l.err
+	}
+
+	if l.holder == holder || l.holder == "" || time.Now().After(l.expiresAt) {
+		l.holder = holder
+		l.expiresAt = time.Now().Add(duration)
+	}
+
+	return l.holder == holder, nil
+}
+
+func (l *lease) ReleaseLease(ctx context.Context, name string, holder string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.err != nil {
+		return l.err
+	}
+
+	if l.holder == holder {
+		l.holder = ""
+	}
+
+	return nil
+}
+
+func (l *lease) fail(err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.err = err
+}
+
+func (l *lease) steal(holder string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.holder = holder
+	l.expiresAt = time.Now().Add(time.Hour)
+}
+
+func TestNew(t *testing.T) {
+	_, err := leader.New(nil, "bot", "a", time.Second, noop.New())
+	if err == nil || err.Error() != "nil lease" {
+		t.Errorf("expecting an error of 'nil lease', instead got %v", err)
+	}
+
+	_, err = leader.New(&lease{}, "bot", "a", time.Second, nil)
+	if err == nil || err.Error() != "nil logger" {
+		t.Errorf("expecting an error of 'nil logger', instead got %v", err)
+	}
+
+	_, err = leader.New(&lease{}, "bot", "", time.Second, noop.New())
+	if err == nil {
+		t.Error("expecting an error for an empty holder, got nil")
+	}
+
+	_, err = leader.New(&lease{}, "bot", "a", 0, noop.New())
+	if err == nil {
+		t.Error("expecting an error for a zero duration, got nil")
+	}
+}
+
+func TestElector_Failover(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	l := &lease{}
+	duration := time.Millisecond * 300
+
+	primary, err := leader.New(l, "bot", "primary", duration, noop.New())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	standby, err := leader.New(l, "bot", "standby", duration, noop.New())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	err = primary.Campaign(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	primaryCtx, stopPrimary := context.WithCancel(ctx)
+	kept := make(chan error, 1)
+	go func() {
+		kept <- primary.Keep(primaryCtx)
+	}()
+
+	// The standby must wait while the primary keeps renewing.
+	campaignCtx, campaignCancel := context.WithTimeout(ctx, duration*3)
+	err = standby.Campaign(campaignCtx)
+	campaignCancel()
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expecting the standby not to become the leader, got %v", err)
+	}
+
+	// Stepping down releases the lease right away.
+	stopPrimary()
+	err = <-kept
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	campaignCtx, campaignCancel = context.WithTimeout(ctx, duration)
+	err = standby.Campaign(campaignCtx)
+	campaignCancel()
+	if err != nil {
+		t.Errorf("expecting the standby to take over, got %v", err)
+	}
+}
+
+func TestElector_Keep_TakenOver(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	l := &lease{}
+	elector, err := leader.New(l, "bot", "primary", time.Millisecond*300, noop.New())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	err = elector.Campaign(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	l.steal("other")
+
+	err = elector.Keep(ctx)
+	if !errors.Is(err, leader.ErrLeaseLost) {
+		t.Errorf("expecting ErrLeaseLost, got %v", err)
+	}
+}
+
+func TestElector_Keep_Unreachable(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	l := &lease{}
+	elector, err := leader.New(l, "bot", "primary", time.Millisecond*300, noop.New())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	err = elector.Campaign(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	l.fail(errors.New("connection refused"))
+
+	startedAt := time.Now()
+	err = elector.Keep(ctx)
+	if !errors.Is(err, leader.ErrLeaseLost) {
+		t.Errorf("expecting ErrLeaseLost, got %v", err)
+	}
+
+	if time.Since(startedAt) < time.Millisecond*300 {
+		t.Error("expecting the lease to be kept until it expires")
+	}
+}
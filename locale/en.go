@@ -15,9 +15,131 @@ var EN map[Message]string = map[Message]string{
 
 	MessageUnderAttackOnlyAdmin: "Only groups admin that is allowed to execute this command. It is advised to contact them directly.",
 
-	MessageUnderAttackAlreadyEnabled: "Under attack mode is in effect. To stop, send /disableunderattack",
+	MessageUnderAttackAlreadyEnabled: "Under attack mode is in effect. " +
+		"To extend, send /underattack extend 30m. To stop, send /disableunderattack",
 
 	MessageUnderAttackStarting: "This groups is on under attack mode until {{expiresAt}}. " +
-		"Every user that is joining the group will be banned forever. " +
+		"Every user that is joining the group will be {{action}}. " +
 		"To be able to join, wait until under attack mode is finished, or contact group admin.",
+
+	MessageUnderAttackUsage: "Usage:\n" +
+		"/underattack [duration] -- for example: /underattack 2h\n" +
+		"/underattack lockdown [duration] -- only admins can send messages until it ends\n" +
+		"/underattack revokelinks [duration] -- revokes the invite links, and sends a new one to the admins\n" +
+		"/underattack extend <duration> -- for example: /underattack extend 30m\n" +
+		"/underattack action <ban|tempban|kick|restrict|decline|default> -- for example: /underattack action tempban\n" +
+		"/underattack schedule add|list|remove -- turns it on every day within a window\n" +
+		"/underattack timezone [timezone] -- the timezone that the times are shown in\n\n" +
+		"The duration must be between {{min}} and {{max}}.",
+
+	MessageUnderAttackNotEnabled: "Under attack mode is not in effect. To start, send /underattack",
+
+	MessageUnderAttackExtended: "Under attack mode has been extended until {{expiresAt}}.",
+
+	MessageUnderAttackEnded: "Under attack mode has ended. New users can join the group again.",
+
+	MessageUnderAttackAction: "Every user that is joining the group during under attack mode will be {{action}}.",
+
+	MessageUnderAttackActionBan:          "banned forever",
+	MessageUnderAttackActionTemporaryBan: "banned until under attack mode ends",
+	MessageUnderAttackActionKick:         "kicked out",
+	MessageUnderAttackActionRestrict:     "muted until under attack mode ends",
+	MessageUnderAttackActionDecline:      "declined",
+
+	MessageUnderAttackLockdown: "The group is locked down. Only admins can send messages until under attack mode ends.",
+
+	MessageUnderAttackInviteLinksRevoked: "The invite links have been revoked. The admins have been sent a new one privately.",
+
+	MessageUnderAttackInviteLinksNoRight: "I can't revoke the invite links, because I'm not allowed to invite users to this group.",
+
+	MessageUnderAttackInviteLink: "The invite links of {{group}} have been revoked because of under attack mode.\n\n" +
+		"Here is the new invite link, only for the admins: {{link}}",
+
+	MessageUnderAttackScheduled: "This is a scheduled under attack mode.\n\n",
+
+	MessageUnderAttackScheduleUsage: "Usage:\n" +
+		"/underattack schedule add <HH:MM-HH:MM> [timezone] -- for example: /underattack schedule add 00:00-06:00 Asia/Jakarta\n" +
+		"/underattack schedule list\n" +
+		"/underattack schedule remove <id>\n\n" +
+		"Under attack mode will be turned on every day within the window. The timezone defaults to the timezone of the group.",
+
+	MessageUnderAttackScheduleLimit: "A group can only have {{max}} schedules. Remove one first.",
+
+	MessageUnderAttackScheduleAdded: "Under attack mode will be turned on every day at {{schedule}} (schedule #{{id}}).",
+
+	MessageUnderAttackScheduleEmpty: "This group has no under attack schedule.",
+
+	MessageUnderAttackScheduleList: "Under attack schedules:\n\n{{schedules}}",
+
+	MessageUnderAttackScheduleRemoved: "The schedule has been removed.",
+
+	MessageUnderAttackScheduleNotFound: "There is no such schedule on this group. Send /underattack schedule list to see them.",
+
+	MessageUnderAttackStatus: "Under attack mode is in effect.\n\n" +
+		"Turned on by: {{enabledBy}}\n" +
+		"Ends at: {{expiresAt}}\n" +
+		"New users will be: {{action}}\n" +
+		"Banned so far: {{bans}} users",
+
+	MessageUnderAttackStatusAutomatic: "the bot, automatically",
+
+	MessageUnderAttackNotifyUsage: "Usage: /underattacknotify on|off\n\n" +
+		"Get a private message when under attack mode on this group is turned on or off.",
+
+	MessageUnderAttackNotifyOn: "You will get a private message when under attack mode on this group is turned on or off. " +
+		"If you haven't, send /start to me privately, so I'm allowed to message you.",
+
+	MessageUnderAttackNotifyOff: "You won't get a private message about under attack mode on this group anymore.",
+
+	MessageUnderAttackNotifyEnabled: "Under attack mode was turned on at {{group}} by {{user}}. It will end at {{expiresAt}}.",
+
+	MessageUnderAttackNotifyDisabled: "Under attack mode at {{group}} has ended.",
+
+	MessageUnderAttackTimezone: "The times of this group are shown in {{timezone}}. It is {{now}} now.",
+
+	MessageUnderAttackTimezoneUsage: "Usage:\n" +
+		"/underattack timezone [timezone] -- for example: /underattack timezone Asia/Jakarta\n\n" +
+		"The timezone must be an IANA timezone name, such as Asia/Jakarta or Europe/London.",
+
+	MessageAttackBans: "Users banned during under attack mode ({{total}} users, page {{page}} of {{pages}}):\n\n{{bans}}\n\n" +
+		"To unban, send /attackunban <user id>, or /attackunban all to unban everyone.",
+
+	MessageAttackBansEmpty: "Nobody has been banned during under attack mode.",
+
+	MessageAttackBansPrevious: "« Previous",
+	MessageAttackBansNext:     "Next »",
+
+	MessageAttackUnbanUsage: "Usage:\n" +
+		"/attackunban <user id> -- for example: /attackunban 123456789\n" +
+		"/attackunban all -- unbans everyone that was banned during under attack mode",
+
+	MessageAttackUnbanned: "{{count}} users have been unbanned. They can join the group again.",
+
+	MessageAttackUnbanNotFound: "The user was not banned during under attack mode. Send /attackbans to see who was.",
+
+	MessageUnderAttackAutomatic: "Too many users have joined in a short time ({{joins}} users in {{window}}), " +
+		"so under attack mode was turned on automatically.\n\n",
+
+	MessageUnderAttackAutomaticAdmin: "Under attack mode was turned on automatically on {{group}}, " +
+		"because {{joins}} users joined within {{window}}. It will end at {{expiresAt}}.\n\n" +
+		"To stop it early, send /disableunderattack on the group.",
+
+	MessageCatchUp: "Sorry, I was away for a while and couldn't greet you properly.\n\n{{users}}\n\n" +
+		"Please press the button below within {{minutes}} minutes to prove that you're a human, " +
+		"or you will be kicked.",
+
+	MessageCatchUpButton: "I'm a human",
+
+	MessageCatchUpNotForYou: "This button is not for you.",
+
+	MessageCatchUpVerified: "Thank you! You can chat now.",
+
+	MessageSomethingWentWrong: "Oh no, something went wrong with me! Can you guys help me to ping my masters?",
+
+	MessageErrorLogChannel: "Something went wrong on <b>{{group}}</b> (<code>{{chatID}}</code>).\n\n" +
+		"Class: <code>{{class}}</code>\n<pre>{{error}}</pre>",
+
+	MessageTimeLayout:     "15:04 MST",
+	MessageDateTimeLayout: "2 {{month}} 15:04 MST",
+	MessageMonths:         "Jan Feb Mar Apr May Jun Jul Aug Sep Oct Nov Dec",
 }
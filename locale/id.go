@@ -20,9 +20,131 @@ var ID map[Message]string = map[Message]string{
 	MessageUnderAttackOnlyAdmin: "Hanya admin grup yang dapat menjalankan command ini. " +
 		"Sebaiknya kamu hubungi admin yang bersangkutan.",
 
-	MessageUnderAttackAlreadyEnabled: "Mode under attack sudah menyala. Untuk memeatikan, kirim /disableunderattack",
+	MessageUnderAttackAlreadyEnabled: "Mode under attack sudah menyala. " +
+		"Untuk memperpanjang, kirim /underattack extend 30m. Untuk mematikan, kirim /disableunderattack",
 
 	MessageUnderAttackStarting: "Grup ini dalam kondisi under attack sampai pukul {{expiresAt}}. " +
-		"Semua yang baru masuk ke grup ini akan langsung di ban selamanya." +
+		"Semua yang baru masuk ke grup ini akan langsung {{action}}. " +
 		"Untuk bisa bergabung, tunggu sampai mode under attack berakhir, atau hubungi admin.",
+
+	MessageUnderAttackUsage: "Cara pakai:\n" +
+		"/underattack [durasi] -- contoh: /underattack 2h\n" +
+		"/underattack lockdown [durasi] -- hanya admin yang bisa mengirim pesan sampai berakhir\n" +
+		"/underattack revokelinks [durasi] -- mencabut link undangan, dan mengirim yang baru ke admin\n" +
+		"/underattack extend <durasi> -- contoh: /underattack extend 30m\n" +
+		"/underattack action <ban|tempban|kick|restrict|decline|default> -- contoh: /underattack action tempban\n" +
+		"/underattack schedule add|list|remove -- menyalakannya setiap hari di antara waktu tertentu\n" +
+		"/underattack timezone [zona waktu] -- zona waktu yang dipakai untuk menampilkan waktu\n\n" +
+		"Durasi harus di antara {{min}} dan {{max}}.",
+
+	MessageUnderAttackNotEnabled: "Mode under attack sedang tidak menyala. Untuk menyalakan, kirim /underattack",
+
+	MessageUnderAttackExtended: "Mode under attack diperpanjang sampai pukul {{expiresAt}}.",
+
+	MessageUnderAttackEnded: "Mode under attack telah berakhir. Anggota baru sudah bisa bergabung lagi.",
+
+	MessageUnderAttackAction: "Semua yang masuk ke grup ini selama mode under attack akan {{action}}.",
+
+	MessageUnderAttackActionBan:          "di ban selamanya",
+	MessageUnderAttackActionTemporaryBan: "di ban sampai mode under attack berakhir",
+	MessageUnderAttackActionKick:         "dikeluarkan",
+	MessageUnderAttackActionRestrict:     "dibisukan sampai mode under attack berakhir",
+	MessageUnderAttackActionDecline:      "ditolak",
+
+	MessageUnderAttackLockdown: "Grup ini dikunci. Hanya admin yang bisa mengirim pesan sampai mode under attack berakhir.",
+
+	MessageUnderAttackInviteLinksRevoked: "Link undangan sudah dicabut. Link yang baru sudah dikirim ke admin secara pribadi.",
+
+	MessageUnderAttackInviteLinksNoRight: "Aku tidak bisa mencabut link undangan, karena aku tidak diizinkan mengundang anggota ke grup ini.",
+
+	MessageUnderAttackInviteLink: "Link undangan {{group}} sudah dicabut karena mode under attack.\n\n" +
+		"Ini link undangan yang baru, khusus untuk admin: {{link}}",
+
+	MessageUnderAttackScheduled: "Ini adalah mode under attack terjadwal.\n\n",
+
+	MessageUnderAttackScheduleUsage: "Cara pakai:\n" +
+		"/underattack schedule add <HH:MM-HH:MM> [zona waktu] -- contoh: /underattack schedule add 00:00-06:00 Asia/Jakarta\n" +
+		"/underattack schedule list\n" +
+		"/underattack schedule remove <id>\n\n" +
+		"Mode under attack akan dinyalakan setiap hari di antara waktu tersebut. Zona waktu bawaannya mengikuti zona waktu grup.",
+
+	MessageUnderAttackScheduleLimit: "Satu grup hanya bisa punya {{max}} jadwal. Hapus salah satunya dulu.",
+
+	MessageUnderAttackScheduleAdded: "Mode under attack akan dinyalakan setiap hari pukul {{schedule}} (jadwal #{{id}}).",
+
+	MessageUnderAttackScheduleEmpty: "Grup ini tidak punya jadwal mode under attack.",
+
+	MessageUnderAttackScheduleList: "Jadwal mode under attack:\n\n{{schedules}}",
+
+	MessageUnderAttackScheduleRemoved: "Jadwal sudah dihapus.",
+
+	MessageUnderAttackScheduleNotFound: "Jadwal tersebut tidak ada di grup ini. Kirim /underattack schedule list untuk melihatnya.",
+
+	MessageUnderAttackStatus: "Mode under attack sedang menyala.\n\n" +
+		"Dinyalakan oleh: {{enabledBy}}\n" +
+		"Berakhir pukul: {{expiresAt}}\n" +
+		"Yang baru masuk akan: {{action}}\n" +
+		"Sudah di ban: {{bans}} orang",
+
+	MessageUnderAttackStatusAutomatic: "bot, secara otomatis",
+
+	MessageUnderAttackNotifyUsage: "Cara pakai: /underattacknotify on|off\n\n" +
+		"Dapatkan pesan pribadi saat mode under attack di grup ini dinyalakan atau dimatikan.",
+
+	MessageUnderAttackNotifyOn: "Kamu akan mendapat pesan pribadi saat mode under attack di grup ini dinyalakan atau dimatikan. " +
+		"Kalau belum, kirim /start ke aku secara pribadi, supaya aku bisa mengirim pesan ke kamu.",
+
+	MessageUnderAttackNotifyOff: "Kamu tidak akan mendapat pesan pribadi tentang mode under attack di grup ini lagi.",
+
+	MessageUnderAttackNotifyEnabled: "Mode under attack di {{group}} dinyalakan oleh {{user}}. Mode ini akan berakhir pukul {{expiresAt}}.",
+
+	MessageUnderAttackNotifyDisabled: "Mode under attack di {{group}} sudah berakhir.",
+
+	MessageUnderAttackTimezone: "Waktu di grup ini ditampilkan dalam zona waktu {{timezone}}. Sekarang pukul {{now}}.",
+
+	MessageUnderAttackTimezoneUsage: "Cara pakai:\n" +
+		"/underattack timezone [zona waktu] -- contoh: /underattack timezone Asia/Jakarta\n\n" +
+		"Zona waktu harus berupa nama zona waktu IANA, misalnya Asia/Jakarta atau Asia/Makassar.",
+
+	MessageAttackBans: "Daftar yang di ban selama mode under attack ({{total}} orang, halaman {{page}} dari {{pages}}):\n\n{{bans}}\n\n" +
+		"Untuk membatalkan ban, kirim /attackunban <user id>, atau /attackunban all untuk semuanya.",
+
+	MessageAttackBansEmpty: "Belum ada yang di ban selama mode under attack.",
+
+	MessageAttackBansPrevious: "« Sebelumnya",
+	MessageAttackBansNext:     "Berikutnya »",
+
+	MessageAttackUnbanUsage: "Cara pakai:\n" +
+		"/attackunban <user id> -- contoh: /attackunban 123456789\n" +
+		"/attackunban all -- membatalkan ban semua yang di ban selama mode under attack",
+
+	MessageAttackUnbanned: "Ban untuk {{count}} orang sudah dibatalkan. Mereka bisa bergabung lagi.",
+
+	MessageAttackUnbanNotFound: "Orang itu tidak di ban selama mode under attack. Kirim /attackbans untuk melihat siapa saja yang di ban.",
+
+	MessageUnderAttackAutomatic: "Terlalu banyak yang bergabung dalam waktu singkat ({{joins}} orang dalam {{window}}), " +
+		"jadi mode under attack dinyalakan secara otomatis.\n\n",
+
+	MessageUnderAttackAutomaticAdmin: "Mode under attack dinyalakan secara otomatis di {{group}}, " +
+		"karena ada {{joins}} orang yang bergabung dalam {{window}}. Mode ini akan berakhir pukul {{expiresAt}}.\n\n" +
+		"Untuk mematikan lebih awal, kirim /disableunderattack di grup.",
+
+	MessageCatchUp: "Maaf, aku sempat pergi sebentar dan tidak bisa menyambut kalian dengan benar.\n\n{{users}}\n\n" +
+		"Tekan tombol di bawah dalam {{minutes}} menit untuk membuktikan kalau kamu manusia, " +
+		"atau kamu akan di kick.",
+
+	MessageCatchUpButton: "Aku manusia",
+
+	MessageCatchUpNotForYou: "Tombol ini bukan untukmu.",
+
+	MessageCatchUpVerified: "Terima kasih! Sekarang kamu sudah bisa chat.",
+
+	MessageSomethingWentWrong: "Aduh, ada yang salah sama aku! Tolong bantu panggilin masterku ya?",
+
+	MessageErrorLogChannel: "Terjadi kesalahan di <b>{{group}}</b> (<code>{{chatID}}</code>).\n\n" +
+		"Kelas: <code>{{class}}</code>\n<pre>{{error}}</pre>",
+
+	MessageTimeLayout:     "15.04 MST",
+	MessageDateTimeLayout: "2 {{month}} 15.04 MST",
+	MessageMonths:         "Jan Feb Mar Apr Mei Jun Jul Agu Sep Okt Nov Des",
 }
@@ -1,5 +1,10 @@
 package locale
 
+import (
+	"strings"
+	"time"
+)
+
 type Message int
 
 const (
@@ -14,4 +19,80 @@ const (
 	MessageUnderAttackOnlyAdmin
 	MessageUnderAttackAlreadyEnabled
 	MessageUnderAttackStarting
+	MessageUnderAttackAutomatic
+	MessageUnderAttackAutomaticAdmin
+	MessageUnderAttackUsage
+	MessageUnderAttackNotEnabled
+	MessageUnderAttackExtended
+	MessageUnderAttackEnded
+	MessageUnderAttackAction
+	MessageUnderAttackActionBan
+	MessageUnderAttackActionTemporaryBan
+	MessageUnderAttackActionKick
+	MessageUnderAttackActionRestrict
+	MessageUnderAttackActionDecline
+	MessageUnderAttackLockdown
+	MessageUnderAttackInviteLinksRevoked
+	MessageUnderAttackInviteLinksNoRight
+	MessageUnderAttackInviteLink
+	MessageUnderAttackScheduled
+	MessageUnderAttackScheduleUsage
+	MessageUnderAttackScheduleLimit
+	MessageUnderAttackScheduleAdded
+	MessageUnderAttackScheduleEmpty
+	MessageUnderAttackScheduleList
+	MessageUnderAttackScheduleRemoved
+	MessageUnderAttackScheduleNotFound
+	MessageUnderAttackStatus
+	MessageUnderAttackStatusAutomatic
+	MessageUnderAttackNotifyUsage
+	MessageUnderAttackNotifyOn
+	MessageUnderAttackNotifyOff
+	MessageUnderAttackNotifyEnabled
+	MessageUnderAttackNotifyDisabled
+	MessageUnderAttackTimezone
+	MessageUnderAttackTimezoneUsage
+
+	// MessageAttackBans represent the list of users banned during the under attack mode
+	MessageAttackBans
+	MessageAttackBansEmpty
+	MessageAttackBansPrevious
+	MessageAttackBansNext
+	MessageAttackUnbanUsage
+	MessageAttackUnbanned
+	MessageAttackUnbanNotFound
+
+	// MessageCatchUp represent the catch up mode for stale user joins
+	MessageCatchUp
+	MessageCatchUpButton
+	MessageCatchUpNotForYou
+	MessageCatchUpVerified
+
+	// MessageSomethingWentWrong is sent to the group when a handler fails.
+	MessageSomethingWentWrong
+	// MessageErrorLogChannel is sent to the admin log channel when a handler fails.
+	MessageErrorLogChannel
+
+	// MessageTimeLayout represent how the times are shown to the users,
+	// see FormatTime.
+	MessageTimeLayout
+	MessageDateTimeLayout
+	MessageMonths
 )
+
+// FormatTime formats the time with the layouts of the language. The date
+// is only shown if withDate is true. The {{month}} on the layout is replaced
+// with the month name of the language, since time.Format only knows English.
+func FormatTime(language map[Message]string, t time.Time, withDate bool) string {
+	layout := language[MessageTimeLayout]
+	if withDate {
+		layout = language[MessageDateTimeLayout]
+	}
+
+	month := t.Format("Jan")
+	if months := strings.Fields(language[MessageMonths]); len(months) == 12 {
+		month = months[t.Month()-1]
+	}
+
+	return strings.Replace(t.Format(layout), "{{month}}", month, 1)
+}
@@ -0,0 +1,38 @@
+package locale_test
+
+import (
+	"testing"
+	"time"
+
+	"captcha-lite/locale"
+)
+
+func TestFormatTime(t *testing.T) {
+	jakarta, err := time.LoadLocation("Asia/Jakarta")
+	if err != nil {
+		t.Skipf("timezone database is not available: %v", err)
+	}
+
+	at := time.Date(2023, time.May, 2, 14, 30, 0, 0, jakarta)
+
+	tests := []struct {
+		name     string
+		language map[locale.Message]string
+		withDate bool
+		want     string
+	}{
+		{name: "en", language: locale.EN, want: "14:30 WIB"},
+		{name: "en with date", language: locale.EN, withDate: true, want: "2 May 14:30 WIB"},
+		{name: "id", language: locale.ID, want: "14.30 WIB"},
+		{name: "id with date", language: locale.ID, withDate: true, want: "2 Mei 14.30 WIB"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := locale.FormatTime(test.language, at, test.withDate)
+			if got != test.want {
+				t.Errorf("expecting %q, got %q", test.want, got)
+			}
+		})
+	}
+}
@@ -1,6 +1,8 @@
 package logger
 
 import (
+	"captcha-lite/telegram"
+
 	tb "gopkg.in/telebot.v3"
 )
 
@@ -12,5 +14,53 @@ type Logger interface {
 	//
 	// For other errors that don't have one of those struct instance, use
 	// HandleError instead.
-	HandleBotError(e error, bot *tb.Bot, m *tb.Message)
+	//
+	// It only reports the error. Telling the chat that something went
+	// wrong is the job of the notifier package.
+	HandleBotError(e error, bot telegram.Client, m *tb.Message)
+
+	// Debug logs the flow of the application that is only useful
+	// when you're hunting down a bug.
+	Debug(msg string, fields ...Field)
+	// Info logs notable events, such as a user passing the captcha
+	// or the under attack mode being toggled.
+	Info(msg string, fields ...Field)
+	// Warn logs something that is not right, but the application
+	// can still continue.
+	Warn(msg string, fields ...Field)
+	// Error logs a failure that is not represented by an error value.
+	// If you have an error value, use HandleError instead.
+	Error(msg string, fields ...Field)
+}
+
+// Field is a key-value context that is attached to a log entry.
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// F creates a new Field.
+func F(key string, value interface{}) Field {
+	return Field{Key: key, Value: value}
+}
+
+// ChatID creates the "chat_id" Field.
+func ChatID(id int64) Field {
+	return F("chat_id", id)
+}
+
+// UserID creates the "user_id" Field.
+func UserID(id int64) Field {
+	return F("user_id", id)
+}
+
+// FieldsToMap converts the slice of Field into a map, which
+// most of the third party SDK accepts.
+func FieldsToMap(fields []Field) map[string]interface{} {
+	m := make(map[string]interface{}, len(fields))
+	for _, field := range fields {
+		m[field.Key] = field.Value
+	}
+
+	return m
 }
@@ -0,0 +1,208 @@
+// Package multi provides a logger.Logger that dispatches every call
+// to several providers at once, for example Sentry for the errors and
+// zerolog for the log shipper.
+//
+// Each provider has its own queue and worker goroutine. A provider that
+// panics will be recovered, and a provider that blocks will only fill up
+// its own queue. Once a queue is full, new entries for that provider are
+// dropped instead of blocking the caller or the other providers.
+package multi
+
+import (
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"captcha-lite/logger"
+	"captcha-lite/telegram"
+
+	tb "gopkg.in/telebot.v3"
+)
+
+// QueueSize is the number of pending entries each provider can hold.
+const QueueSize = 256
+
+// CloseTimeout is how long Close waits for the providers to finish
+// their queue.
+const CloseTimeout = 10 * time.Second
+
+// Provider is a named logger.Logger. The name is only used to identify
+// the provider when something goes wrong.
+type Provider struct {
+	Name   string
+	Logger logger.Logger
+}
+
+type provider struct {
+	name   string
+	logger logger.Logger
+	queue  chan func(logger.Logger)
+	// done is closed once the worker has finished the queue.
+	done chan struct{}
+}
+
+type Config struct {
+	providers []*provider
+	mu        sync.RWMutex
+	closed    bool
+}
+
+// New creates a fan-out logger for the given providers.
+func New(providers ...Provider) *Config {
+	if len(providers) == 0 {
+		panic("multi.New: no provider")
+	}
+
+	c := &Config{}
+	for _, pr := range providers {
+		if pr.Logger == nil {
+			panic("multi.New: provider " + pr.Name + " is nil")
+		}
+
+		p := &provider{
+			name:   pr.Name,
+			logger: pr.Logger,
+			queue:  make(chan func(logger.Logger), QueueSize),
+			done:   make(chan struct{}),
+		}
+		c.providers = append(c.providers, p)
+
+		go c.work(p)
+	}
+
+	return c
+}
+
+func (c *Config) work(p *provider) {
+	defer close(p.done)
+
+	for fn := range p.queue {
+		p.call(fn)
+	}
+}
+
+// call executes fn, recovering from any panic so the worker can
+// carry on with the next entry.
+func (p *provider) call(fn func(logger.Logger)) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("multi: provider %s panicked: %v", p.name, r)
+		}
+	}()
+
+	fn(p.logger)
+}
+
+func (c *Config) dispatch(fn func(logger.Logger)) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.closed {
+		return
+	}
+
+	for _, p := range c.providers {
+		select {
+		case p.queue <- fn:
+		default:
+			log.Printf("multi: queue for provider %s is full, dropping log entry", p.name)
+		}
+	}
+}
+
+// Close stops accepting new entries and waits for every provider
+// to finish its queue, for up to CloseTimeout.
+func (c *Config) Close() {
+	c.CloseWithin(CloseTimeout)
+}
+
+// CloseWithin stops accepting new entries and waits for every provider
+// to finish its queue, for up to the timeout. A provider that is blocked,
+// for example flushing to a server that doesn't answer, is left behind
+// along with the rest of its queue. It returns the names of those.
+func (c *Config) CloseWithin(timeout time.Duration) []string {
+	c.mu.Lock()
+	if !c.closed {
+		c.closed = true
+		for _, p := range c.providers {
+			close(p.queue)
+		}
+	}
+	c.mu.Unlock()
+
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+
+	var dropped []string
+	expired := false
+	for _, p := range c.providers {
+		if !expired {
+			select {
+			case <-p.done:
+				continue
+			case <-deadline.C:
+				// The rest are only checked from now on.
+				expired = true
+			}
+		}
+
+		select {
+		case <-p.done:
+		default:
+			dropped = append(dropped, p.name)
+		}
+	}
+
+	if len(dropped) > 0 {
+		log.Printf("multi: providers %s did not finish their queue in %s, dropping them", strings.Join(dropped, ", "), timeout)
+	}
+
+	return dropped
+}
+
+// HandleError handles common errors.
+func (c *Config) HandleError(e error) {
+	c.dispatch(func(l logger.Logger) {
+		l.HandleError(e)
+	})
+}
+
+// HandleBotError is the handler for an error which a function has a
+// bot and a message instance.
+//
+// For other errors that don't have one of those struct instance, use
+// HandleError instead.
+func (c *Config) HandleBotError(e error, bot telegram.Client, m *tb.Message) {
+	c.dispatch(func(l logger.Logger) {
+		l.HandleBotError(e, bot, m)
+	})
+}
+
+// Debug logs the message on debug level to every provider.
+func (c *Config) Debug(msg string, fields ...logger.Field) {
+	c.dispatch(func(l logger.Logger) {
+		l.Debug(msg, fields...)
+	})
+}
+
+// Info logs the message on info level to every provider.
+func (c *Config) Info(msg string, fields ...logger.Field) {
+	c.dispatch(func(l logger.Logger) {
+		l.Info(msg, fields...)
+	})
+}
+
+// Warn logs the message on warn level to every provider.
+func (c *Config) Warn(msg string, fields ...logger.Field) {
+	c.dispatch(func(l logger.Logger) {
+		l.Warn(msg, fields...)
+	})
+}
+
+// Error logs the message on error level to every provider.
+func (c *Config) Error(msg string, fields ...logger.Field) {
+	c.dispatch(func(l logger.Logger) {
+		l.Error(msg, fields...)
+	})
+}
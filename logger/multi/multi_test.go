@@ -0,0 +1,142 @@
+package multi_test
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"captcha-lite/logger"
+	"captcha-lite/logger/multi"
+	"captcha-lite/telegram"
+
+	tb "gopkg.in/telebot.v3"
+)
+
+type recorder struct {
+	mu     sync.Mutex
+	errors []error
+	infos  []string
+}
+
+func (r *recorder) HandleError(e error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.errors = append(r.errors, e)
+}
+
+func (r *recorder) HandleBotError(e error, bot telegram.Client, m *tb.Message) { r.HandleError(e) }
+
+func (r *recorder) Debug(msg string, fields ...logger.Field) {}
+
+func (r *recorder) Info(msg string, fields ...logger.Field) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.infos = append(r.infos, msg)
+}
+
+func (r *recorder) Warn(msg string, fields ...logger.Field) {}
+
+func (r *recorder) Error(msg string, fields ...logger.Field) {}
+
+type panicking struct{ recorder }
+
+func (p *panicking) HandleError(e error) { panic("boom") }
+
+type blocking struct {
+	recorder
+	release chan struct{}
+}
+
+func (b *blocking) HandleError(e error) { <-b.release }
+
+func TestMulti(t *testing.T) {
+	healthy := &recorder{}
+	blocked := &blocking{release: make(chan struct{})}
+
+	m := multi.New(
+		multi.Provider{Name: "panicking", Logger: &panicking{}},
+		multi.Provider{Name: "blocking", Logger: blocked},
+		multi.Provider{Name: "healthy", Logger: healthy},
+	)
+
+	done := make(chan struct{})
+	go func() {
+		// Overflow the queue of the blocking provider, this must not block.
+		for i := 0; i < multi.QueueSize*2; i++ {
+			m.HandleError(errors.New("something"))
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second * 5):
+		t.Fatal("dispatching blocked on a stuck provider")
+	}
+
+	// Wait for the healthy provider to drain its queue.
+	previous := -1
+	for i := 0; i < 100; i++ {
+		healthy.mu.Lock()
+		n := len(healthy.errors)
+		healthy.mu.Unlock()
+		if n > 0 && n == previous {
+			break
+		}
+		previous = n
+		time.Sleep(time.Millisecond * 20)
+	}
+
+	m.Info("hello")
+
+	close(blocked.release)
+	m.Close()
+
+	// Calling after Close must not panic.
+	m.HandleError(errors.New("after close"))
+
+	healthy.mu.Lock()
+	defer healthy.mu.Unlock()
+
+	if len(healthy.errors) == 0 {
+		t.Error("expecting the healthy provider to receive errors, got none")
+	}
+
+	if len(healthy.infos) != 1 || healthy.infos[0] != "hello" {
+		t.Errorf("expecting a single 'hello' info, got %v", healthy.infos)
+	}
+}
+
+func TestCloseWithin(t *testing.T) {
+	healthy := &recorder{}
+	blocked := &blocking{release: make(chan struct{})}
+	defer close(blocked.release)
+
+	m := multi.New(
+		multi.Provider{Name: "blocking", Logger: blocked},
+		multi.Provider{Name: "healthy", Logger: healthy},
+	)
+	m.HandleError(errors.New("something"))
+
+	done := make(chan []string)
+	go func() {
+		done <- m.CloseWithin(time.Millisecond * 100)
+	}()
+
+	select {
+	case dropped := <-done:
+		if len(dropped) != 1 || dropped[0] != "blocking" {
+			t.Errorf("expecting only the blocking provider to be dropped, got %v", dropped)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("closing blocked on a stuck provider")
+	}
+
+	healthy.mu.Lock()
+	defer healthy.mu.Unlock()
+
+	if len(healthy.errors) != 1 {
+		t.Errorf("expecting the healthy provider to finish its queue, got %d errors", len(healthy.errors))
+	}
+}
@@ -1,6 +1,9 @@
 package noop
 
 import (
+	"captcha-lite/logger"
+	"captcha-lite/telegram"
+
 	tb "gopkg.in/telebot.v3"
 )
 
@@ -20,6 +23,18 @@ func (c *Config) HandleError(e error) {
 //
 // For other errors that don't have one of those struct instance, use
 // HandleError instead.
-func (c *Config) HandleBotError(e error, bot *tb.Bot, m *tb.Message) {
+func (c *Config) HandleBotError(e error, bot telegram.Client, m *tb.Message) {
 	return
 }
+
+// Debug logs nothing.
+func (c *Config) Debug(msg string, fields ...logger.Field) {}
+
+// Info logs nothing.
+func (c *Config) Info(msg string, fields ...logger.Field) {}
+
+// Warn logs nothing.
+func (c *Config) Warn(msg string, fields ...logger.Field) {}
+
+// Error logs nothing.
+func (c *Config) Error(msg string, fields ...logger.Field) {}
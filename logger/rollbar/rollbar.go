@@ -2,7 +2,9 @@ package rollbar
 
 import (
 	"log"
-	"os"
+
+	"captcha-lite/logger"
+	"captcha-lite/telegram"
 
 	"github.com/pkg/errors"
 	rb "github.com/rollbar/rollbar-go"
@@ -25,7 +27,7 @@ func New(client *rb.Client) *Config {
 
 // HandleError handles common errors
 func (c *Config) HandleError(e error) {
-	if os.Getenv("ENVIRONMENT") == "development" {
+	if c.Client.Environment() == "development" {
 		log.Println(e)
 	}
 
@@ -37,20 +39,11 @@ func (c *Config) HandleError(e error) {
 //
 // For other errors that don't have one of those struct instance, use
 // HandleError instead.
-func (c *Config) HandleBotError(e error, bot *tb.Bot, m *tb.Message) {
-	if os.Getenv("ENVIRONMENT") == "development" {
+func (c *Config) HandleBotError(e error, bot telegram.Client, m *tb.Message) {
+	if c.Client.Environment() == "development" {
 		log.Println(e)
 	}
 
-	_, err := bot.Send(
-		m.Chat,
-		"Oh no, something went wrong with me! Can you guys help me to ping my masters?",
-		&tb.SendOptions{ParseMode: tb.ModeHTML},
-	)
-	if err != nil {
-		c.Client.ErrorWithLevel(rb.ERR, errors.WithStack(err))
-	}
-
 	c.Client.ErrorWithExtras(rb.ERR, errors.WithStack(e), map[string]interface{}{
 		"sender:id":       m.Sender.ID,
 		"sender:name":     m.Sender.FirstName + " " + m.Sender.LastName,
@@ -60,3 +53,36 @@ func (c *Config) HandleBotError(e error, bot *tb.Bot, m *tb.Message) {
 		"message:unix":    m.Unixtime,
 	})
 }
+
+// Debug records the message as a telemetry event on debug level.
+func (c *Config) Debug(msg string, fields ...logger.Field) {
+	c.captureTelemetry(rb.DEBUG, msg, fields)
+}
+
+// Info records the message as a telemetry event on info level.
+func (c *Config) Info(msg string, fields ...logger.Field) {
+	c.captureTelemetry(rb.INFO, msg, fields)
+}
+
+// Warn records the message as a telemetry event on warning level.
+func (c *Config) Warn(msg string, fields ...logger.Field) {
+	c.captureTelemetry(rb.WARN, msg, fields)
+}
+
+// Error records the message as a telemetry event on error level.
+func (c *Config) Error(msg string, fields ...logger.Field) {
+	c.captureTelemetry(rb.ERR, msg, fields)
+}
+
+// captureTelemetry records the log, which will be sent along with
+// the next reported error. It's the Rollbar equivalent of a breadcrumb.
+func (c *Config) captureTelemetry(level string, msg string, fields []logger.Field) {
+	if c.Client.Environment() == "development" {
+		log.Println(msg, fields)
+	}
+
+	data := logger.FieldsToMap(fields)
+	data["message"] = msg
+
+	c.Client.CaptureTelemetryEvent("log", level, data)
+}
@@ -2,7 +2,11 @@ package sentry
 
 import (
 	"log"
-	"os"
+	"sync"
+	"time"
+
+	"captcha-lite/logger"
+	"captcha-lite/telegram"
 
 	tb "gopkg.in/telebot.v3"
 
@@ -10,8 +14,30 @@ import (
 	"github.com/pkg/errors"
 )
 
+const (
+	// defaultMaxBreadcrumbs and maxBreadcrumbs are the same
+	// as the ones that the sentry hub uses.
+	defaultMaxBreadcrumbs = 30
+	maxBreadcrumbs        = 100
+	// scopeIdleTimeout is how long the breadcrumbs of a chat are kept
+	// after the last one was recorded.
+	scopeIdleTimeout = 10 * time.Minute
+)
+
 type Config struct {
 	Client *sentry.Client
+
+	mu sync.Mutex
+	// scopes hold the breadcrumbs by the chat that they were recorded
+	// on, which will be attached to the next captured exception of
+	// the same chat. The ones without a chat are kept on zero.
+	scopes    map[int64]*chatScope
+	lastPrune time.Time
+}
+
+type chatScope struct {
+	scope    *sentry.Scope
+	lastUsed time.Time
 }
 
 func New(client *sentry.Client) *Config {
@@ -21,19 +47,20 @@ func New(client *sentry.Client) *Config {
 
 	return &Config{
 		Client: client,
+		scopes: make(map[int64]*chatScope),
 	}
 }
 
 // HandleError handles common errors.
 func (c *Config) HandleError(e error) {
-	if os.Getenv("ENVIRONMENT") == "development" {
+	if c.Client.Options().Environment == "development" {
 		log.Println(e)
 	}
 
 	_ = c.Client.CaptureException(
 		errors.WithStack(e),
 		&sentry.EventHint{OriginalException: e},
-		nil,
+		c.scopeOf(0),
 	)
 }
 
@@ -42,18 +69,17 @@ func (c *Config) HandleError(e error) {
 //
 // For other errors that don't have one of those struct instance, use
 // HandleError instead.
-func (c *Config) HandleBotError(e error, bot *tb.Bot, m *tb.Message) {
-	if os.Getenv("ENVIRONMENT") == "development" {
+func (c *Config) HandleBotError(e error, bot telegram.Client, m *tb.Message) {
+	if c.Client.Options().Environment == "development" {
 		log.Println(e)
 	}
 
-	_, err := bot.Send(
-		m.Chat,
-		"Oh no, something went wrong with me! Can you guys help me to ping my masters?",
-		&tb.SendOptions{ParseMode: tb.ModeHTML},
-	)
+	var chatID int64
+	if m.Chat != nil {
+		chatID = m.Chat.ID
+	}
 
-	scope := sentry.NewScope()
+	scope := c.scopeOf(chatID)
 	scope.SetContext("tg:sender", map[string]interface{}{
 		"id":       m.Sender.ID,
 		"name":     m.Sender.FirstName + " " + m.Sender.LastName,
@@ -65,18 +91,105 @@ func (c *Config) HandleBotError(e error, bot *tb.Bot, m *tb.Message) {
 		"unix": m.Unixtime,
 	})
 
-	if err != nil {
-		// Come on? Another error?
-		_ = c.Client.CaptureException(
-			errors.WithStack(err),
-			&sentry.EventHint{OriginalException: err},
-			scope,
-		)
-	}
-
 	_ = c.Client.CaptureException(
 		errors.WithStack(e),
 		&sentry.EventHint{OriginalException: e},
 		scope,
 	)
 }
+
+// Debug records the message as a breadcrumb on debug level.
+func (c *Config) Debug(msg string, fields ...logger.Field) {
+	c.addBreadcrumb(sentry.LevelDebug, msg, fields)
+}
+
+// Info records the message as a breadcrumb on info level.
+func (c *Config) Info(msg string, fields ...logger.Field) {
+	c.addBreadcrumb(sentry.LevelInfo, msg, fields)
+}
+
+// Warn records the message as a breadcrumb on warning level.
+func (c *Config) Warn(msg string, fields ...logger.Field) {
+	c.addBreadcrumb(sentry.LevelWarning, msg, fields)
+}
+
+// Error records the message as a breadcrumb on error level.
+func (c *Config) Error(msg string, fields ...logger.Field) {
+	c.addBreadcrumb(sentry.LevelError, msg, fields)
+}
+
+func (c *Config) addBreadcrumb(level sentry.Level, msg string, fields []logger.Field) {
+	if c.Client.Options().Environment == "development" {
+		log.Println(msg, fields)
+	}
+
+	// Same as the sentry hub, a negative limit disables the breadcrumbs.
+	limit := c.Client.Options().MaxBreadcrumbs
+	if limit < 0 {
+		return
+	}
+
+	if limit == 0 {
+		limit = defaultMaxBreadcrumbs
+	} else if limit > maxBreadcrumbs {
+		limit = maxBreadcrumbs
+	}
+
+	var chatID int64
+	for _, field := range fields {
+		if id, ok := field.Value.(int64); ok && field.Key == "chat_id" {
+			chatID = id
+			break
+		}
+	}
+
+	now := time.Now()
+
+	c.mu.Lock()
+	c.prune(now)
+	s, ok := c.scopes[chatID]
+	if !ok {
+		s = &chatScope{scope: sentry.NewScope()}
+		c.scopes[chatID] = s
+	}
+	s.lastUsed = now
+	c.mu.Unlock()
+
+	s.scope.AddBreadcrumb(&sentry.Breadcrumb{
+		Type:     "default",
+		Category: "log",
+		Message:  msg,
+		Data:     logger.FieldsToMap(fields),
+		Level:    level,
+	}, limit)
+}
+
+// scopeOf returns a copy of the scope that holds the breadcrumbs of the chat,
+// so every captured exception has its own.
+func (c *Config) scopeOf(chatID int64) *sentry.Scope {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s, ok := c.scopes[chatID]
+	if !ok {
+		return sentry.NewScope()
+	}
+
+	return s.scope.Clone()
+}
+
+// prune drops the breadcrumbs of the chats that have been idle for a while.
+// It only runs once in a while, so it doesn't scan every chat on every log.
+// c.mu must be held.
+func (c *Config) prune(now time.Time) {
+	if now.Sub(c.lastPrune) < scopeIdleTimeout {
+		return
+	}
+	c.lastPrune = now
+
+	for chatID, s := range c.scopes {
+		if now.Sub(s.lastUsed) >= scopeIdleTimeout {
+			delete(c.scopes, chatID)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package sentry_test
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"captcha-lite/logger"
+	sentrylogger "captcha-lite/logger/sentry"
+
+	"github.com/getsentry/sentry-go"
+	tb "gopkg.in/telebot.v3"
+)
+
+type transport struct {
+	mu     sync.Mutex
+	events []*sentry.Event
+}
+
+func (t *transport) Flush(timeout time.Duration) bool { return true }
+
+func (t *transport) Configure(options sentry.ClientOptions) {}
+
+func (t *transport) SendEvent(event *sentry.Event) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.events = append(t.events, event)
+}
+
+func TestBreadcrumbs(t *testing.T) {
+	tr := &transport{}
+	client, err := sentry.NewClient(sentry.ClientOptions{Transport: tr})
+	if err != nil {
+		t.Fatalf("creating client: %s", err.Error())
+	}
+
+	log := sentrylogger.New(client)
+	log.Info("first chat", logger.ChatID(1))
+	log.Info("second chat", logger.ChatID(2))
+	log.Debug("no chat")
+
+	log.HandleBotError(errors.New("boom"), nil, &tb.Message{
+		Chat:   &tb.Chat{ID: 1},
+		Sender: &tb.User{ID: 3},
+	})
+	log.HandleError(errors.New("boom"))
+
+	if len(tr.events) != 2 {
+		t.Fatalf("expecting 2 events, got %d", len(tr.events))
+	}
+
+	for i, expected := range []string{"first chat", "no chat"} {
+		breadcrumbs := tr.events[i].Breadcrumbs
+		if len(breadcrumbs) != 1 || breadcrumbs[0].Message != expected {
+			t.Errorf("expecting only the %q breadcrumb on event %d, got %v", expected, i, breadcrumbs)
+		}
+	}
+}
@@ -3,6 +3,9 @@ package rollbar
 import (
 	"fmt"
 
+	"captcha-lite/logger"
+	"captcha-lite/telegram"
+
 	"github.com/rs/zerolog"
 	tb "gopkg.in/telebot.v3"
 )
@@ -52,17 +55,7 @@ func (c *Config) HandleError(e error) {
 //
 // For other errors that don't have one of those struct instance, use
 // HandleError instead.
-func (c *Config) HandleBotError(e error, bot *tb.Bot, m *tb.Message) {
-	_, err := bot.Send(
-		m.Chat,
-		"Oh no, something went wrong with me! Can you guys help me to ping my masters?",
-		&tb.SendOptions{ParseMode: tb.ModeHTML},
-	)
-
-	if err != nil {
-		c.Log.Error().Err(err).Stack().Msg("")
-	}
-
+func (c *Config) HandleBotError(e error, bot telegram.Client, m *tb.Message) {
 	s := sender{
 		Id:       m.Sender.ID,
 		Name:     fmt.Sprintf("%s %s", m.Sender.FirstName, m.Sender.LastName),
@@ -83,3 +76,36 @@ func (c *Config) HandleBotError(e error, bot *tb.Bot, m *tb.Message) {
 		Object("message", msg).
 		Msg("")
 }
+
+// Debug logs the message on debug level.
+func (c *Config) Debug(msg string, fields ...logger.Field) {
+	c.write(c.Log.Debug(), msg, fields)
+}
+
+// Info logs the message on info level.
+func (c *Config) Info(msg string, fields ...logger.Field) {
+	c.write(c.Log.Info(), msg, fields)
+}
+
+// Warn logs the message on warn level.
+func (c *Config) Warn(msg string, fields ...logger.Field) {
+	c.write(c.Log.Warn(), msg, fields)
+}
+
+// Error logs the message on error level.
+func (c *Config) Error(msg string, fields ...logger.Field) {
+	c.write(c.Log.Error(), msg, fields)
+}
+
+func (c *Config) write(e *zerolog.Event, msg string, fields []logger.Field) {
+	// The event is nil if the level is disabled.
+	if e == nil {
+		return
+	}
+
+	for _, field := range fields {
+		e = e.Interface(field.Key, field.Value)
+	}
+
+	e.Msg(msg)
+}
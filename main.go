@@ -18,30 +18,51 @@ import (
 	"context"
 	"database/sql"
 	"flag"
+	"fmt"
 	"io"
 	"log"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
+	// Embeds the timezone database, as the container image doesn't have
+	// one, and the under attack schedules need it.
+	_ "time/tzdata"
 
 	// Internals
+	"captcha-lite/cache"
+	"captcha-lite/captcha"
+	memorystore "captcha-lite/captcha/store/memory"
+	redisstore "captcha-lite/captcha/store/redis"
 	"captcha-lite/cmd"
+	"captcha-lite/config"
+	"captcha-lite/leader"
 	"captcha-lite/logger"
+	"captcha-lite/logger/multi"
 	"captcha-lite/logger/noop"
 	rollbarlogger "captcha-lite/logger/rollbar"
 	sentrylogger "captcha-lite/logger/sentry"
 	zerologlogger "captcha-lite/logger/zerolog"
+	"captcha-lite/middleware"
+	"captcha-lite/snapshot"
+	"captcha-lite/telegram"
 	"captcha-lite/underattack"
 	"captcha-lite/underattack/datastore/memory"
 	"captcha-lite/underattack/datastore/mysql"
 	"captcha-lite/underattack/datastore/postgres"
+	redisdatastore "captcha-lite/underattack/datastore/redis"
+	"captcha-lite/underattack/datastore/sqlite"
 
 	// Database and cache
 	"github.com/allegro/bigcache/v3"
 	_ "github.com/go-sql-driver/mysql"
 	_ "github.com/lib/pq"
+	goredis "github.com/redis/go-redis/v9"
 	"github.com/rollbar/rollbar-go"
+	_ "modernc.org/sqlite"
 
 	// Others third party stuff
 	"github.com/getsentry/sentry-go"
@@ -51,35 +72,92 @@ import (
 	tb "gopkg.in/telebot.v3"
 )
 
-// This init function checks if there's any configuration
-// missing from the .env file.
+// This init function makes sure every time that we're dealing
+// with is in UTC, unless being told otherwise.
 func init() {
-	env := os.Getenv("ENVIRONMENT")
-	if env == "" {
-		log.Fatal("Please provide the ENVIRONMENT value on the .env file")
-	}
-
-	token := os.Getenv("BOT_TOKEN")
-	if token == "" {
-		log.Fatal("Please provide the BOT_TOKEN value on the .env file")
-	}
-
 	if os.Getenv("TZ") == "" {
 		err := os.Setenv("TZ", "UTC")
 		if err != nil {
 			log.Fatalln("during setting TZ environment variable:", err)
 		}
 	}
-
-	log.Println("Passed the environment variable check")
 }
 
 func main() {
+	configPath := flag.String("config", os.Getenv("CONFIG_FILE"), "Path to the YAML or TOML configuration file")
+	printConfig := flag.Bool("print-config", false, "Print the effective configuration with secrets redacted, then exit")
 	// Feature flags
 	experimentalUnderAttack := flag.Bool("experimental-underattack", false, "Enable the experimental under attack module")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] [migrate up|down|status]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	configuration, err := config.Load(*configPath)
+	if err != nil {
+		log.Fatal("during loading configuration: ", err)
+	}
+
+	if *experimentalUnderAttack {
+		configuration.UnderAttack.Enabled = true
+	}
+
+	if *printConfig {
+		fmt.Print(configuration.String())
+
+		err := configuration.Validate()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "\nConfiguration is invalid:\n%s\n", err.Error())
+			os.Exit(1)
+		}
+
+		return
+	}
+
+	err = configuration.Validate()
+	if err != nil {
+		log.Fatal("Invalid configuration:\n", err)
+	}
+
+	log.Println("Passed the configuration check")
+
+	// Every in memory cache that is saved to the snapshot directory,
+	// if there is one.
+	type snapshotTarget struct {
+		cache *bigcache.BigCache
+		path  string
+		// prepare, if not nil, is called before every save.
+		prepare func() error
+	}
+	var snapshotTargets []snapshotTarget
+
+	if configuration.Snapshot.Directory != "" {
+		err := os.MkdirAll(configuration.Snapshot.Directory, 0o700)
+		if err != nil {
+			log.Fatalf("Creating the snapshot directory: %s", err.Error())
+		}
+	}
+
+	// restoreSnapshot fills the cache from its snapshot, and keeps
+	// it to be saved later.
+	restoreSnapshot := func(cache *bigcache.BigCache, name string, maxAge time.Duration, prepare func() error) {
+		if configuration.Snapshot.Directory == "" {
+			return
+		}
+
+		path := filepath.Join(configuration.Snapshot.Directory, name)
+		restored, err := snapshot.Restore(cache, path, maxAge)
+		if err != nil {
+			log.Fatalf("Restoring the snapshot %s: %s", path, err.Error())
+		}
+
+		log.Printf("Restored %d entries from %s", restored, path)
+		snapshotTargets = append(snapshotTargets, snapshotTarget{cache: cache, path: path, prepare: prepare})
+	}
 
 	// Setup in memory cache
-	cache, err := bigcache.New(context.Background(), bigcache.Config{
+	cacheConfig := bigcache.Config{
 		Shards:             1024,
 		LifeWindow:         time.Minute * 5,
 		CleanWindow:        time.Minute * 1,
@@ -87,93 +165,103 @@ func main() {
 		HardMaxCacheSize:   1024 * 1024 * 1024,
 		MaxEntrySize:       500,
 		MaxEntriesInWindow: 50,
-	})
+	}
+	bigCache, err := bigcache.New(context.Background(), cacheConfig)
 	if err != nil {
 		log.Fatal("during creating a in memory cache:", errors.WithStack(err))
 	}
-	defer func(cache *bigcache.BigCache) {
-		err := cache.Close()
+	restoreSnapshot(bigCache, "captcha.snapshot", cacheConfig.LifeWindow, nil)
+	defer func(bigCache *bigcache.BigCache) {
+		err := bigCache.Close()
 		if err != nil {
 			log.Fatal(errors.WithStack(err))
 		}
-	}(cache)
+	}(bigCache)
+
+	memoryCache, err := cache.NewBigCache(bigCache)
+	if err != nil {
+		log.Fatal("during creating a in memory cache:", errors.WithStack(err))
+	}
 
 	// Setup logger client
-	var loggerClient logger.Logger
+	var loggerProviders []multi.Provider
+
+	for _, provider := range configuration.Log.Providers() {
+		var providerClient logger.Logger
+
+		switch provider {
+		case "noop":
+			providerClient = noop.New()
+		case "sentry":
+			// Setup Sentry for error handling.
+			sentryClient, err := sentry.NewClient(sentry.ClientOptions{
+				Dsn:              configuration.Log.Sentry.DSN,
+				AttachStacktrace: true,
+				Debug:            configuration.Environment == "development",
+				Environment:      configuration.Environment,
+			})
+			if err != nil {
+				log.Fatal("during initiating a new sentry client:", errors.WithStack(err))
+			}
+			defer sentryClient.Flush(5 * time.Second)
+
+			providerClient = sentrylogger.New(sentryClient)
+		case "rollbar":
+			providerClient = rollbarlogger.New(
+				rollbar.New(
+					configuration.Log.Rollbar.Token,
+					configuration.Environment,
+					"1.0.0",
+					configuration.Log.Rollbar.ServerHost,
+					configuration.Log.Rollbar.ServerRoot,
+				),
+			)
+		case "zerolog":
+			var out io.WriteCloser
+			switch configuration.Log.Zerolog.Output {
+			case "STDOUT":
+				out = os.Stdout
+			case "STDERR":
+				fallthrough
+			default:
+				out = os.Stderr
+			}
 
-	logProvider, ok := os.LookupEnv("LOG_PROVIDER")
-	if !ok {
-		logProvider = "noop"
-	}
+			level, err := zerolog.ParseLevel(configuration.Log.Level)
+			if err != nil {
+				log.Fatal("during parsing log level:", errors.WithStack(err))
+			}
 
-	switch strings.ToLower(logProvider) {
-	case "noop":
-		loggerClient = noop.New()
-	case "sentry":
-		// Setup Sentry for error handling.
-		sentryClient, err := sentry.NewClient(sentry.ClientOptions{
-			Dsn:              os.Getenv("SENTRY_DSN"),
-			AttachStacktrace: true,
-			Debug:            os.Getenv("ENVIRONMENT") == "development",
-			Environment:      os.Getenv("ENVIRONMENT"),
-		})
-		if err != nil {
-			log.Fatal("during initiating a new sentry client:", errors.WithStack(err))
-		}
-		defer sentryClient.Flush(5 * time.Second)
-
-		loggerClient = sentrylogger.New(sentryClient)
-	case "rollbar":
-		loggerClient = rollbarlogger.New(
-			rollbar.New(
-				os.Getenv("ROLLBAR_TOKEN"),
-				os.Getenv("ENVIRONMENT"),
-				"1.0.0",
-				os.Getenv("ROLLBAR_SERVERHOST"),
-				os.Getenv("ROLLBAR_SERVERROOT"),
-			),
-		)
-	case "zerolog":
-		var out io.WriteCloser
-		switch os.Getenv("ZEROLOG_OUTPUT") {
-		case "STDOUT":
-			out = os.Stdout
-		case "STDERR":
-			fallthrough
+			zerologLogger := zerolog.New(out).Level(level).With().Timestamp().Logger()
+			providerClient = zerologlogger.New(zerologLogger)
 		default:
-			out = os.Stderr
+			providerClient = noop.New()
 		}
 
-		zerologLogger := zerolog.New(out)
-		loggerClient = zerologlogger.New(zerologLogger)
-	default:
-		loggerClient = noop.New()
+		loggerProviders = append(loggerProviders, multi.Provider{Name: provider, Logger: providerClient})
 	}
 
-	var underAttackModule *underattack.Dependency = nil
-	if *experimentalUnderAttack {
-		underAttackDatastoreProvider, ok := os.LookupEnv("UNDER_ATTACK_DATASTORE_PROVIDER")
-		if !ok {
-			underAttackDatastoreProvider = "memory"
-		}
+	var loggerClient logger.Logger
+	if len(loggerProviders) == 1 {
+		loggerClient = loggerProviders[0].Logger
+	} else {
+		// Sending to several providers at once. This is deferred after
+		// the Sentry flush above, so it will be executed before it.
+		multiLogger := multi.New(loggerProviders...)
+		defer multiLogger.Close()
+
+		loggerClient = multiLogger
+	}
 
-		underAttackDatastoreDSN, ok := os.LookupEnv("UNDER_ATTACK_DATASTORE_DSN")
-		if !ok {
-			underAttackDatastoreDSN = ""
-		}
+	var underAttackModule *underattack.Dependency = nil
+	if configuration.UnderAttack.Enabled {
+		underAttackDatastoreProvider := configuration.UnderAttack.Datastore.Provider
+		underAttackDatastoreDSN := configuration.UnderAttack.Datastore.DSN
 
 		var underAttackDatastore underattack.Datastore = nil
 
-		switch strings.ToLower(underAttackDatastoreProvider) {
-		case "pgsql":
-			fallthrough
-		case "postgresql":
-			fallthrough
+		switch underAttackDatastoreProvider {
 		case "postgres":
-			if underAttackDatastoreDSN == "" {
-				log.Fatalf("Empty UNDER_ATTACK_DATASTORE_DSN for provider: %s", underAttackDatastoreProvider)
-			}
-
 			db, err := sql.Open("postgres", underAttackDatastoreDSN)
 			if err != nil {
 				log.Fatalf("Creating connection to PostgreSQL: %s", err.Error())
@@ -184,10 +272,6 @@ func main() {
 				log.Fatalf("Creating NewPostgresDatastore: %s", err.Error())
 			}
 		case "mysql":
-			if underAttackDatastoreDSN == "" {
-				log.Fatalf("Empty UNDER_ATTACK_DATASTORE_DSN for provider: %s", underAttackDatastoreProvider)
-			}
-
 			db, err := sql.Open("mysql", underAttackDatastoreDSN)
 			if err != nil {
 				log.Fatalf("Creating connection to PostgreSQL: %s", err.Error())
@@ -197,28 +281,119 @@ func main() {
 			if err != nil {
 				log.Fatalf("Creating NewPostgresDatastore: %s", err.Error())
 			}
+		case "sqlite":
+			db, err := sql.Open("sqlite", underAttackDatastoreDSN)
+			if err != nil {
+				log.Fatalf("Creating connection to SQLite: %s", err.Error())
+			}
+
+			underAttackDatastore, err = sqlite.NewSQLiteDatastore(db, loggerClient)
+			if err != nil {
+				log.Fatalf("Creating NewSQLiteDatastore: %s", err.Error())
+			}
+		case "redis":
+			redisOptions, err := goredis.ParseURL(underAttackDatastoreDSN)
+			if err != nil {
+				log.Fatalf("Parsing the Redis URL: %s", err.Error())
+			}
+
+			underAttackDatastore, err = redisdatastore.NewRedisDatastore(goredis.NewClient(redisOptions), loggerClient)
+			if err != nil {
+				log.Fatalf("Creating NewRedisDatastore: %s", err.Error())
+			}
 		case "memory":
-			db, err := bigcache.New(context.Background(), bigcache.DefaultConfig(time.Hour*24))
+			dbConfig := bigcache.DefaultConfig(time.Hour * 24)
+			db, err := bigcache.New(context.Background(), dbConfig)
 			if err != nil {
 				log.Fatalf("Creating in memory store: %s", err.Error())
 			}
+			// The datastore writes its records to the cache right
+			// before it is saved, it's only created once restored.
+			var persist func() error
+			// Not on the migrate subcommand, it would be
+			// saved without ever being used. The entries and the records
+			// never expire, so nothing is skipped by age. The attacks that
+			// ended while the bot was down are ended by the expiry worker.
+			if flag.Arg(0) != "migrate" {
+				restoreSnapshot(db, "underattack.snapshot", 0, func() error { return persist() })
+			}
 
-			underAttackDatastore, err = memory.NewInMemoryDatastore(db, loggerClient)
+			memoryDatastore, err := memory.NewInMemoryDatastore(db, loggerClient)
 			if err != nil {
 				log.Fatalf("Creating NewInMemoryDatastore: %s", err.Error())
 			}
+			persist = memoryDatastore.Persist
+			underAttackDatastore = memoryDatastore
 		default:
 			log.Fatalf("Unknown under attack datastore provider: %s", underAttackDatastoreProvider)
 		}
 
+		// The migrate subcommand runs the migrations on its own terms.
+		if flag.Arg(0) != "migrate" && configuration.UnderAttack.Datastore.AutoMigrate {
+			migrateCtx, migrateCancel := context.WithTimeout(context.Background(), time.Minute*5)
+			err := underAttackDatastore.Migrate(migrateCtx)
+			migrateCancel()
+			if err != nil {
+				log.Fatalf("Migrating the under attack datastore: %s", err.Error())
+			}
+		}
+
 		underAttackModule = &underattack.Dependency{
 			Datastore: underAttackDatastore,
 		}
 	}
 
+	if flag.Arg(0) == "migrate" {
+		if underAttackModule == nil {
+			log.Fatal("The under attack module is not enabled, there is nothing to migrate")
+		}
+
+		migrateCtx, migrateCancel := context.WithTimeout(context.Background(), time.Minute*5)
+		err := cmd.Migrate(migrateCtx, underAttackModule.Datastore, flag.Args()[1:], os.Stdout)
+		migrateCancel()
+		if err != nil {
+			log.Fatalf("Migrating the under attack datastore: %s", err.Error())
+		}
+
+		err = underAttackModule.Datastore.Close()
+		if err != nil {
+			log.Printf("Error during closing datastore connection: %s", err.Error())
+		}
+
+		return
+	}
+
+	// Setup the store of the pending captchas
+	var captchaStore captcha.Store
+	switch configuration.Captcha.Store {
+	case "redis":
+		redisOptions, err := goredis.ParseURL(configuration.Captcha.RedisURL)
+		if err != nil {
+			log.Fatalf("Parsing the captcha Redis URL: %s", err.Error())
+		}
+
+		redisClient := goredis.NewClient(redisOptions)
+		defer func() {
+			err := redisClient.Close()
+			if err != nil {
+				log.Printf("Error during closing captcha Redis connection: %s", err.Error())
+			}
+		}()
+
+		captchaStore, err = redisstore.NewRedisStore(redisClient)
+		if err != nil {
+			log.Fatalf("Creating NewRedisStore: %s", err.Error())
+		}
+	default:
+		captchaStore, err = memorystore.NewMemoryStore(memoryCache)
+		if err != nil {
+			log.Fatalf("Creating NewMemoryStore: %s", err.Error())
+		}
+	}
+
 	// Setup Telegram Bot
 	b, err := tb.NewBot(tb.Settings{
-		Token:  os.Getenv("BOT_TOKEN"),
+		Token:  configuration.BotToken,
 		Poller: &tb.LongPoller{Timeout: 10 * time.Second},
 		OnError: func(err error, ctx tb.Context) {
 			if strings.Contains(err.Error(), "Conflict: terminated by other getUpdates request") {
@@ -232,37 +407,53 @@ func main() {
 	if err != nil {
 		log.Fatal("during init of bot client:", errors.WithStack(err))
 	}
-	defer b.Stop()
 
-	// Setup language
-	language, ok := os.LookupEnv("LANGUAGE")
-	if !ok {
-		language = "en"
+	botClient, err := telegram.NewClient(b)
+	if err != nil {
+		log.Fatalf("Creating telegram NewClient: %s", err.Error())
 	}
 
-	// This is for recovering from panic.
+	// This is for recovering from panic on the main goroutine.
+	// Panics on the handlers are recovered by the middleware below.
 	defer func() {
 		r := recover()
 		if r != nil {
-			loggerClient.HandleError(err)
+			panicErr, ok := r.(error)
+			if !ok {
+				panicErr = fmt.Errorf("%v", r)
+			}
+
+			loggerClient.HandleError(errors.Wrap(panicErr, "recovered from panic"))
 
-			log.Println(r.(error))
+			log.Println(panicErr)
 		}
 	}()
 
 	deps := cmd.New(cmd.Dependency{
-		Memory:      cache,
-		Bot:         b,
-		Logger:      loggerClient,
-		Language:    strings.ToLower(language),
-		UnderAttack: underAttackModule,
+		Memory:       memoryCache,
+		CaptchaStore: captchaStore,
+		Bot:          botClient,
+		Logger:       loggerClient,
+		Config:       configuration,
+		UnderAttack:  underAttackModule,
 	})
 
+	// Every handler below must be registered after this.
+	b.Use(
+		middleware.Recover(deps.Logger, botClient),
+		middleware.Stale(
+			configuration.StaleUpdate.MaxAge,
+			middleware.StalePolicy(configuration.StaleUpdate.Policy),
+			deps.OnStaleUserJoinHandler,
+			deps.Logger,
+		),
+	)
+
 	// This is basically just for health check.
 	b.Handle("/start", func(c tb.Context) error {
-		_, err := c.Bot().Send(c.Message().Chat, "ok")
+		_, err := botClient.Send(c.Message().Chat, "ok")
 		if err != nil {
-			loggerClient.HandleBotError(err, b, c.Message())
+			deps.Logger.HandleBotError(err, botClient, c.Message())
 		}
 		return nil
 	})
@@ -278,24 +469,167 @@ func main() {
 	b.Handle(tb.OnVoice, deps.OnNonTextHandler)
 	b.Handle(tb.OnVideoNote, deps.OnNonTextHandler)
 	b.Handle(tb.OnUserLeft, deps.OnUserLeftHandler)
+	b.Handle(&tb.Btn{Unique: captcha.CatchUpButtonUnique}, deps.OnCatchUpCallbackHandler)
+
+	// Under attack handlers
+	if deps.UnderAttack != nil {
+		b.Handle("/underattack", deps.UnderAttack.EnableUnderAttackModeHandler)
+		b.Handle("/disableunderattack", deps.UnderAttack.DisableUnderAttackModeHandler)
+		b.Handle(tb.OnChatJoinRequest, deps.OnChatJoinRequestHandler)
+		b.Handle("/underattackstatus", deps.UnderAttack.StatusHandler)
+		b.Handle("/underattacknotify", deps.UnderAttack.NotifyHandler)
+		b.Handle("/attackbans", deps.UnderAttack.AttackBansHandler)
+		b.Handle("/attackunban", deps.UnderAttack.AttackUnbanHandler)
+		b.Handle(&tb.Btn{Unique: underattack.AttackBansButtonUnique}, deps.UnderAttack.AttackBansCallback)
+	}
 
-	signalChan := make(chan os.Signal, 1)
-	signal.Notify(signalChan, os.Interrupt, os.Kill)
+	shutdownCtx, shutdown := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer shutdown()
 
-	go func() {
-		<-signalChan
+	// With several replicas, only the one that holds the lease polls
+	// the updates and runs the timers. The others stand by here.
+	var elector *leader.Elector
+	if configuration.LeaderElection.Enabled {
+		lease, ok := underAttackModule.Datastore.(leader.Lease)
+		if !ok {
+			log.Fatal("The under attack datastore can't hold the leader lease")
+		}
+
+		holder := configuration.LeaderElection.Holder
+		if holder == "" {
+			holder = leader.DefaultHolder()
+		}
 
-		log.Println("Shutdown signal received, exiting...")
+		elector, err = leader.New(lease, "captcha-lite", holder, configuration.LeaderElection.LeaseDuration, loggerClient)
+		if err != nil {
+			log.Fatalf("Creating the leader elector: %s", err.Error())
+		}
+
+		log.Printf("Waiting to become the leader as %s", holder)
+		err = elector.Campaign(shutdownCtx)
+		if err != nil {
+			// It's only cancelled by the shutdown signal.
+			log.Println("Shutdown signal received, exiting...")
 
-		if underAttackModule != nil {
-			err := underAttackModule.Datastore.Close()
+			err = underAttackModule.Datastore.Close()
 			if err != nil {
 				log.Printf("Error during closing datastore connection: %s", err.Error())
 			}
+
+			return
+		}
+
+		log.Println("Became the leader")
+	}
+
+	// The lease is renewed until everything else has stopped,
+	// then released so a standby takes over right away.
+	leaseCtx, leaseCancel := context.WithCancel(context.Background())
+	defer leaseCancel()
+
+	leaseLost := make(chan struct{})
+	leaseReleased := make(chan struct{})
+	go func() {
+		defer close(leaseReleased)
+		if elector == nil {
+			return
+		}
+
+		err := elector.Keep(leaseCtx)
+		if err != nil {
+			loggerClient.HandleError(err)
+		}
+
+		if errors.Is(err, leader.ErrLeaseLost) {
+			close(leaseLost)
 		}
 	}()
 
-	// Start the bot
+	// The captchas on the snapshot, or on the shared store,
+	// have lost their timers.
+	if configuration.Snapshot.Directory != "" || configuration.Captcha.Store == "redis" {
+		deps.ResumeCaptchas()
+	}
+
+	// Background workers are stopped through this context on shutdown.
+	workerCtx, workerCancel := context.WithCancel(context.Background())
+	defer workerCancel()
+
+	var workers sync.WaitGroup
+	for _, target := range snapshotTargets {
+		workers.Add(1)
+		go func(target snapshotTarget) {
+			defer workers.Done()
+			snapshot.Run(workerCtx, target.cache, target.path, configuration.Snapshot.Interval, target.prepare, loggerClient)
+		}(target)
+	}
+
+	if deps.UnderAttack != nil {
+		workers.Add(2)
+		go func() {
+			defer workers.Done()
+			deps.UnderAttack.RunExpiryWorker(workerCtx, configuration.UnderAttack.ExpiryCheckInterval)
+		}()
+		go func() {
+			defer workers.Done()
+			deps.UnderAttack.RunScheduler(workerCtx, configuration.UnderAttack.ExpiryCheckInterval)
+		}()
+
+		// The memory datastore is never shared with another instance.
+		if configuration.UnderAttack.Datastore.Provider != "memory" {
+			workers.Add(1)
+			go func() {
+				defer workers.Done()
+				deps.UnderAttack.RunCacheInvalidator(workerCtx, configuration.UnderAttack.CacheInvalidationInterval)
+			}()
+		}
+	}
+
+	go func() {
+		select {
+		case <-shutdownCtx.Done():
+			log.Println("Shutdown signal received, exiting...")
+		case <-leaseLost:
+			// Another replica is the leader now. The process supervisor
+			// restarts this one as a standby.
+			log.Println("Lost the leader lease, exiting...")
+		}
+
+		// Stop must only be called once. Calling it again blocks
+		// forever, as nobody is receiving from the bot anymore.
+		b.Stop()
+	}()
+
+	// Start the bot. It blocks until the bot is stopped.
 	log.Println("Bot started!")
 	b.Start()
+
+	workerCancel()
+	workers.Wait()
+
+	// The caches are closed after this, it's the last chance to save them.
+	for _, target := range snapshotTargets {
+		if target.prepare != nil {
+			err := target.prepare()
+			if err != nil {
+				log.Printf("Error during preparing snapshot %s: %s", target.path, err.Error())
+				continue
+			}
+		}
+
+		err := snapshot.Save(target.cache, target.path)
+		if err != nil {
+			log.Printf("Error during saving snapshot %s: %s", target.path, err.Error())
+		}
+	}
+
+	leaseCancel()
+	<-leaseReleased
+
+	if underAttackModule != nil {
+		err := underAttackModule.Datastore.Close()
+		if err != nil {
+			log.Printf("Error during closing datastore connection: %s", err.Error())
+		}
+	}
 }
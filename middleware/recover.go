@@ -0,0 +1,63 @@
+// Package middleware contains the telebot middlewares that are applied
+// to every handler registered on the bot.
+package middleware
+
+import (
+	"fmt"
+	"runtime/debug"
+
+	"captcha-lite/logger"
+	"captcha-lite/telegram"
+
+	tb "gopkg.in/telebot.v3"
+)
+
+// Recover returns a middleware that recovers from a panic on the handler,
+// and reports it along with the update context (chat, sender, message)
+// to the logger, which is given the bot along with the message.
+//
+// Telebot runs every handler on its own goroutine, so without this, a
+// single panic will bring the whole bot down.
+func Recover(log logger.Logger, bot telegram.Client) tb.MiddlewareFunc {
+	return func(next tb.HandlerFunc) tb.HandlerFunc {
+		return func(c tb.Context) (err error) {
+			defer func() {
+				r := recover()
+				if r == nil {
+					return
+				}
+
+				// The recovered value is not always an error.
+				// A panic("something") will give us a string.
+				panicErr, ok := r.(error)
+				if !ok {
+					panicErr = fmt.Errorf("%v", r)
+				}
+				panicErr = fmt.Errorf("recovered from panic: %w", panicErr)
+
+				fields := []logger.Field{logger.F("stack", string(debug.Stack()))}
+				if chat := c.Chat(); chat != nil {
+					fields = append(fields, logger.ChatID(chat.ID))
+				}
+				if sender := c.Sender(); sender != nil {
+					fields = append(fields, logger.UserID(sender.ID))
+				}
+				if m := c.Message(); m != nil {
+					fields = append(fields, logger.F("message_id", m.ID), logger.F("message_text", m.Text))
+				}
+				log.Error("recovered from panic on handler", fields...)
+
+				// HandleBotError needs both the chat and the sender.
+				if m := c.Message(); m != nil && m.Chat != nil && m.Sender != nil {
+					log.HandleBotError(panicErr, bot, m)
+				} else {
+					log.HandleError(panicErr)
+				}
+
+				err = nil
+			}()
+
+			return next(c)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package middleware_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"captcha-lite/logger"
+	"captcha-lite/middleware"
+	"captcha-lite/telegram"
+	"captcha-lite/telegram/telegramtest"
+
+	tb "gopkg.in/telebot.v3"
+)
+
+type recorder struct {
+	errors    []error
+	botErrors []error
+	logs      []string
+}
+
+func (r *recorder) HandleError(e error) { r.errors = append(r.errors, e) }
+
+func (r *recorder) HandleBotError(e error, bot telegram.Client, m *tb.Message) {
+	r.botErrors = append(r.botErrors, e)
+}
+
+func (r *recorder) Debug(msg string, fields ...logger.Field) {}
+
+func (r *recorder) Info(msg string, fields ...logger.Field) {}
+
+func (r *recorder) Warn(msg string, fields ...logger.Field) {}
+
+func (r *recorder) Error(msg string, fields ...logger.Field) { r.logs = append(r.logs, msg) }
+
+func TestRecover(t *testing.T) {
+	bot, err := tb.NewBot(tb.Settings{Offline: true})
+	if err != nil {
+		t.Fatalf("creating offline bot: %s", err.Error())
+	}
+
+	tests := []struct {
+		name        string
+		update      tb.Update
+		panicWith   interface{}
+		botErrors   int
+		plainErrors int
+	}{
+		{
+			name:      "Panic with string on a message",
+			update:    tb.Update{Message: &tb.Message{ID: 1, Chat: &tb.Chat{ID: 2}, Sender: &tb.User{ID: 3}}},
+			panicWith: "boom",
+			botErrors: 1,
+		},
+		{
+			name:        "Panic with error without a message",
+			update:      tb.Update{},
+			panicWith:   errors.New("boom"),
+			plainErrors: 1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := &recorder{}
+			handler := middleware.Recover(r, telegramtest.NewFake())(func(c tb.Context) error {
+				panic(test.panicWith)
+			})
+
+			err := handler(bot.NewContext(test.update))
+			if err != nil {
+				t.Errorf("expecting nil error, got %s", err.Error())
+			}
+
+			if len(r.botErrors) != test.botErrors || len(r.errors) != test.plainErrors {
+				t.Fatalf("expecting %d bot errors and %d errors, got %d and %d", test.botErrors, test.plainErrors, len(r.botErrors), len(r.errors))
+			}
+
+			reported := append(r.botErrors, r.errors...)[0]
+			if !strings.Contains(reported.Error(), "recovered from panic: boom") {
+				t.Errorf("unexpected reported error: %s", reported.Error())
+			}
+
+			if len(r.logs) != 1 {
+				t.Errorf("expecting one error log, got %d", len(r.logs))
+			}
+		})
+	}
+
+	t.Run("No panic", func(t *testing.T) {
+		r := &recorder{}
+		expected := errors.New("regular error")
+		err := middleware.Recover(r, telegramtest.NewFake())(func(c tb.Context) error {
+			return expected
+		})(bot.NewContext(tb.Update{}))
+		if !errors.Is(err, expected) {
+			t.Errorf("expecting the handler error to be passed through, got %v", err)
+		}
+
+		if len(r.errors) != 0 || len(r.botErrors) != 0 {
+			t.Error("expecting nothing to be reported")
+		}
+	})
+}
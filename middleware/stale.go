@@ -0,0 +1,65 @@
+package middleware
+
+import (
+	"time"
+
+	"captcha-lite/logger"
+
+	tb "gopkg.in/telebot.v3"
+)
+
+// StalePolicy decides what happens to an update that is older than
+// the maximum age.
+type StalePolicy string
+
+const (
+	// StalePolicyDrop ignores every stale update.
+	StalePolicyDrop StalePolicy = "drop"
+	// StalePolicyCatchUp hands stale user joins over to the catch up
+	// handler, and ignores every other stale update.
+	StalePolicyCatchUp StalePolicy = "catch_up"
+)
+
+// Stale returns a middleware that applies the update age policy.
+//
+// When the bot comes back after some downtime, long polling delivers a
+// backlog of old updates. Challenging a user that joined an hour ago with
+// a one minute captcha doesn't make any sense, so the updates that are
+// older than maxAge are either dropped, or for user joins, passed to the
+// catchUp handler instead of the regular one.
+//
+// A zero maxAge disables the middleware.
+func Stale(maxAge time.Duration, policy StalePolicy, catchUp tb.HandlerFunc, log logger.Logger) tb.MiddlewareFunc {
+	return func(next tb.HandlerFunc) tb.HandlerFunc {
+		if maxAge <= 0 {
+			return next
+		}
+
+		return func(c tb.Context) error {
+			// Only the message updates carry a date. We can't use c.Message()
+			// here, as it returns the (old) message of a callback query too.
+			m := c.Update().Message
+			if m == nil || m.Unixtime == 0 {
+				return next(c)
+			}
+
+			age := time.Since(m.Time())
+			if age <= maxAge {
+				return next(c)
+			}
+
+			fields := []logger.Field{logger.F("age", age.String()), logger.F("message_id", m.ID)}
+			if m.Chat != nil {
+				fields = append(fields, logger.ChatID(m.Chat.ID))
+			}
+
+			if policy == StalePolicyCatchUp && catchUp != nil && m.UserJoined != nil {
+				log.Info("handling stale user join in catch up mode", fields...)
+				return catchUp(c)
+			}
+
+			log.Debug("dropping stale update", fields...)
+			return nil
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package middleware_test
+
+import (
+	"testing"
+	"time"
+
+	"captcha-lite/middleware"
+
+	tb "gopkg.in/telebot.v3"
+)
+
+func TestStale(t *testing.T) {
+	bot, err := tb.NewBot(tb.Settings{Offline: true})
+	if err != nil {
+		t.Fatalf("creating offline bot: %s", err.Error())
+	}
+
+	fresh := time.Now().Unix()
+	stale := time.Now().Add(-time.Hour).Unix()
+
+	tests := []struct {
+		name     string
+		maxAge   time.Duration
+		policy   middleware.StalePolicy
+		update   tb.Update
+		expected string
+	}{
+		{
+			name:     "Fresh message",
+			maxAge:   time.Minute,
+			policy:   middleware.StalePolicyDrop,
+			update:   tb.Update{Message: &tb.Message{Unixtime: fresh, Chat: &tb.Chat{ID: 1}}},
+			expected: "next",
+		},
+		{
+			name:     "Stale message dropped",
+			maxAge:   time.Minute,
+			policy:   middleware.StalePolicyCatchUp,
+			update:   tb.Update{Message: &tb.Message{Unixtime: stale, Chat: &tb.Chat{ID: 1}}},
+			expected: "",
+		},
+		{
+			name:     "Stale join on catch up",
+			maxAge:   time.Minute,
+			policy:   middleware.StalePolicyCatchUp,
+			update:   tb.Update{Message: &tb.Message{Unixtime: stale, Chat: &tb.Chat{ID: 1}, UserJoined: &tb.User{ID: 2}}},
+			expected: "catch up",
+		},
+		{
+			name:     "Stale join on drop",
+			maxAge:   time.Minute,
+			policy:   middleware.StalePolicyDrop,
+			update:   tb.Update{Message: &tb.Message{Unixtime: stale, Chat: &tb.Chat{ID: 1}, UserJoined: &tb.User{ID: 2}}},
+			expected: "",
+		},
+		{
+			name:     "Callback of an old message",
+			maxAge:   time.Minute,
+			policy:   middleware.StalePolicyDrop,
+			update:   tb.Update{Callback: &tb.Callback{Message: &tb.Message{Unixtime: stale}}},
+			expected: "next",
+		},
+		{
+			name:     "Disabled",
+			maxAge:   0,
+			policy:   middleware.StalePolicyDrop,
+			update:   tb.Update{Message: &tb.Message{Unixtime: stale, Chat: &tb.Chat{ID: 1}}},
+			expected: "next",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var called string
+			catchUp := func(c tb.Context) error {
+				called = "catch up"
+				return nil
+			}
+
+			handler := middleware.Stale(test.maxAge, test.policy, catchUp, &recorder{})(func(c tb.Context) error {
+				called = "next"
+				return nil
+			})
+
+			err := handler(bot.NewContext(test.update))
+			if err != nil {
+				t.Errorf("unexpected error: %s", err.Error())
+			}
+
+			if called != test.expected {
+				t.Errorf("expecting %q to be called, got %q", test.expected, called)
+			}
+		})
+	}
+}
@@ -0,0 +1,231 @@
+// Package notifier tells the humans on Telegram that something went wrong
+// with the bot, without spamming them.
+//
+// Reporting the error to Sentry, Rollbar and friends is the job of the
+// logger.Logger implementation. This package only takes care of the
+// message that is sent to the chat. During a raid, a flaky database or a
+// storm of 429 can fail dozens of handlers in a row, so the notification
+// is rate limited per chat and deduplicated by the class of the error.
+package notifier
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"html"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"captcha-lite/locale"
+	"captcha-lite/logger"
+	"captcha-lite/telegram"
+
+	tb "gopkg.in/telebot.v3"
+)
+
+// Class is the broad category of an error. Errors of the same class
+// within the dedup window only produce one notification.
+type Class string
+
+const (
+	ClassRateLimited Class = "rate_limited"
+	ClassTimeout     Class = "timeout"
+	ClassTelegram    Class = "telegram"
+	ClassDatastore   Class = "datastore"
+	ClassInternal    Class = "internal"
+)
+
+// Target is where the notification is sent to.
+type Target string
+
+const (
+	// TargetGroup sends the notification to the group where the error happened.
+	TargetGroup Target = "group"
+	// TargetLogChannel sends the notification, with the error detail,
+	// to the admin log channel instead of the group.
+	TargetLogChannel Target = "log_channel"
+	// TargetNone disables the notification altogether.
+	TargetNone Target = "none"
+)
+
+// Config configures the Notifier.
+type Config struct {
+	Target Target
+	// LogChannelID is the chat ID of the admin log channel.
+	// Required if Target is TargetLogChannel.
+	LogChannelID int64
+	// Cooldown is the minimum time between two notifications
+	// for the same chat.
+	Cooldown time.Duration
+	// DedupWindow is the time in which errors of the same class
+	// for the same chat will only be notified once.
+	DedupWindow time.Duration
+}
+
+type key struct {
+	chatID int64
+	class  Class
+}
+
+type Notifier struct {
+	bot    telegram.Client
+	locale map[locale.Message]string
+	config Config
+
+	mu         sync.Mutex
+	lastByChat map[int64]time.Time
+	lastByKey  map[key]time.Time
+	lastPrune  time.Time
+	now        func() time.Time
+}
+
+// New creates a new Notifier.
+func New(bot telegram.Client, localeLanguage map[locale.Message]string, config Config) *Notifier {
+	if bot == nil {
+		panic("notifier.New: bot is nil")
+	}
+
+	return &Notifier{
+		bot:        bot,
+		locale:     localeLanguage,
+		config:     config,
+		lastByChat: make(map[int64]time.Time),
+		lastByKey:  make(map[key]time.Time),
+		now:        time.Now,
+	}
+}
+
+// Classify returns the Class of the given error.
+func Classify(err error) Class {
+	var floodError tb.FloodError
+	var floodErrorPtr *tb.FloodError
+	if errors.As(err, &floodError) || errors.As(err, &floodErrorPtr) || strings.Contains(err.Error(), "retry after") {
+		return ClassRateLimited
+	}
+
+	var netError net.Error
+	if errors.Is(err, context.DeadlineExceeded) ||
+		(errors.As(err, &netError) && netError.Timeout()) ||
+		strings.Contains(err.Error(), "Gateway Timeout (504)") {
+		return ClassTimeout
+	}
+
+	var telegramError *tb.Error
+	if errors.As(err, &telegramError) || strings.HasPrefix(err.Error(), "telegram: ") {
+		return ClassTelegram
+	}
+
+	if errors.Is(err, sql.ErrConnDone) || errors.Is(err, sql.ErrTxDone) || errors.Is(err, driver.ErrBadConn) {
+		return ClassDatastore
+	}
+
+	return ClassInternal
+}
+
+// Notify sends the failure notification for the given message, if it's
+// not suppressed by the cooldown or the dedup window. The returned error
+// is the error of sending the notification itself.
+func (n *Notifier) Notify(e error, m *tb.Message) error {
+	if n.config.Target == TargetNone || e == nil || m == nil || m.Chat == nil {
+		return nil
+	}
+
+	class := Classify(e)
+	if !n.allow(m.Chat.ID, class) {
+		return nil
+	}
+
+	if n.config.Target == TargetLogChannel {
+		_, err := n.bot.Send(
+			tb.ChatID(n.config.LogChannelID),
+			strings.NewReplacer(
+				"{{group}}", html.EscapeString(m.Chat.Title),
+				"{{chatID}}", strconv.FormatInt(m.Chat.ID, 10),
+				"{{class}}", string(class),
+				"{{error}}", html.EscapeString(e.Error()),
+			).Replace(n.locale[locale.MessageErrorLogChannel]),
+			&tb.SendOptions{ParseMode: tb.ModeHTML, DisableWebPagePreview: true},
+		)
+		return err
+	}
+
+	_, err := n.bot.Send(
+		m.Chat,
+		n.locale[locale.MessageSomethingWentWrong],
+		&tb.SendOptions{ParseMode: tb.ModeHTML},
+	)
+	return err
+}
+
+// allow records and decides whether a notification can be sent.
+func (n *Notifier) allow(chatID int64, class Class) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	now := n.now()
+	n.prune(now)
+
+	if last, ok := n.lastByChat[chatID]; ok && now.Sub(last) < n.config.Cooldown {
+		return false
+	}
+
+	k := key{chatID: chatID, class: class}
+	if last, ok := n.lastByKey[k]; ok && now.Sub(last) < n.config.DedupWindow {
+		return false
+	}
+
+	n.lastByChat[chatID] = now
+	n.lastByKey[k] = now
+	return true
+}
+
+// prune removes the entries that can't suppress anything anymore,
+// so the maps don't grow forever.
+func (n *Notifier) prune(now time.Time) {
+	window := n.config.DedupWindow
+	if n.config.Cooldown > window {
+		window = n.config.Cooldown
+	}
+
+	if now.Sub(n.lastPrune) < window {
+		return
+	}
+	n.lastPrune = now
+
+	for chatID, last := range n.lastByChat {
+		if now.Sub(last) >= n.config.Cooldown {
+			delete(n.lastByChat, chatID)
+		}
+	}
+
+	for k, last := range n.lastByKey {
+		if now.Sub(last) >= n.config.DedupWindow {
+			delete(n.lastByKey, k)
+		}
+	}
+}
+
+// Wrap returns a logger.Logger that reports the errors through l, and
+// notifies the chat through the Notifier on HandleBotError.
+func (n *Notifier) Wrap(l logger.Logger) logger.Logger {
+	return &notifyingLogger{Logger: l, notifier: n}
+}
+
+type notifyingLogger struct {
+	logger.Logger
+	notifier *Notifier
+}
+
+func (l *notifyingLogger) HandleBotError(e error, bot telegram.Client, m *tb.Message) {
+	l.Logger.HandleBotError(e, bot, m)
+
+	err := l.notifier.Notify(e, m)
+	if err != nil {
+		// Come on? Another error?
+		l.Logger.HandleError(err)
+	}
+}
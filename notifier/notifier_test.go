@@ -0,0 +1,68 @@
+package notifier
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	tb "gopkg.in/telebot.v3"
+)
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		err      error
+		expected Class
+	}{
+		{err: tb.FloodError{RetryAfter: 10}, expected: ClassRateLimited},
+		{err: errors.New("telegram: retry after 10 (429)"), expected: ClassRateLimited},
+		{err: fmt.Errorf("fetching: %w", context.DeadlineExceeded), expected: ClassTimeout},
+		{err: errors.New("telegram: Gateway Timeout (504)"), expected: ClassTimeout},
+		{err: tb.ErrNotFound, expected: ClassTelegram},
+		{err: errors.New("telegram: chat not found (400)"), expected: ClassTelegram},
+		{err: errors.New("json: cannot unmarshal"), expected: ClassInternal},
+	}
+
+	for _, test := range tests {
+		if class := Classify(test.err); class != test.expected {
+			t.Errorf("Classify(%q): expecting %s, got %s", test.err.Error(), test.expected, class)
+		}
+	}
+}
+
+func TestAllow(t *testing.T) {
+	now := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	n := &Notifier{
+		config:     Config{Target: TargetGroup, Cooldown: time.Minute, DedupWindow: time.Hour},
+		lastByChat: make(map[int64]time.Time),
+		lastByKey:  make(map[key]time.Time),
+		now:        func() time.Time { return now },
+	}
+
+	if !n.allow(1, ClassTelegram) {
+		t.Error("first notification must be allowed")
+	}
+
+	if n.allow(1, ClassDatastore) {
+		t.Error("notification within the cooldown must not be allowed")
+	}
+
+	if !n.allow(2, ClassTelegram) {
+		t.Error("cooldown must be per chat")
+	}
+
+	now = now.Add(time.Minute * 2)
+	if n.allow(1, ClassTelegram) {
+		t.Error("same class within the dedup window must not be allowed")
+	}
+
+	if !n.allow(1, ClassDatastore) {
+		t.Error("different class after the cooldown must be allowed")
+	}
+
+	now = now.Add(time.Hour)
+	if !n.allow(1, ClassTelegram) {
+		t.Error("same class after the dedup window must be allowed")
+	}
+}
@@ -0,0 +1,203 @@
+// Package snapshot saves the content of a bigcache instance to a local
+// file, and restores it back, so the in memory state survives a restart
+// without any database.
+//
+// The snapshot is written to a temporary file on the same directory, then
+// renamed over the previous one. A crash in the middle of a save leaves
+// the previous snapshot intact.
+package snapshot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"captcha-lite/logger"
+
+	"github.com/allegro/bigcache/v3"
+)
+
+// version is bumped whenever the file format changes.
+const version = 1
+
+type file struct {
+	Version int     `json:"version"`
+	SavedAt int64   `json:"saved_at"`
+	Entries []entry `json:"entries"`
+}
+
+type entry struct {
+	Key   string `json:"key"`
+	Value []byte `json:"value"`
+	// Timestamp is when the entry was last set, in unix seconds.
+	Timestamp uint64 `json:"timestamp"`
+}
+
+// Save writes every entry of the cache to the file on path, atomically.
+func Save(cache *bigcache.BigCache, path string) error {
+	if cache == nil {
+		return fmt.Errorf("nil cache")
+	}
+
+	snapshot := file{Version: version, SavedAt: time.Now().Unix()}
+
+	iterator := cache.Iterator()
+	for iterator.SetNext() {
+		value, err := iterator.Value()
+		if err != nil {
+			// The entry was removed while iterating.
+			if errors.Is(err, bigcache.ErrInvalidIteratorState) || errors.Is(err, bigcache.ErrCannotRetrieveEntry) {
+				continue
+			}
+
+			return err
+		}
+
+		snapshot.Entries = append(snapshot.Entries, entry{
+			Key:       value.Key(),
+			Value:     value.Value(),
+			Timestamp: value.Timestamp(),
+		})
+	}
+
+	content, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+
+	return writeFile(path, content)
+}
+
+// Restore sets every entry on the file on path to the cache, and returns
+// how many were restored. Entries older than maxAge are skipped, as the
+// cache would have evicted them by now. Zero maxAge restores everything.
+//
+// A missing file is not an error, there is simply nothing to restore.
+//
+// The cache can't take the original timestamps, so a restored entry
+// lives for the whole life window of the cache again.
+func Restore(cache *bigcache.BigCache, path string, maxAge time.Duration) (int, error) {
+	if cache == nil {
+		return 0, fmt.Errorf("nil cache")
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return 0, nil
+		}
+
+		return 0, err
+	}
+
+	var snapshot file
+	err = json.Unmarshal(content, &snapshot)
+	if err != nil {
+		return 0, fmt.Errorf("parsing snapshot %s: %w", path, err)
+	}
+
+	if snapshot.Version != version {
+		return 0, fmt.Errorf("unknown snapshot version %d on %s", snapshot.Version, path)
+	}
+
+	now := time.Now()
+
+	var restored int
+	for _, entry := range snapshot.Entries {
+		if maxAge > 0 && now.Sub(time.Unix(int64(entry.Timestamp), 0)) > maxAge {
+			continue
+		}
+
+		err := cache.Set(entry.Key, entry.Value)
+		if err != nil {
+			return restored, err
+		}
+
+		restored++
+	}
+
+	return restored, nil
+}
+
+// Run saves the cache to the file on path every interval, until the
+// context is cancelled. The errors are sent to the logger, as there
+// is nobody to return them to.
+//
+// The prepare function, if not nil, is called before every save, for
+// the owner of the cache to bring it up to date.
+func Run(ctx context.Context, cache *bigcache.BigCache, path string, interval time.Duration, prepare func() error, log logger.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if prepare != nil {
+				err := prepare()
+				if err != nil {
+					log.HandleError(fmt.Errorf("preparing snapshot %s: %w", path, err))
+					continue
+				}
+			}
+
+			err := Save(cache, path)
+			if err != nil {
+				log.HandleError(fmt.Errorf("saving snapshot %s: %w", path, err))
+			}
+		}
+	}
+}
+
+// writeFile writes the content to a temporary file next to path, flushes
+// it to the disk, then renames it to path.
+func writeFile(path string, content []byte) error {
+	dir := filepath.Dir(path)
+
+	temporary, err := os.CreateTemp(dir, "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+
+	// This is a no-op once the file is renamed.
+	defer func() {
+		_ = os.Remove(temporary.Name())
+	}()
+
+	_, err = temporary.Write(content)
+	if err != nil {
+		_ = temporary.Close()
+		return err
+	}
+
+	err = temporary.Sync()
+	if err != nil {
+		_ = temporary.Close()
+		return err
+	}
+
+	err = temporary.Close()
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(temporary.Name(), path)
+	if err != nil {
+		return err
+	}
+
+	// Persist the rename itself. Not every platform can sync
+	// a directory, and the file is in place anyway.
+	if d, err := os.Open(dir); err == nil {
+		_ = d.Sync()
+		_ = d.Close()
+	}
+
+	return nil
+}
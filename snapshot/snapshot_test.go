@@ -0,0 +1,158 @@
+package snapshot_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"captcha-lite/snapshot"
+
+	"github.com/allegro/bigcache/v3"
+)
+
+func newCache(t *testing.T) *bigcache.BigCache {
+	t.Helper()
+
+	cache, err := bigcache.New(context.Background(), bigcache.DefaultConfig(time.Hour))
+	if err != nil {
+		t.Fatalf("creating bigcache instance: %s", err.Error())
+	}
+
+	t.Cleanup(func() {
+		_ = cache.Close()
+	})
+
+	return cache
+}
+
+func TestSaveRestore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "captcha.snapshot")
+
+	source := newCache(t)
+	for key, value := range map[string]string{"1": `{"answer":"123"}`, "captcha:users": ";1"} {
+		err := source.Set(key, []byte(value))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	err := snapshot.Save(source, path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	target := newCache(t)
+	restored, err := snapshot.Restore(target, path, time.Hour)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if restored != 2 {
+		t.Errorf("expecting 2 entries to be restored, got %d", restored)
+	}
+
+	value, err := target.Get("captcha:users")
+	if err != nil || string(value) != ";1" {
+		t.Errorf("expecting captcha:users to be restored, got %q (%v)", value, err)
+	}
+
+	// Nothing is left behind but the snapshot itself.
+	files, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(files) != 1 {
+		t.Errorf("expecting only the snapshot on the directory, got %d files", len(files))
+	}
+}
+
+func TestSave_Overwrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "captcha.snapshot")
+
+	cache := newCache(t)
+	err := cache.Set("a", []byte("1"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	err = snapshot.Save(cache, path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	err = cache.Delete("a")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	err = snapshot.Save(cache, path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	restored, err := snapshot.Restore(newCache(t), path, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if restored != 0 {
+		t.Errorf("expecting the latest snapshot to replace the previous one, got %d entries", restored)
+	}
+}
+
+func TestRestore_MissingFile(t *testing.T) {
+	restored, err := snapshot.Restore(newCache(t), filepath.Join(t.TempDir(), "missing.snapshot"), 0)
+	if err != nil {
+		t.Errorf("expecting a missing snapshot not to be an error, got %v", err)
+	}
+
+	if restored != 0 {
+		t.Errorf("expecting nothing to be restored, got %d", restored)
+	}
+}
+
+func TestRestore_Corrupted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "captcha.snapshot")
+
+	err := os.WriteFile(path, []byte(`{"version":1,"entries":[`), 0o600)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	_, err = snapshot.Restore(newCache(t), path, 0)
+	if err == nil {
+		t.Error("expecting an error, got nil")
+	}
+}
+
+func TestRestore_MaxAge(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "captcha.snapshot")
+
+	// The timestamps are in seconds.
+	old := time.Now().Add(-time.Hour).Unix()
+	err := os.WriteFile(path, []byte(`{"version":1,"entries":[`+
+		`{"key":"old","value":"MQ==","timestamp":`+strconv.FormatInt(old, 10)+`},`+
+		`{"key":"new","value":"Mg==","timestamp":`+strconv.FormatInt(time.Now().Unix(), 10)+`}]}`), 0o600)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	cache := newCache(t)
+	restored, err := snapshot.Restore(cache, path, time.Minute*5)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if restored != 1 {
+		t.Errorf("expecting only the new entry to be restored, got %d", restored)
+	}
+
+	_, err = cache.Get("old")
+	if err == nil {
+		t.Error("expecting the old entry to be skipped")
+	}
+}
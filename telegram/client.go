@@ -0,0 +1,117 @@
+// Package telegram is the narrow set of Telegram Bot API calls that the
+// handlers make, so they can be given a fake one on the tests.
+package telegram
+
+import (
+	"fmt"
+
+	tb "gopkg.in/telebot.v3"
+)
+
+// Client is the part of the Telegram Bot API that the handlers use. The
+// methods are the same as the ones on the telebot Bot.
+type Client interface {
+	// Me returns the user of the bot itself.
+	Me() *tb.User
+
+	Send(to tb.Recipient, what interface{}, opts ...interface{}) (*tb.Message, error)
+	Edit(msg tb.Editable, what interface{}, opts ...interface{}) (*tb.Message, error)
+	Delete(msg tb.Editable) error
+	Pin(msg tb.Editable, opts ...interface{}) error
+	Unpin(chat *tb.Chat, messageID ...int) error
+
+	ChatByID(id int64) (*tb.Chat, error)
+	ChatMemberOf(chat, user tb.Recipient) (*tb.ChatMember, error)
+	AdminsOf(chat *tb.Chat) ([]tb.ChatMember, error)
+
+	Ban(chat *tb.Chat, member *tb.ChatMember, revokeMessages ...bool) error
+	Unban(chat *tb.Chat, user *tb.User, forBanned ...bool) error
+	Restrict(chat *tb.Chat, member *tb.ChatMember) error
+	SetGroupPermissions(chat *tb.Chat, perms tb.Rights) error
+
+	InviteLink(chat *tb.Chat) (string, error)
+	CreateInviteLink(chat tb.Recipient, link *tb.ChatInviteLink) (*tb.ChatInviteLink, error)
+	RevokeInviteLink(chat tb.Recipient, link string) (*tb.ChatInviteLink, error)
+	DeclineJoinRequest(chat tb.Recipient, user *tb.User) error
+}
+
+// client calls the Telegram Bot API through telebot.
+type client struct {
+	bot *tb.Bot
+}
+
+func NewClient(bot *tb.Bot) (*client, error) {
+	if bot == nil {
+		return nil, fmt.Errorf("nil bot")
+	}
+
+	return &client{bot: bot}, nil
+}
+
+func (c *client) Me() *tb.User {
+	return c.bot.Me
+}
+
+func (c *client) Send(to tb.Recipient, what interface{}, opts ...interface{}) (*tb.Message, error) {
+	return c.bot.Send(to, what, opts...)
+}
+
+func (c *client) Edit(msg tb.Editable, what interface{}, opts ...interface{}) (*tb.Message, error) {
+	return c.bot.Edit(msg, what, opts...)
+}
+
+func (c *client) Delete(msg tb.Editable) error {
+	return c.bot.Delete(msg)
+}
+
+func (c *client) Pin(msg tb.Editable, opts ...interface{}) error {
+	return c.bot.Pin(msg, opts...)
+}
+
+func (c *client) Unpin(chat *tb.Chat, messageID ...int) error {
+	return c.bot.Unpin(chat, messageID...)
+}
+
+func (c *client) ChatByID(id int64) (*tb.Chat, error) {
+	return c.bot.ChatByID(id)
+}
+
+func (c *client) ChatMemberOf(chat, user tb.Recipient) (*tb.ChatMember, error) {
+	return c.bot.ChatMemberOf(chat, user)
+}
+
+func (c *client) AdminsOf(chat *tb.Chat) ([]tb.ChatMember, error) {
+	return c.bot.AdminsOf(chat)
+}
+
+func (c *client) Ban(chat *tb.Chat, member *tb.ChatMember, revokeMessages ...bool) error {
+	return c.bot.Ban(chat, member, revokeMessages...)
+}
+
+func (c *client) Unban(chat *tb.Chat, user *tb.User, forBanned ...bool) error {
+	return c.bot.Unban(chat, user, forBanned...)
+}
+
+func (c *client) Restrict(chat *tb.Chat, member *tb.ChatMember) error {
+	return c.bot.Restrict(chat, member)
+}
+
+func (c *client) SetGroupPermissions(chat *tb.Chat, perms tb.Rights) error {
+	return c.bot.SetGroupPermissions(chat, perms)
+}
+
+func (c *client) InviteLink(chat *tb.Chat) (string, error) {
+	return c.bot.InviteLink(chat)
+}
+
+func (c *client) CreateInviteLink(chat tb.Recipient, link *tb.ChatInviteLink) (*tb.ChatInviteLink, error) {
+	return c.bot.CreateInviteLink(chat, link)
+}
+
+func (c *client) RevokeInviteLink(chat tb.Recipient, link string) (*tb.ChatInviteLink, error) {
+	return c.bot.RevokeInviteLink(chat, link)
+}
+
+func (c *client) DeclineJoinRequest(chat tb.Recipient, user *tb.User) error {
+	return c.bot.DeclineJoinRequest(chat, user)
+}
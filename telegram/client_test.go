@@ -0,0 +1,30 @@
+package telegram_test
+
+import (
+	"testing"
+
+	"captcha-lite/telegram"
+
+	tb "gopkg.in/telebot.v3"
+)
+
+func TestNewClient(t *testing.T) {
+	_, err := telegram.NewClient(nil)
+	if err == nil || err.Error() != "nil bot" {
+		t.Errorf("expecting an error of 'nil bot', instead got %v", err)
+	}
+
+	bot, err := tb.NewBot(tb.Settings{Offline: true})
+	if err != nil {
+		t.Fatalf("creating offline bot: %s", err.Error())
+	}
+
+	client, err := telegram.NewClient(bot)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if client.Me() != bot.Me {
+		t.Error("expecting Me to be the user of the bot")
+	}
+}
@@ -0,0 +1,264 @@
+// Package telegramtest provides a fake telegram.Client that records the
+// calls made on it, for testing the handlers.
+package telegramtest
+
+import (
+	"strconv"
+	"sync"
+
+	tb "gopkg.in/telebot.v3"
+)
+
+// Call is a call that was made on the Fake.
+type Call struct {
+	// Method is the name of the method, such as "Send".
+	Method string
+	// ChatID is the chat that the call was made on, if any.
+	ChatID int64
+	// MessageID is the message that the call was made on, for Edit,
+	// Delete, Pin and Unpin.
+	MessageID string
+	// UserID is the user that the call was made on, for the calls
+	// about a member.
+	UserID int64
+	// What is what was sent, or what the message was edited to.
+	What interface{}
+	// Member is the member given to Ban and Restrict.
+	Member *tb.ChatMember
+}
+
+// Fake is a telegram.Client that records the calls made on it, and
+// answers them without calling the Telegram Bot API. It's safe for
+// concurrent use, as the handlers make some of the calls on their own
+// goroutines. The exported fields must be set before it's used.
+type Fake struct {
+	// User is returned by Me.
+	User *tb.User
+	// Admins is returned by AdminsOf, by the chat ID.
+	Admins map[int64][]tb.ChatMember
+	// Errors is returned by the calls, by the name of the method.
+	Errors map[string]error
+
+	mu            sync.Mutex
+	calls         []Call
+	lastMessageID int
+}
+
+func NewFake() *Fake {
+	return &Fake{
+		User:   &tb.User{ID: 1, IsBot: true, Username: "captcha_bot"},
+		Admins: make(map[int64][]tb.ChatMember),
+		Errors: make(map[string]error),
+	}
+}
+
+// Calls returns the calls that were made, in order.
+func (f *Fake) Calls() []Call {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	calls := make([]Call, len(f.calls))
+	copy(calls, f.calls)
+	return calls
+}
+
+// CallsOf returns the calls that were made on the method, in order.
+func (f *Fake) CallsOf(method string) []Call {
+	var calls []Call
+	for _, call := range f.Calls() {
+		if call.Method == method {
+			calls = append(calls, call)
+		}
+	}
+
+	return calls
+}
+
+func (f *Fake) record(call Call) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.calls = append(f.calls, call)
+	return f.Errors[call.Method]
+}
+
+// nextMessageID numbers the messages that are sent, from 1.
+func (f *Fake) nextMessageID() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.lastMessageID++
+	return f.lastMessageID
+}
+
+func (f *Fake) Me() *tb.User {
+	return f.User
+}
+
+func (f *Fake) Send(to tb.Recipient, what interface{}, opts ...interface{}) (*tb.Message, error) {
+	chatID := recipientID(to)
+	err := f.record(Call{Method: "Send", ChatID: chatID, What: what})
+	if err != nil {
+		return nil, err
+	}
+
+	msg := &tb.Message{ID: f.nextMessageID(), Chat: &tb.Chat{ID: chatID}, Sender: f.User}
+	if text, ok := what.(string); ok {
+		msg.Text = text
+	}
+
+	return msg, nil
+}
+
+func (f *Fake) Edit(msg tb.Editable, what interface{}, opts ...interface{}) (*tb.Message, error) {
+	messageID, chatID := msg.MessageSig()
+	err := f.record(Call{Method: "Edit", ChatID: chatID, MessageID: messageID, What: what})
+	if err != nil {
+		return nil, err
+	}
+
+	id, _ := strconv.Atoi(messageID)
+	edited := &tb.Message{ID: id, Chat: &tb.Chat{ID: chatID}, Sender: f.User}
+	if text, ok := what.(string); ok {
+		edited.Text = text
+	}
+
+	return edited, nil
+}
+
+func (f *Fake) Delete(msg tb.Editable) error {
+	messageID, chatID := msg.MessageSig()
+	return f.record(Call{Method: "Delete", ChatID: chatID, MessageID: messageID})
+}
+
+func (f *Fake) Pin(msg tb.Editable, opts ...interface{}) error {
+	messageID, chatID := msg.MessageSig()
+	return f.record(Call{Method: "Pin", ChatID: chatID, MessageID: messageID})
+}
+
+func (f *Fake) Unpin(chat *tb.Chat, messageID ...int) error {
+	call := Call{Method: "Unpin", ChatID: chat.ID}
+	if len(messageID) > 0 {
+		call.MessageID = strconv.Itoa(messageID[0])
+	}
+
+	return f.record(call)
+}
+
+func (f *Fake) ChatByID(id int64) (*tb.Chat, error) {
+	err := f.record(Call{Method: "ChatByID", ChatID: id})
+	if err != nil {
+		return nil, err
+	}
+
+	return &tb.Chat{ID: id, Type: tb.ChatSuperGroup}, nil
+}
+
+// ChatMemberOf returns the member from Admins if they are one,
+// or else a plain member.
+func (f *Fake) ChatMemberOf(chat, user tb.Recipient) (*tb.ChatMember, error) {
+	chatID, userID := recipientID(chat), recipientID(user)
+	err := f.record(Call{Method: "ChatMemberOf", ChatID: chatID, UserID: userID})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, admin := range f.Admins[chatID] {
+		if admin.User != nil && admin.User.ID == userID {
+			return &admin, nil
+		}
+	}
+
+	u, ok := user.(*tb.User)
+	if !ok {
+		u = &tb.User{ID: userID}
+	}
+
+	return &tb.ChatMember{User: u, Role: tb.Member}, nil
+}
+
+func (f *Fake) AdminsOf(chat *tb.Chat) ([]tb.ChatMember, error) {
+	err := f.record(Call{Method: "AdminsOf", ChatID: chat.ID})
+	if err != nil {
+		return nil, err
+	}
+
+	return f.Admins[chat.ID], nil
+}
+
+func (f *Fake) Ban(chat *tb.Chat, member *tb.ChatMember, revokeMessages ...bool) error {
+	return f.record(Call{Method: "Ban", ChatID: chat.ID, UserID: memberID(member), Member: member})
+}
+
+func (f *Fake) Unban(chat *tb.Chat, user *tb.User, forBanned ...bool) error {
+	return f.record(Call{Method: "Unban", ChatID: chat.ID, UserID: user.ID})
+}
+
+func (f *Fake) Restrict(chat *tb.Chat, member *tb.ChatMember) error {
+	return f.record(Call{Method: "Restrict", ChatID: chat.ID, UserID: memberID(member), Member: member})
+}
+
+func (f *Fake) SetGroupPermissions(chat *tb.Chat, perms tb.Rights) error {
+	return f.record(Call{Method: "SetGroupPermissions", ChatID: chat.ID, What: perms})
+}
+
+func (f *Fake) InviteLink(chat *tb.Chat) (string, error) {
+	err := f.record(Call{Method: "InviteLink", ChatID: chat.ID})
+	if err != nil {
+		return "", err
+	}
+
+	return "https://t.me/+" + strconv.FormatInt(chat.ID, 10), nil
+}
+
+func (f *Fake) CreateInviteLink(chat tb.Recipient, link *tb.ChatInviteLink) (*tb.ChatInviteLink, error) {
+	chatID := recipientID(chat)
+	err := f.record(Call{Method: "CreateInviteLink", ChatID: chatID, What: link})
+	if err != nil {
+		return nil, err
+	}
+
+	created := &tb.ChatInviteLink{}
+	if link != nil {
+		*created = *link
+	}
+	created.InviteLink = "https://t.me/+" + strconv.FormatInt(chatID, 10) + "_" + strconv.Itoa(f.nextMessageID())
+	created.Creator = f.User
+
+	return created, nil
+}
+
+func (f *Fake) RevokeInviteLink(chat tb.Recipient, link string) (*tb.ChatInviteLink, error) {
+	err := f.record(Call{Method: "RevokeInviteLink", ChatID: recipientID(chat), What: link})
+	if err != nil {
+		return nil, err
+	}
+
+	return &tb.ChatInviteLink{InviteLink: link, Creator: f.User, IsRevoked: true}, nil
+}
+
+func (f *Fake) DeclineJoinRequest(chat tb.Recipient, user *tb.User) error {
+	return f.record(Call{Method: "DeclineJoinRequest", ChatID: recipientID(chat), UserID: user.ID})
+}
+
+// recipientID returns the ID of the chat or the user, or zero
+// if it's a username.
+func recipientID(recipient tb.Recipient) int64 {
+	switch r := recipient.(type) {
+	case *tb.Chat:
+		return r.ID
+	case *tb.User:
+		return r.ID
+	}
+
+	id, _ := strconv.ParseInt(recipient.Recipient(), 10, 64)
+	return id
+}
+
+func memberID(member *tb.ChatMember) int64 {
+	if member == nil || member.User == nil {
+		return 0
+	}
+
+	return member.User.ID
+}
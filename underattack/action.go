@@ -0,0 +1,199 @@
+package underattack
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"captcha-lite/locale"
+	"captcha-lite/logger"
+
+	tb "gopkg.in/telebot.v3"
+)
+
+// Action is what happens to a user that joins the group
+// while the under attack mode is in effect.
+type Action string
+
+const (
+	// ActionBan bans the user forever.
+	ActionBan Action = "ban"
+	// ActionTemporaryBan bans the user until the under attack mode ends.
+	ActionTemporaryBan Action = "tempban"
+	// ActionKick removes the user from the group, but they can join again.
+	ActionKick Action = "kick"
+	// ActionRestrict keeps the user on the group, but they can't send
+	// anything until the under attack mode ends.
+	ActionRestrict Action = "restrict"
+	// ActionDecline declines the join request of the user. Users that
+	// join directly can't be declined, so they will be kicked instead.
+	ActionDecline Action = "decline"
+)
+
+// Actions lists every valid Action.
+var Actions = []Action{ActionBan, ActionTemporaryBan, ActionKick, ActionRestrict, ActionDecline}
+
+// ParseAction parses the action name, case-insensitively.
+func ParseAction(s string) (Action, error) {
+	for _, action := range Actions {
+		if strings.EqualFold(s, string(action)) {
+			return action, nil
+		}
+	}
+
+	return "", fmt.Errorf("unknown action: %q", s)
+}
+
+// action returns the action of the given entry, or the default
+// action if the group has not chosen one.
+func (d *Dependency) action(entry UnderAttack) Action {
+	if entry.Action != "" {
+		return entry.Action
+	}
+
+	if d.DefaultAction != "" {
+		return d.DefaultAction
+	}
+
+	return ActionBan
+}
+
+// activeAction returns the action that is in force during the current
+// under attack mode of the given entry, or the action of the group if
+// none was recorded.
+func (d *Dependency) activeAction(entry UnderAttack) Action {
+	if entry.ActiveAction != "" {
+		return entry.ActiveAction
+	}
+
+	return d.action(entry)
+}
+
+// describe returns the localized description of the action,
+// that fits into "every user that is joining the group will be ...".
+func (d *Dependency) describe(action Action) string {
+	switch action {
+	case ActionTemporaryBan:
+		return d.Locale[locale.MessageUnderAttackActionTemporaryBan]
+	case ActionKick:
+		return d.Locale[locale.MessageUnderAttackActionKick]
+	case ActionRestrict:
+		return d.Locale[locale.MessageUnderAttackActionRestrict]
+	case ActionDecline:
+		return d.Locale[locale.MessageUnderAttackActionDecline]
+	default:
+		return d.Locale[locale.MessageUnderAttackActionBan]
+	}
+}
+
+// ActOnJoin applies the action of the group to the user that just joined,
+// if the group is on under attack mode. It returns true if the join has
+// been dealt with.
+func (d *Dependency) ActOnJoin(ctx context.Context, chat *tb.Chat, user *tb.User) (bool, error) {
+	entry, active, err := d.state(ctx, chat.ID)
+	if err != nil {
+		return false, err
+	}
+
+	if !active {
+		return false, nil
+	}
+
+	action := d.activeAction(entry)
+	until := actionUntil(entry.ExpiresAt)
+
+	switch action {
+	case ActionBan:
+		err = d.Bot.Ban(chat, &tb.ChatMember{User: user, RestrictedUntil: tb.Forever()})
+	case ActionTemporaryBan:
+		err = d.Bot.Ban(chat, &tb.ChatMember{User: user, RestrictedUntil: until})
+	case ActionRestrict:
+		err = d.Bot.Restrict(chat, &tb.ChatMember{User: user, Rights: tb.NoRights(), RestrictedUntil: until})
+	case ActionKick, ActionDecline:
+		// A user that has joined can't be declined anymore.
+		err = d.kick(chat, user)
+	}
+	if err != nil {
+		return true, err
+	}
+
+	if action == ActionBan || action == ActionTemporaryBan {
+		d.recordBan(ctx, chat, user, entry)
+	}
+
+	d.Logger.Info(
+		"acted on a new member during under attack mode",
+		logger.ChatID(chat.ID),
+		logger.UserID(user.ID),
+		logger.F("action", string(action)),
+	)
+
+	return true, nil
+}
+
+// ActOnJoinRequest declines the join request of the user if the group is
+// on under attack mode. If the action of the group is a ban, the user will
+// be banned as well, so they can't send another request.
+func (d *Dependency) ActOnJoinRequest(ctx context.Context, chat *tb.Chat, user *tb.User) (bool, error) {
+	entry, active, err := d.state(ctx, chat.ID)
+	if err != nil {
+		return false, err
+	}
+
+	if !active {
+		return false, nil
+	}
+
+	action := d.activeAction(entry)
+
+	err = d.Bot.DeclineJoinRequest(chat, user)
+	if err != nil {
+		return true, err
+	}
+
+	switch action {
+	case ActionBan:
+		err = d.Bot.Ban(chat, &tb.ChatMember{User: user, RestrictedUntil: tb.Forever()})
+	case ActionTemporaryBan:
+		err = d.Bot.Ban(chat, &tb.ChatMember{User: user, RestrictedUntil: actionUntil(entry.ExpiresAt)})
+	}
+	if err != nil {
+		return true, err
+	}
+
+	if action == ActionBan || action == ActionTemporaryBan {
+		d.recordBan(ctx, chat, user, entry)
+	}
+
+	d.Logger.Info(
+		"declined a join request during under attack mode",
+		logger.ChatID(chat.ID),
+		logger.UserID(user.ID),
+		logger.F("action", string(action)),
+	)
+
+	return true, nil
+}
+
+// kick bans the user, then unbans them right away,
+// so they are removed but can join again.
+func (d *Dependency) kick(chat *tb.Chat, user *tb.User) error {
+	err := d.Bot.Ban(chat, &tb.ChatMember{User: user, RestrictedUntil: tb.Forever()})
+	if err != nil {
+		return err
+	}
+
+	return d.Bot.Unban(chat, user, true)
+}
+
+// actionUntil returns the unix time of when a temporary action should be
+// lifted. Telegram treats anything shorter than 30 seconds as forever,
+// so it's never sooner than a minute from now.
+func actionUntil(expiresAt time.Time) int64 {
+	if limit := time.Now().Add(time.Minute); expiresAt.Before(limit) {
+		return limit.Unix()
+	}
+
+	return expiresAt.Unix()
+}
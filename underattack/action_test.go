@@ -0,0 +1,34 @@
+package underattack_test
+
+import (
+	"testing"
+
+	"captcha-lite/underattack"
+)
+
+func TestParseAction(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected underattack.Action
+		wantErr  bool
+	}{
+		{input: "ban", expected: underattack.ActionBan},
+		{input: "TempBan", expected: underattack.ActionTemporaryBan},
+		{input: "kick", expected: underattack.ActionKick},
+		{input: "restrict", expected: underattack.ActionRestrict},
+		{input: "decline", expected: underattack.ActionDecline},
+		{input: "", wantErr: true},
+		{input: "nuke", wantErr: true},
+	}
+
+	for _, test := range tests {
+		action, err := underattack.ParseAction(test.input)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%q: unexpected error: %v", test.input, err)
+		}
+
+		if action != test.expected {
+			t.Errorf("%q: expecting %q, got %q", test.input, test.expected, action)
+		}
+	}
+}
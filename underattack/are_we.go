@@ -7,41 +7,83 @@ import (
 	"strconv"
 	"time"
 
-	"github.com/allegro/bigcache/v3"
+	"captcha-lite/cache"
+	"captcha-lite/logger"
 )
 
 // AreWe ...on under attack mode?
 func (d *Dependency) AreWe(ctx context.Context, chatID int64) (bool, error) {
+	_, active, err := d.state(ctx, chatID)
+	return active, err
+}
+
+// state returns the under attack entry of the chat, and whether the under
+// attack mode is in effect. It's in effect if it has been turned on, or if
+// a window of the schedules has started but the scheduler hasn't run it
+// yet. For the latter, the ExpiresAt of the entry is the end of the window.
+func (d *Dependency) state(ctx context.Context, chatID int64) (UnderAttack, bool, error) {
+	entry, err := d.entry(ctx, chatID)
+	if err != nil {
+		return UnderAttack{}, false, err
+	}
+
+	if entry.active() {
+		return entry, true, nil
+	}
+
+	_, end, ok, err := d.pendingSchedule(ctx, chatID)
+	if err != nil {
+		return UnderAttack{}, false, err
+	}
+
+	if ok {
+		entry.ExpiresAt = end
+		return entry, true, nil
+	}
+
+	return entry, false, nil
+}
+
+// entry acquires the under attack entry of the chat,
+// from the cache if it's there, or from the datastore.
+func (d *Dependency) entry(ctx context.Context, chatID int64) (UnderAttack, error) {
 	underAttackCache, err := d.Memory.Get("underattack:" + strconv.FormatInt(chatID, 10))
-	if err != nil && !errors.Is(err, bigcache.ErrEntryNotFound) {
-		return false, err
+	if err != nil && !errors.Is(err, cache.ErrNotFound) {
+		return UnderAttack{}, err
 	}
 
 	if err == nil {
 		var entry UnderAttack
 		err := json.Unmarshal(underAttackCache, &entry)
 		if err != nil {
-			return false, err
+			return UnderAttack{}, err
 		}
 
-		return entry.IsUnderAttack && entry.ExpiresAt.After(time.Now()), nil
+		return entry, nil
 	}
 
 	// Cache was not found
+	d.Logger.Debug("under attack cache miss, fetching from datastore", logger.ChatID(chatID))
+
 	underAttackEntry, err := d.Datastore.GetUnderAttackEntry(ctx, chatID)
 	if err != nil {
-		return false, err
+		return UnderAttack{}, err
 	}
 
 	marshaledEntry, err := json.Marshal(underAttackEntry)
 	if err != nil {
-		return false, err
+		return UnderAttack{}, err
 	}
 
-	err = d.Memory.Set("underattack:"+strconv.FormatInt(chatID, 10), marshaledEntry)
+	err = d.Memory.SetWithTTL("underattack:"+strconv.FormatInt(chatID, 10), marshaledEntry, cacheTTL)
 	if err != nil {
-		return false, err
+		return UnderAttack{}, err
 	}
 
-	return underAttackEntry.IsUnderAttack && underAttackEntry.ExpiresAt.After(time.Now()), nil
+	return underAttackEntry, nil
+}
+
+// active returns true if the under attack mode is in effect.
+func (u UnderAttack) active() bool {
+	return u.IsUnderAttack && u.ExpiresAt.After(time.Now())
 }
@@ -0,0 +1,104 @@
+package underattack
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"captcha-lite/locale"
+	"captcha-lite/logger"
+
+	tb "gopkg.in/telebot.v3"
+)
+
+// RecordJoin feeds a user join on the given chat to the join rate detector.
+// When the detector trips, the under attack mode will be turned on
+// automatically, and every admin of the group will be notified.
+//
+// It returns true if the under attack mode has just been turned on.
+func (d *Dependency) RecordJoin(ctx context.Context, chat *tb.Chat) (bool, error) {
+	if d.Detector == nil {
+		return false, nil
+	}
+
+	now := time.Now()
+	joins, tripped := d.Detector.Record(chat.ID, now)
+	if !tripped {
+		return false, nil
+	}
+
+	// Someone might have beaten us to it.
+	underAttackModeEnabled, err := d.AreWe(ctx, chat.ID)
+	if err != nil {
+		return false, err
+	}
+
+	if underAttackModeEnabled {
+		d.Detector.Trip(chat.ID, now)
+		return false, nil
+	}
+
+	entry, err := d.entry(ctx, chat.ID)
+	if err != nil {
+		return false, err
+	}
+
+	expiresAt := time.Now().Add(DefaultDuration)
+	replacer := strings.NewReplacer(
+		"{{joins}}", strconv.Itoa(joins),
+		"{{window}}", d.Detector.Window.String(),
+		"{{group}}", chat.Title,
+		"{{expiresAt}}", d.formatTime(expiresAt, entry.Timezone),
+	)
+
+	_, err = d.enable(ctx, chat, expiresAt, replacer.Replace(d.Locale[locale.MessageUnderAttackAutomatic]), 0)
+	if err != nil {
+		// Not committing the trip lets the next join of the raid
+		// try again, rather than waiting out the cooldown.
+		return false, err
+	}
+
+	d.Detector.Trip(chat.ID, now)
+
+	d.Logger.Warn(
+		"under attack mode enabled automatically",
+		logger.ChatID(chat.ID),
+		logger.F("joins", joins),
+		logger.F("window", d.Detector.Window.String()),
+		logger.F("expires_at", expiresAt),
+	)
+
+	// Every admin is notified on an automatic activation, whether
+	// they have opted in or not, as nobody has seen it coming.
+	d.notifyAdmins(chat, replacer.Replace(d.Locale[locale.MessageUnderAttackAutomaticAdmin]))
+
+	return true, nil
+}
+
+// notifyAdmins sends the message privately to every admin of the chat.
+// The bot can only send a private message to the admins that have
+// started a conversation with it, so failures are expected.
+func (d *Dependency) notifyAdmins(chat *tb.Chat, message string) {
+	admins, err := d.Bot.AdminsOf(chat)
+	if err != nil {
+		d.Logger.HandleError(err)
+		return
+	}
+
+	for _, admin := range admins {
+		if admin.User == nil || admin.User.IsBot {
+			continue
+		}
+
+		_, err := d.Bot.Send(admin.User, message, &tb.SendOptions{DisableWebPagePreview: true})
+		if err != nil {
+			d.Logger.Debug(
+				"could not notify admin privately",
+				logger.ChatID(chat.ID),
+				logger.UserID(admin.User.ID),
+				logger.F("error", err.Error()),
+			)
+		}
+	}
+}
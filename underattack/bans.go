@@ -0,0 +1,313 @@
+package underattack
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"captcha-lite/locale"
+	"captcha-lite/logger"
+	"captcha-lite/utils"
+
+	tb "gopkg.in/telebot.v3"
+)
+
+// AttackBansButtonUnique is the unique identifier of the pagination
+// buttons on the /attackbans message.
+const AttackBansButtonUnique = "underattack_bans"
+
+// AttackBansPageSize is the number of bans shown on a single page.
+const AttackBansPageSize = 10
+
+// recordBan records the user that was just banned by the under attack mode,
+// so the admins can find and unban them later.
+func (d *Dependency) recordBan(ctx context.Context, chat *tb.Chat, user *tb.User, entry UnderAttack) {
+	err := d.Datastore.CreateBan(ctx, Ban{
+		GroupID:  chat.ID,
+		UserID:   user.ID,
+		Name:     strings.TrimSpace(user.FirstName + utils.ShouldAddSpace(user) + user.LastName),
+		Username: user.Username,
+		AttackID: entry.NotificationMessageID,
+		BannedAt: time.Now(),
+	})
+	if err != nil {
+		d.Logger.HandleError(err)
+	}
+}
+
+// AttackBansHandler provides a handler for /attackbans command.
+func (d *Dependency) AttackBansHandler(c tb.Context) error {
+	if c.Message().Private() || c.Sender().IsBot {
+		return nil
+	}
+
+	if !d.requireAdmin(c) {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute*1)
+	defer cancel()
+
+	text, markup, err := d.renderAttackBans(ctx, c.Chat().ID, 0)
+	if err != nil {
+		d.Logger.HandleBotError(err, d.Bot, c.Message())
+		return nil
+	}
+
+	_, err = d.Bot.Send(
+		c.Chat(),
+		text,
+		&tb.SendOptions{
+			ReplyTo:               c.Message(),
+			AllowWithoutReply:     true,
+			DisableWebPagePreview: true,
+			ReplyMarkup:           markup,
+		},
+	)
+	if err != nil {
+		d.Logger.HandleBotError(err, d.Bot, c.Message())
+	}
+
+	return nil
+}
+
+// AttackBansCallback handles the pagination buttons on the /attackbans message.
+func (d *Dependency) AttackBansCallback(c tb.Context) error {
+	if c.Callback() == nil || c.Chat() == nil {
+		return nil
+	}
+
+	admins, err := d.Bot.AdminsOf(c.Chat())
+	if err != nil {
+		d.Logger.HandleError(err)
+		return nil
+	}
+
+	if !utils.IsAdmin(admins, c.Sender()) {
+		err := c.Respond(&tb.CallbackResponse{Text: d.Locale[locale.MessageUnderAttackOnlyAdmin]})
+		if err != nil {
+			d.Logger.HandleError(err)
+		}
+		return nil
+	}
+
+	page, err := strconv.Atoi(c.Callback().Data)
+	if err != nil || page < 0 {
+		page = 0
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute*1)
+	defer cancel()
+
+	text, markup, err := d.renderAttackBans(ctx, c.Chat().ID, page)
+	if err != nil {
+		d.Logger.HandleError(err)
+		return nil
+	}
+
+	_, err = d.Bot.Edit(
+		c.Callback().Message,
+		text,
+		&tb.SendOptions{
+			DisableWebPagePreview: true,
+			ReplyMarkup:           markup,
+		},
+	)
+	if err != nil && !strings.Contains(err.Error(), "message is not modified") {
+		d.Logger.HandleError(err)
+	}
+
+	err = c.Respond()
+	if err != nil {
+		d.Logger.HandleError(err)
+	}
+
+	return nil
+}
+
+// AttackUnbanHandler provides a handler for /attackunban command.
+// The argument is either "all", or the ID of the user to unban.
+func (d *Dependency) AttackUnbanHandler(c tb.Context) error {
+	if c.Message().Private() || c.Sender().IsBot {
+		return nil
+	}
+
+	if !d.requireAdmin(c) {
+		return nil
+	}
+
+	args := c.Args()
+	if len(args) != 1 {
+		d.reply(c, d.Locale[locale.MessageAttackUnbanUsage])
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute*5)
+	defer cancel()
+
+	var unbanned int
+	if strings.EqualFold(args[0], "all") {
+		var err error
+		unbanned, err = d.unbanAll(ctx, c.Chat())
+		if err != nil {
+			d.Logger.HandleBotError(err, d.Bot, c.Message())
+		}
+	} else {
+		userID, err := strconv.ParseInt(args[0], 10, 64)
+		if err != nil {
+			d.reply(c, d.Locale[locale.MessageAttackUnbanUsage])
+			return nil
+		}
+
+		// Only the bans of the under attack mode are lifted, not
+		// the ones that an admin has made.
+		_, ok, err := d.Datastore.GetBan(ctx, c.Chat().ID, userID)
+		if err != nil {
+			d.Logger.HandleBotError(err, d.Bot, c.Message())
+			return nil
+		}
+
+		if !ok {
+			d.reply(c, d.Locale[locale.MessageAttackUnbanNotFound])
+			return nil
+		}
+
+		err = d.unban(ctx, c.Chat(), userID)
+		if err != nil {
+			d.Logger.HandleBotError(err, d.Bot, c.Message())
+			return nil
+		}
+
+		unbanned = 1
+	}
+
+	d.reply(c, strings.Replace(d.Locale[locale.MessageAttackUnbanned], "{{count}}", strconv.Itoa(unbanned), 1))
+
+	d.Logger.Info(
+		"lifted under attack bans",
+		logger.ChatID(c.Chat().ID),
+		logger.UserID(c.Sender().ID),
+		logger.F("count", unbanned),
+	)
+
+	return nil
+}
+
+// unban lifts the ban of the user and removes its record.
+func (d *Dependency) unban(ctx context.Context, chat *tb.Chat, userID int64) error {
+	err := d.Bot.Unban(chat, &tb.User{ID: userID}, true)
+	if err != nil {
+		return err
+	}
+
+	return d.Datastore.DeleteBan(ctx, chat.ID, userID)
+}
+
+// unbanAll lifts every recorded ban of the chat. The ones that fail
+// are skipped and kept on the record, so they can be retried.
+func (d *Dependency) unbanAll(ctx context.Context, chat *tb.Chat) (int, error) {
+	var unbanned, failed int
+	for {
+		bans, err := d.Datastore.GetBans(ctx, chat.ID, AttackBansPageSize, failed)
+		if err != nil {
+			return unbanned, err
+		}
+
+		if len(bans) == 0 {
+			return unbanned, nil
+		}
+
+		for _, ban := range bans {
+			err := d.unban(ctx, chat, ban.UserID)
+			if err != nil {
+				d.Logger.HandleError(err)
+				failed++
+				continue
+			}
+
+			unbanned++
+		}
+	}
+}
+
+// renderAttackBans renders the given page of the bans of the chat.
+func (d *Dependency) renderAttackBans(ctx context.Context, chatID int64, page int) (string, *tb.ReplyMarkup, error) {
+	total, err := d.Datastore.CountBans(ctx, chatID)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if total == 0 {
+		return d.Locale[locale.MessageAttackBansEmpty], nil, nil
+	}
+
+	pages := (total + AttackBansPageSize - 1) / AttackBansPageSize
+	if page >= pages {
+		page = pages - 1
+	}
+
+	bans, err := d.Datastore.GetBans(ctx, chatID, AttackBansPageSize, page*AttackBansPageSize)
+	if err != nil {
+		return "", nil, err
+	}
+
+	entry, err := d.entry(ctx, chatID)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var lines []string
+	for i, ban := range bans {
+		line := strconv.Itoa(page*AttackBansPageSize+i+1) + ". " + ban.Name
+		if ban.Username != "" {
+			line += " (@" + ban.Username + ")"
+		}
+		line += " - " + strconv.FormatInt(ban.UserID, 10) + " - " + d.formatTime(ban.BannedAt, entry.Timezone)
+
+		lines = append(lines, line)
+	}
+
+	text := strings.NewReplacer(
+		"{{page}}", strconv.Itoa(page+1),
+		"{{pages}}", strconv.Itoa(pages),
+		"{{total}}", strconv.Itoa(total),
+		"{{bans}}", strings.Join(lines, "\n"),
+	).Replace(d.Locale[locale.MessageAttackBans])
+
+	markup := &tb.ReplyMarkup{}
+	var buttons []tb.Btn
+	if page > 0 {
+		buttons = append(buttons, markup.Data(d.Locale[locale.MessageAttackBansPrevious], AttackBansButtonUnique, strconv.Itoa(page-1)))
+	}
+	if page < pages-1 {
+		buttons = append(buttons, markup.Data(d.Locale[locale.MessageAttackBansNext], AttackBansButtonUnique, strconv.Itoa(page+1)))
+	}
+	if len(buttons) > 0 {
+		markup.Inline(markup.Row(buttons...))
+	} else {
+		// Removes the buttons when editing to a single page.
+		markup.Inline()
+	}
+
+	return text, markup, nil
+}
+
+// requireAdmin returns true if the sender is an admin of the chat.
+// Otherwise, it tells them that only the admins can do it.
+func (d *Dependency) requireAdmin(c tb.Context) bool {
+	admins, err := d.Bot.AdminsOf(c.Chat())
+	if err != nil {
+		d.Logger.HandleBotError(err, d.Bot, c.Message())
+		return false
+	}
+
+	if !utils.IsAdmin(admins, c.Sender()) {
+		d.Logger.Warn("non-admin tried to run an under attack command", logger.ChatID(c.Chat().ID), logger.UserID(c.Sender().ID))
+
+		d.reply(c, d.Locale[locale.MessageUnderAttackOnlyAdmin])
+		return false
+	}
+
+	return true
+}
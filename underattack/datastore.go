@@ -9,6 +9,27 @@ type Datastore interface {
 	Migrate(ctx context.Context) error
 	GetUnderAttackEntry(ctx context.Context, groupID int64) (UnderAttack, error)
 	CreateNewEntry(ctx context.Context, groupID int64) error
-	SetUnderAttackStatus(ctx context.Context, groupID int64, underAttack bool, expiresAt time.Time, notificationMessageID int64) error
+	SetUnderAttackStatus(ctx context.Context, groupID int64, underAttack bool, expiresAt time.Time, notificationMessageID int64, activeAction Action) error
+	GetExpiredUnderAttackEntries(ctx context.Context, before time.Time) ([]UnderAttack, error)
+	SetUnderAttackAction(ctx context.Context, groupID int64, action Action) error
+	CreateBan(ctx context.Context, ban Ban) error
+	GetBans(ctx context.Context, groupID int64, limit int, offset int) ([]Ban, error)
+	GetBan(ctx context.Context, groupID int64, userID int64) (Ban, bool, error)
+	CountBans(ctx context.Context, groupID int64) (int, error)
+	DeleteBan(ctx context.Context, groupID int64, userID int64) error
+	CountBansByAttack(ctx context.Context, groupID int64, attackID int64) (int, error)
+	SetUnderAttackEnabledBy(ctx context.Context, groupID int64, userID int64) error
+	SetLockdownPermissions(ctx context.Context, groupID int64, permissions string) error
+	SetInviteLink(ctx context.Context, groupID int64, inviteLink string) error
+	SetTimezone(ctx context.Context, groupID int64, timezone string) error
+	CreateSchedule(ctx context.Context, schedule Schedule) (int64, error)
+	GetSchedules(ctx context.Context, groupID int64) ([]Schedule, error)
+	GetAllSchedules(ctx context.Context) ([]Schedule, error)
+	DeleteSchedule(ctx context.Context, groupID int64, scheduleID int64) (bool, error)
+	SetScheduleLastRunAt(ctx context.Context, scheduleID int64, lastRunAt time.Time) error
+	SetNotificationSubscription(ctx context.Context, groupID int64, userID int64, subscribed bool) error
+	GetNotificationSubscribers(ctx context.Context, groupID int64) ([]int64, error)
+	InvalidateGroup(ctx context.Context, groupID int64) error
+	GetInvalidatedGroups(ctx context.Context, since time.Time) ([]int64, error)
 	Close() error
 }
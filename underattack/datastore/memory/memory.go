@@ -5,7 +5,10 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"captcha-lite/logger"
@@ -17,8 +20,40 @@ import (
 type memoryDatastore struct {
 	db     *bigcache.BigCache
 	logger logger.Logger
+	// entriesMu guards the under attack entries. It is taken before
+	// recordsMu when both are needed.
+	entriesMu sync.Mutex
+	// An attack can outlive the life window of the cache, so the
+	// entries are kept here rather than on the cache, which would
+	// evict them while the group is still locked down.
+	entries map[int64]underattack.UnderAttack
+	// recordsMu guards the records below.
+	recordsMu sync.Mutex
+	// The records outlive any attack, so they are kept here
+	// rather than on the cache as well.
+	bans        map[int64][]underattack.Ban
+	subscribers map[int64][]int64
+	schedules   map[int64][]underattack.Schedule
+	// lastScheduleID is the ID of the last created schedule.
+	lastScheduleID int64
 }
 
+// These prefix the keys of the records of a group on the cache, to tell
+// them apart from the under attack entries, which are keyed by the bare
+// group ID.
+const (
+	bansKeyPrefix        = "bans:"
+	subscribersKeyPrefix = "subscribers:"
+	schedulesKeyPrefix   = "schedules:"
+)
+
+// invalidationsKey keeps when each group was last invalidated.
+const invalidationsKey = "invalidations"
+
+// NewInMemoryDatastore creates a datastore on the given cache. The entries
+// and the records that are on the cache, as restored from a snapshot, are
+// loaded. Call Persist before taking a snapshot of the cache for them to
+// be saved.
 func NewInMemoryDatastore(db *bigcache.BigCache, logger logger.Logger) (*memoryDatastore, error) {
 	if db == nil {
 		return nil, fmt.Errorf("nil db")
@@ -28,7 +63,21 @@ func NewInMemoryDatastore(db *bigcache.BigCache, logger logger.Logger) (*memoryD
 		return nil, fmt.Errorf("nil logger")
 	}
 
-	return &memoryDatastore{db: db, logger: logger}, nil
+	m := &memoryDatastore{
+		db:          db,
+		logger:      logger,
+		entries:     make(map[int64]underattack.UnderAttack),
+		bans:        make(map[int64][]underattack.Ban),
+		subscribers: make(map[int64][]int64),
+		schedules:   make(map[int64][]underattack.Schedule),
+	}
+
+	err := m.load()
+	if err != nil {
+		return nil, err
+	}
+
+	return m, nil
 }
 
 func (m *memoryDatastore) Migrate(ctx context.Context) error {
@@ -37,70 +86,512 @@ func (m *memoryDatastore) Migrate(ctx context.Context) error {
 }
 
 func (m *memoryDatastore) GetUnderAttackEntry(ctx context.Context, groupID int64) (underattack.UnderAttack, error) {
-	value, err := m.db.Get(strconv.FormatInt(groupID, 10))
-	if err != nil {
-		if errors.Is(err, bigcache.ErrEntryNotFound) {
-			go func(groupID int64) {
-				time.Sleep(time.Second * 5)
-				ctx, cancel := context.WithTimeout(context.Background(), time.Second*15)
-				defer cancel()
-
-				err := m.CreateNewEntry(ctx, groupID)
-				if err != nil {
-					m.logger.HandleError(err)
-				}
-			}(groupID)
+	m.entriesMu.Lock()
+	entry, ok := m.entries[groupID]
+	m.entriesMu.Unlock()
 
-			return underattack.UnderAttack{}, nil
-		}
+	if !ok {
+		go func(groupID int64) {
+			time.Sleep(time.Second * 5)
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second*15)
+			defer cancel()
 
-		return underattack.UnderAttack{}, err
-	}
+			err := m.CreateNewEntry(ctx, groupID)
+			if err != nil {
+				m.logger.HandleError(err)
+			}
+		}(groupID)
 
-	var entry underattack.UnderAttack
-	err = json.Unmarshal(value, &entry)
-	if err != nil {
-		return underattack.UnderAttack{}, err
+		return underattack.UnderAttack{}, nil
 	}
 
 	return entry, nil
 }
 
 func (m *memoryDatastore) CreateNewEntry(ctx context.Context, groupID int64) error {
-	if _, err := m.db.Get(strconv.FormatInt(groupID, 10)); err != nil {
+	m.entriesMu.Lock()
+	defer m.entriesMu.Unlock()
+
+	if _, ok := m.entries[groupID]; ok {
 		// Do nothing if already exists
 		return nil
 	}
 
 	// Set a new one if not exists
-	value, err := json.Marshal(underattack.UnderAttack{
+	m.entries[groupID] = underattack.UnderAttack{
 		GroupID:               groupID,
 		IsUnderAttack:         false,
 		NotificationMessageID: 0,
 		ExpiresAt:             time.Time{},
 		UpdatedAt:             time.Now(),
+	}
+
+	return nil
+}
+
+func (m *memoryDatastore) SetUnderAttackStatus(ctx context.Context, groupID int64, underAttack bool, expiresAt time.Time, notificationMessageID int64, activeAction underattack.Action) error {
+	m.update(groupID, func(entry *underattack.UnderAttack) {
+		entry.IsUnderAttack = underAttack
+		entry.NotificationMessageID = notificationMessageID
+		entry.ExpiresAt = expiresAt
+		entry.ActiveAction = activeAction
+	})
+
+	return nil
+}
+
+func (m *memoryDatastore) SetUnderAttackAction(ctx context.Context, groupID int64, action underattack.Action) error {
+	m.update(groupID, func(entry *underattack.UnderAttack) {
+		entry.Action = action
 	})
+
+	return nil
+}
+
+func (m *memoryDatastore) SetUnderAttackEnabledBy(ctx context.Context, groupID int64, userID int64) error {
+	m.update(groupID, func(entry *underattack.UnderAttack) {
+		entry.EnabledBy = userID
+	})
+
+	return nil
+}
+
+func (m *memoryDatastore) SetLockdownPermissions(ctx context.Context, groupID int64, permissions string) error {
+	m.update(groupID, func(entry *underattack.UnderAttack) {
+		entry.LockdownPermissions = permissions
+	})
+
+	return nil
+}
+
+func (m *memoryDatastore) SetInviteLink(ctx context.Context, groupID int64, inviteLink string) error {
+	m.update(groupID, func(entry *underattack.UnderAttack) {
+		entry.InviteLink = inviteLink
+	})
+
+	return nil
+}
+
+func (m *memoryDatastore) SetTimezone(ctx context.Context, groupID int64, timezone string) error {
+	m.update(groupID, func(entry *underattack.UnderAttack) {
+		entry.Timezone = timezone
+	})
+
+	return nil
+}
+
+// update changes the entry of the group, or a new one if not exists.
+func (m *memoryDatastore) update(groupID int64, change func(entry *underattack.UnderAttack)) {
+	m.entriesMu.Lock()
+	defer m.entriesMu.Unlock()
+
+	entry := m.entries[groupID]
+	change(&entry)
+	entry.GroupID = groupID
+	entry.UpdatedAt = time.Now()
+	m.entries[groupID] = entry
+}
+
+func (m *memoryDatastore) GetExpiredUnderAttackEntries(ctx context.Context, before time.Time) ([]underattack.UnderAttack, error) {
+	m.entriesMu.Lock()
+	defer m.entriesMu.Unlock()
+
+	var entries []underattack.UnderAttack
+	for _, entry := range m.entries {
+		if entry.IsUnderAttack && !entry.ExpiresAt.After(before) {
+			entries = append(entries, entry)
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].ExpiresAt.Before(entries[j].ExpiresAt)
+	})
+
+	return entries, nil
+}
+
+func (m *memoryDatastore) CreateBan(ctx context.Context, ban underattack.Ban) error {
+	m.recordsMu.Lock()
+	defer m.recordsMu.Unlock()
+
+	bans := make([]underattack.Ban, 0, len(m.bans[ban.GroupID])+1)
+	for _, b := range m.bans[ban.GroupID] {
+		if b.UserID != ban.UserID {
+			bans = append(bans, b)
+		}
+	}
+
+	m.bans[ban.GroupID] = append(bans, ban)
+	return nil
+}
+
+func (m *memoryDatastore) GetBans(ctx context.Context, groupID int64, limit int, offset int) ([]underattack.Ban, error) {
+	m.recordsMu.Lock()
+	defer m.recordsMu.Unlock()
+
+	bans := append([]underattack.Ban(nil), m.bans[groupID]...)
+
+	sort.Slice(bans, func(i, j int) bool {
+		if bans[i].BannedAt.Equal(bans[j].BannedAt) {
+			return bans[i].UserID < bans[j].UserID
+		}
+
+		return bans[i].BannedAt.After(bans[j].BannedAt)
+	})
+
+	if offset >= len(bans) {
+		return nil, nil
+	}
+
+	bans = bans[offset:]
+	if limit < len(bans) {
+		bans = bans[:limit]
+	}
+
+	return bans, nil
+}
+
+func (m *memoryDatastore) GetBan(ctx context.Context, groupID int64, userID int64) (underattack.Ban, bool, error) {
+	m.recordsMu.Lock()
+	defer m.recordsMu.Unlock()
+
+	for _, ban := range m.bans[groupID] {
+		if ban.UserID == userID {
+			return ban, true, nil
+		}
+	}
+
+	return underattack.Ban{}, false, nil
+}
+
+func (m *memoryDatastore) CountBans(ctx context.Context, groupID int64) (int, error) {
+	m.recordsMu.Lock()
+	defer m.recordsMu.Unlock()
+
+	return len(m.bans[groupID]), nil
+}
+
+func (m *memoryDatastore) DeleteBan(ctx context.Context, groupID int64, userID int64) error {
+	m.recordsMu.Lock()
+	defer m.recordsMu.Unlock()
+
+	bans := make([]underattack.Ban, 0, len(m.bans[groupID]))
+	for _, ban := range m.bans[groupID] {
+		if ban.UserID != userID {
+			bans = append(bans, ban)
+		}
+	}
+
+	// The group is kept with no bans, so Persist overwrites the
+	// ones that were written to the cache before.
+	if _, ok := m.bans[groupID]; ok {
+		m.bans[groupID] = bans
+	}
+
+	return nil
+}
+
+func (m *memoryDatastore) CountBansByAttack(ctx context.Context, groupID int64, attackID int64) (int, error) {
+	m.recordsMu.Lock()
+	defer m.recordsMu.Unlock()
+
+	var count int
+	for _, ban := range m.bans[groupID] {
+		if ban.AttackID == attackID {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+func (m *memoryDatastore) SetNotificationSubscription(ctx context.Context, groupID int64, userID int64, subscribed bool) error {
+	m.recordsMu.Lock()
+	defer m.recordsMu.Unlock()
+
+	subscribers := make([]int64, 0, len(m.subscribers[groupID])+1)
+	for _, subscriber := range m.subscribers[groupID] {
+		if subscriber == userID {
+			if subscribed {
+				return nil
+			}
+
+			continue
+		}
+
+		subscribers = append(subscribers, subscriber)
+	}
+
+	if subscribed {
+		subscribers = append(subscribers, userID)
+	} else if _, ok := m.subscribers[groupID]; !ok {
+		return nil
+	}
+
+	m.subscribers[groupID] = subscribers
+	return nil
+}
+
+func (m *memoryDatastore) GetNotificationSubscribers(ctx context.Context, groupID int64) ([]int64, error) {
+	m.recordsMu.Lock()
+	defer m.recordsMu.Unlock()
+
+	if len(m.subscribers[groupID]) == 0 {
+		return nil, nil
+	}
+
+	return append([]int64(nil), m.subscribers[groupID]...), nil
+}
+
+func (m *memoryDatastore) CreateSchedule(ctx context.Context, schedule underattack.Schedule) (int64, error) {
+	m.recordsMu.Lock()
+	defer m.recordsMu.Unlock()
+
+	m.lastScheduleID++
+	schedule.ID = m.lastScheduleID
+
+	m.schedules[schedule.GroupID] = append(m.schedules[schedule.GroupID], schedule)
+	return schedule.ID, nil
+}
+
+func (m *memoryDatastore) GetSchedules(ctx context.Context, groupID int64) ([]underattack.Schedule, error) {
+	m.recordsMu.Lock()
+	defer m.recordsMu.Unlock()
+
+	if len(m.schedules[groupID]) == 0 {
+		return nil, nil
+	}
+
+	return append([]underattack.Schedule(nil), m.schedules[groupID]...), nil
+}
+
+func (m *memoryDatastore) GetAllSchedules(ctx context.Context) ([]underattack.Schedule, error) {
+	m.recordsMu.Lock()
+	defer m.recordsMu.Unlock()
+
+	var schedules []underattack.Schedule
+	for _, groupSchedules := range m.schedules {
+		schedules = append(schedules, groupSchedules...)
+	}
+
+	sort.Slice(schedules, func(i, j int) bool {
+		return schedules[i].ID < schedules[j].ID
+	})
+
+	return schedules, nil
+}
+
+func (m *memoryDatastore) DeleteSchedule(ctx context.Context, groupID int64, scheduleID int64) (bool, error) {
+	m.recordsMu.Lock()
+	defer m.recordsMu.Unlock()
+
+	schedules := m.schedules[groupID]
+	for i, schedule := range schedules {
+		if schedule.ID == scheduleID {
+			m.schedules[groupID] = append(append([]underattack.Schedule(nil), schedules[:i]...), schedules[i+1:]...)
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func (m *memoryDatastore) SetScheduleLastRunAt(ctx context.Context, scheduleID int64, lastRunAt time.Time) error {
+	m.recordsMu.Lock()
+	defer m.recordsMu.Unlock()
+
+	for _, schedules := range m.schedules {
+		for i := range schedules {
+			if schedules[i].ID == scheduleID {
+				schedules[i].LastRunAt = lastRunAt
+				return nil
+			}
+		}
+	}
+
+	return nil
+}
+
+// InvalidateGroup records that the cached state of the group is stale.
+func (m *memoryDatastore) InvalidateGroup(ctx context.Context, groupID int64) error {
+	m.recordsMu.Lock()
+	defer m.recordsMu.Unlock()
+
+	invalidations, err := m.getInvalidations()
 	if err != nil {
 		return err
 	}
 
-	return m.db.Set(strconv.FormatInt(groupID, 10), value)
+	invalidations[strconv.FormatInt(groupID, 10)] = time.Now()
+
+	value, err := json.Marshal(invalidations)
+	if err != nil {
+		return err
+	}
+
+	return m.db.Set(invalidationsKey, value)
 }
 
-func (m *memoryDatastore) SetUnderAttackStatus(ctx context.Context, groupID int64, underAttack bool, expiresAt time.Time, notificationMessageID int64) error {
-	// Set a new one if not exists
-	value, err := json.Marshal(underattack.UnderAttack{
-		GroupID:               groupID,
-		IsUnderAttack:         underAttack,
-		NotificationMessageID: notificationMessageID,
-		ExpiresAt:             expiresAt,
-		UpdatedAt:             time.Now(),
-	})
+// GetInvalidatedGroups returns the groups that were invalidated since the given time.
+func (m *memoryDatastore) GetInvalidatedGroups(ctx context.Context, since time.Time) ([]int64, error) {
+	m.recordsMu.Lock()
+	defer m.recordsMu.Unlock()
+
+	invalidations, err := m.getInvalidations()
+	if err != nil {
+		return nil, err
+	}
+
+	var groupIDs []int64
+	for key, invalidatedAt := range invalidations {
+		if invalidatedAt.Before(since) {
+			continue
+		}
+
+		groupID, err := strconv.ParseInt(key, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+
+		groupIDs = append(groupIDs, groupID)
+	}
+
+	return groupIDs, nil
+}
+
+func (m *memoryDatastore) getInvalidations() (map[string]time.Time, error) {
+	invalidations := make(map[string]time.Time)
+
+	value, err := m.db.Get(invalidationsKey)
+	if err != nil {
+		if errors.Is(err, bigcache.ErrEntryNotFound) {
+			return invalidations, nil
+		}
+
+		return nil, err
+	}
+
+	err = json.Unmarshal(value, &invalidations)
+	if err != nil {
+		return nil, err
+	}
+
+	return invalidations, nil
+}
+
+// Persist writes the entries and the records to the cache, so they are
+// saved along with its snapshot and loaded back on the next start.
+// Writing them again keeps the cache from evicting them at the end of
+// its life window.
+func (m *memoryDatastore) Persist() error {
+	m.entriesMu.Lock()
+	defer m.entriesMu.Unlock()
+
+	for groupID, entry := range m.entries {
+		err := m.setRecord("", groupID, entry)
+		if err != nil {
+			return err
+		}
+	}
+
+	m.recordsMu.Lock()
+	defer m.recordsMu.Unlock()
+
+	for groupID, bans := range m.bans {
+		err := m.setRecord(bansKeyPrefix, groupID, bans)
+		if err != nil {
+			return err
+		}
+	}
+
+	for groupID, subscribers := range m.subscribers {
+		err := m.setRecord(subscribersKeyPrefix, groupID, subscribers)
+		if err != nil {
+			return err
+		}
+	}
+
+	for groupID, schedules := range m.schedules {
+		err := m.setRecord(schedulesKeyPrefix, groupID, schedules)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m *memoryDatastore) setRecord(prefix string, groupID int64, record interface{}) error {
+	value, err := json.Marshal(record)
 	if err != nil {
 		return err
 	}
 
-	return m.db.Set(strconv.FormatInt(groupID, 10), value)
+	return m.db.Set(prefix+strconv.FormatInt(groupID, 10), value)
+}
+
+// load reads the entries and the records that Persist wrote to the cache,
+// as it might have been restored from a snapshot.
+func (m *memoryDatastore) load() error {
+	iterator := m.db.Iterator()
+	for iterator.SetNext() {
+		value, err := iterator.Value()
+		if err != nil {
+			return err
+		}
+
+		key := value.Key()
+
+		var record interface{}
+		groupKey := key
+		switch {
+		case strings.HasPrefix(key, bansKeyPrefix):
+			groupKey = strings.TrimPrefix(key, bansKeyPrefix)
+			record = &[]underattack.Ban{}
+		case strings.HasPrefix(key, subscribersKeyPrefix):
+			groupKey = strings.TrimPrefix(key, subscribersKeyPrefix)
+			record = &[]int64{}
+		case strings.HasPrefix(key, schedulesKeyPrefix):
+			groupKey = strings.TrimPrefix(key, schedulesKeyPrefix)
+			record = &[]underattack.Schedule{}
+		default:
+			// Only the entries are keyed by the bare group ID,
+			// the rest, like the invalidations, stay on the cache.
+			if _, err := strconv.ParseInt(key, 10, 64); err != nil {
+				continue
+			}
+
+			record = &underattack.UnderAttack{}
+		}
+
+		groupID, err := strconv.ParseInt(groupKey, 10, 64)
+		if err != nil {
+			return fmt.Errorf("parsing the group of %s: %w", key, err)
+		}
+
+		err = json.Unmarshal(value.Value(), record)
+		if err != nil {
+			return err
+		}
+
+		switch record := record.(type) {
+		case *underattack.UnderAttack:
+			m.entries[groupID] = *record
+		case *[]underattack.Ban:
+			m.bans[groupID] = *record
+		case *[]int64:
+			m.subscribers[groupID] = *record
+		case *[]underattack.Schedule:
+			m.schedules[groupID] = *record
+			for _, schedule := range *record {
+				if schedule.ID > m.lastScheduleID {
+					m.lastScheduleID = schedule.ID
+				}
+			}
+		}
+	}
+
+	return nil
 }
 
 func (m *memoryDatastore) Close() error {
@@ -11,6 +11,7 @@ import (
 	"captcha-lite/logger/noop"
 	"captcha-lite/underattack"
 	"captcha-lite/underattack/datastore/memory"
+	"captcha-lite/underattack/datastoretest"
 
 	"github.com/allegro/bigcache/v3"
 )
@@ -23,23 +24,24 @@ func TestMain(m *testing.M) {
 		log.Fatalf("Creating bigcache instance: %s", err.Error())
 	}
 
+	setupCtx, setupCancel := context.WithTimeout(context.Background(), time.Second*30)
+
+	// The entries are loaded from the cache, as if it was restored from a snapshot.
+	err = Seed(setupCtx, db)
+	if err != nil {
+		log.Fatalf("seeding data: %s", err.Error())
+	}
+
 	dependency, err = memory.NewInMemoryDatastore(db, noop.New())
 	if err != nil {
 		log.Fatalf("creating new postgres datastore: %s", err.Error())
 	}
 
-	setupCtx, setupCancel := context.WithTimeout(context.Background(), time.Second*30)
-
 	err = dependency.Migrate(setupCtx)
 	if err != nil {
 		log.Fatalf("migrating tables: %s", err.Error())
 	}
 
-	err = Seed(setupCtx, db)
-	if err != nil {
-		log.Fatalf("seeding data: %s", err.Error())
-	}
-
 	exitCode := m.Run()
 
 	setupCancel()
@@ -114,33 +116,163 @@ func TestGetUnderAttackEntry(t *testing.T) {
 		t.Errorf("expecting NotificationMessageID to be 1002, got: %v", entry.NotificationMessageID)
 	}
 }
+func TestDatastore(t *testing.T) {
+	datastoretest.Run(t, func(t *testing.T) underattack.Datastore {
+		db, err := bigcache.New(context.Background(), bigcache.DefaultConfig(time.Hour))
+		if err != nil {
+			t.Fatalf("creating bigcache instance: %s", err.Error())
+		}
+
+		datastore, err := memory.NewInMemoryDatastore(db, noop.New())
+		if err != nil {
+			t.Fatalf("creating new memory datastore: %s", err.Error())
+		}
 
-func TestGetUnderAttackEntry_NotExists(t *testing.T) {
+		return datastore
+	})
+}
+
+func TestNewInMemoryDatastore_RestoredSchedules(t *testing.T) {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
 	defer cancel()
 
-	_, err := dependency.GetUnderAttackEntry(ctx, 20)
+	db, err := bigcache.New(context.Background(), bigcache.DefaultConfig(time.Hour))
 	if err != nil {
-		t.Errorf("unexpected error: %v", err)
+		t.Fatalf("creating bigcache instance: %s", err.Error())
 	}
-}
 
-func TestCreateNewEntry(t *testing.T) {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
-	defer cancel()
+	// As if it was restored from a snapshot.
+	value, err := json.Marshal([]underattack.Schedule{{ID: 7, GroupID: 2, EndMinute: 60, Timezone: "UTC"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
 
-	err := dependency.CreateNewEntry(ctx, 2)
+	err = db.Set("schedules:2", value)
 	if err != nil {
-		t.Errorf("unexpected error: %v", err)
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	datastore, err := memory.NewInMemoryDatastore(db, noop.New())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer func() {
+		_ = datastore.Close()
+	}()
+
+	id, err := datastore.CreateSchedule(ctx, underattack.Schedule{GroupID: 2, StartMinute: 60, EndMinute: 120, Timezone: "UTC"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if id != 8 {
+		t.Errorf("expecting the new schedule to continue from the restored ones, got ID %d", id)
 	}
 }
 
-func TestSetUnderAttackStatus(t *testing.T) {
+func TestRecords(t *testing.T) {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
 	defer cancel()
 
-	err := dependency.SetUnderAttackStatus(ctx, 3, true, time.Now().Add(time.Minute*30), 1003)
+	db, err := bigcache.New(context.Background(), bigcache.DefaultConfig(time.Hour))
 	if err != nil {
-		t.Errorf("unexpected error: %v", err)
+		t.Fatalf("creating bigcache instance: %s", err.Error())
 	}
+
+	datastore, err := memory.NewInMemoryDatastore(db, noop.New())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer func() {
+		_ = datastore.Close()
+	}()
+
+	err = datastore.CreateBan(ctx, underattack.Ban{GroupID: 3, UserID: 10, AttackID: 1, BannedAt: time.Now()})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	err = datastore.SetNotificationSubscription(ctx, 3, 20, true)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	_, err = datastore.CreateSchedule(ctx, underattack.Schedule{GroupID: 3, EndMinute: 60, Timezone: "UTC"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	err = datastore.SetTimezone(ctx, 3, "Asia/Jakarta")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	err = datastore.SetUnderAttackAction(ctx, 3, underattack.ActionKick)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Longer than the life window of the cache.
+	expiresAt := time.Now().Add(underattack.MaxDuration)
+	err = datastore.SetUnderAttackStatus(ctx, 3, true, expiresAt, 1005, underattack.ActionKick)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	check := func(t *testing.T, datastore underattack.Datastore) {
+		t.Helper()
+
+		if count, err := datastore.CountBans(ctx, 3); err != nil || count != 1 {
+			t.Errorf("expecting 1 ban, got %d and %v", count, err)
+		}
+
+		if subscribers, err := datastore.GetNotificationSubscribers(ctx, 3); err != nil || len(subscribers) != 1 {
+			t.Errorf("expecting 1 subscriber, got %v and %v", subscribers, err)
+		}
+
+		if schedules, err := datastore.GetSchedules(ctx, 3); err != nil || len(schedules) != 1 {
+			t.Errorf("expecting 1 schedule, got %v and %v", schedules, err)
+		}
+
+		entry, err := datastore.GetUnderAttackEntry(ctx, 3)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if entry.Timezone != "Asia/Jakarta" || entry.Action != underattack.ActionKick {
+			t.Errorf("expecting the settings to be kept, got %q and %q", entry.Timezone, entry.Action)
+		}
+
+		if !entry.IsUnderAttack || !entry.ExpiresAt.Equal(expiresAt) || entry.NotificationMessageID != 1005 {
+			t.Errorf("expecting the attack to be kept, got %+v", entry)
+		}
+
+		expired, err := datastore.GetExpiredUnderAttackEntries(ctx, expiresAt)
+		if err != nil || len(expired) != 1 || expired[0].GroupID != 3 {
+			t.Errorf("expecting the attack to expire, got %v and %v", expired, err)
+		}
+	}
+
+	t.Run("Evicted from the cache", func(t *testing.T) {
+		err := db.Reset()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		check(t, datastore)
+	})
+
+	t.Run("Restored from a snapshot", func(t *testing.T) {
+		err := datastore.Persist()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		restored, err := memory.NewInMemoryDatastore(db, noop.New())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		check(t, restored)
+	})
 }
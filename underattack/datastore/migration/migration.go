@@ -0,0 +1,395 @@
+// Package migration runs the versioned schema migrations of the SQL
+// datastores. The applied versions are recorded on the schema_migrations
+// table, so every migration only runs once on a database.
+package migration
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Dialect is the SQL flavor of the database.
+type Dialect int
+
+const (
+	Postgres Dialect = iota
+	MySQL
+	SQLite
+)
+
+// ErrIrreversible is returned when rolling back a migration without Down.
+var ErrIrreversible = errors.New("migration can't be rolled back")
+
+// These keep two processes, like the replicas starting together, from
+// migrating the same database at once.
+const (
+	// lockName is the name of the MySQL lock.
+	lockName = "captcha-lite:schema_migrations"
+	// lockKey is the key of the Postgres advisory lock.
+	lockKey int64 = 0x63617074636861
+	// lockTimeout is how long to wait for the other process to finish.
+	lockTimeout = 5 * time.Minute
+)
+
+// sqliteBusyTimeout makes SQLite wait for the other process to commit,
+// rather than failing with "database is locked".
+var sqliteBusyTimeout = `PRAGMA busy_timeout = ` + strconv.FormatInt(lockTimeout.Milliseconds(), 10)
+
+// Migration is a single step of the schema. Up and Down are run in a
+// transaction, but keep in mind that MySQL commits implicitly on most
+// schema changes, so a failed migration there might be half applied.
+type Migration struct {
+	// Version orders the migrations. It must be unique and positive,
+	// and must never change once the migration is released.
+	Version int64
+	Name    string
+	Up      func(ctx context.Context, tx *sql.Tx) error
+	// Down reverts Up. Nil means the migration can't be rolled back.
+	Down func(ctx context.Context, tx *sql.Tx) error
+}
+
+// Status is a migration, and whether it has been applied.
+type Status struct {
+	Migration
+	Applied bool
+	// AppliedAt is zero if the migration has not been applied.
+	AppliedAt time.Time
+}
+
+// Exec returns a migration step that executes the statements in order.
+func Exec(statements ...string) func(ctx context.Context, tx *sql.Tx) error {
+	return func(ctx context.Context, tx *sql.Tx) error {
+		for _, statement := range statements {
+			_, err := tx.ExecContext(ctx, statement)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+}
+
+// ExecIgnoring is Exec, but a statement failing with an error that contains
+// the message is ignored. It's meant for the dialects that can't check for
+// the existence of a column or an index on the statement itself.
+// Don't use it on Postgres, where a failed statement aborts the transaction.
+func ExecIgnoring(message string, statements ...string) func(ctx context.Context, tx *sql.Tx) error {
+	return func(ctx context.Context, tx *sql.Tx) error {
+		for _, statement := range statements {
+			_, err := tx.ExecContext(ctx, statement)
+			if err != nil && !strings.Contains(err.Error(), message) {
+				return err
+			}
+		}
+
+		return nil
+	}
+}
+
+// Migrator applies and rolls back the migrations of a database.
+type Migrator struct {
+	db         *sql.DB
+	dialect    Dialect
+	migrations []Migration
+}
+
+// New creates a Migrator for the migrations, which are sorted by version.
+func New(db *sql.DB, dialect Dialect, migrations []Migration) (*Migrator, error) {
+	if db == nil {
+		return nil, fmt.Errorf("nil db")
+	}
+
+	sorted := make([]Migration, len(migrations))
+	copy(sorted, migrations)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Version < sorted[j].Version
+	})
+
+	for i, migration := range sorted {
+		if migration.Version <= 0 {
+			return nil, fmt.Errorf("migration %q must have a positive version", migration.Name)
+		}
+
+		if i > 0 && sorted[i-1].Version == migration.Version {
+			return nil, fmt.Errorf("duplicate migration version: %d", migration.Version)
+		}
+
+		if migration.Up == nil {
+			return nil, fmt.Errorf("migration %d has no up step", migration.Version)
+		}
+	}
+
+	return &Migrator{db: db, dialect: dialect, migrations: sorted}, nil
+}
+
+// Up applies every migration that has not been applied, in order, and
+// returns the applied ones. It stops on the first failing migration.
+// The migrations that another process applies meanwhile are skipped.
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	unlock, err := m.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for _, migration := range m.migrations {
+		if _, ok := applied[migration.Version]; ok {
+			continue
+		}
+
+		ran, err := m.run(ctx, migration.Version, false, migration.Up, `INSERT INTO schema_migrations (version, name, applied_at) VALUES (`+m.placeholder(1)+`, `+m.placeholder(2)+`, CURRENT_TIMESTAMP)`, migration.Version, migration.Name)
+		if err != nil {
+			return done, fmt.Errorf("applying migration %d (%s): %w", migration.Version, migration.Name, err)
+		}
+
+		if ran {
+			done = append(done, migration)
+		}
+	}
+
+	return done, nil
+}
+
+// Down rolls back the latest applied migration, and returns it. It
+// returns false if there is no applied migration.
+func (m *Migrator) Down(ctx context.Context) (Migration, bool, error) {
+	unlock, err := m.lock(ctx)
+	if err != nil {
+		return Migration{}, false, err
+	}
+	defer unlock()
+
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return Migration{}, false, err
+	}
+
+	for i := len(m.migrations) - 1; i >= 0; i-- {
+		migration := m.migrations[i]
+		if _, ok := applied[migration.Version]; !ok {
+			continue
+		}
+
+		if migration.Down == nil {
+			return migration, false, fmt.Errorf("rolling back migration %d (%s): %w", migration.Version, migration.Name, ErrIrreversible)
+		}
+
+		ran, err := m.run(ctx, migration.Version, true, migration.Down, `DELETE FROM schema_migrations WHERE version = `+m.placeholder(1), migration.Version)
+		if err != nil {
+			return migration, false, fmt.Errorf("rolling back migration %d (%s): %w", migration.Version, migration.Name, err)
+		}
+
+		return migration, ran, nil
+	}
+
+	return Migration{}, false, nil
+}
+
+// Status returns every migration, and whether it has been applied.
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]Status, 0, len(m.migrations))
+	for _, migration := range m.migrations {
+		appliedAt, ok := applied[migration.Version]
+		statuses = append(statuses, Status{Migration: migration, Applied: ok, AppliedAt: appliedAt})
+	}
+
+	return statuses, nil
+}
+
+// run runs the step of the migration and the bookkeeping statement
+// in a single transaction. The step is only run if the migration is
+// still in the state it's expected to be in, applied or not, as another
+// process might have just run it. It returns whether the step was run.
+func (m *Migrator) run(ctx context.Context, version int64, applied bool, step func(ctx context.Context, tx *sql.Tx) error, bookkeeping string, args ...interface{}) (bool, error) {
+	c, err := m.db.Conn(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		// There is no logger here, and the connection is returned
+		// to the pool anyway.
+		_ = c.Close()
+	}()
+
+	if m.dialect == SQLite {
+		_, err := c.ExecContext(ctx, sqliteBusyTimeout)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	tx, err := c.BeginTx(ctx, &sql.TxOptions{})
+	if err != nil {
+		return false, err
+	}
+
+	ran, err := m.runTx(ctx, tx, version, applied, step, bookkeeping, args...)
+	if err != nil || !ran {
+		if e := tx.Rollback(); e != nil && err == nil {
+			return false, e
+		}
+
+		return false, err
+	}
+
+	return true, tx.Commit()
+}
+
+func (m *Migrator) runTx(ctx context.Context, tx *sql.Tx, version int64, applied bool, step func(ctx context.Context, tx *sql.Tx) error, bookkeeping string, args ...interface{}) (bool, error) {
+	if m.dialect == SQLite {
+		// SQLite has no named locks. A write takes the lock of the
+		// database until the end of the transaction, like BEGIN
+		// IMMEDIATE would, before the version is checked. Versions
+		// are positive, so nothing is deleted.
+		_, err := tx.ExecContext(ctx, `DELETE FROM schema_migrations WHERE version < 0`)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	var count int
+	err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM schema_migrations WHERE version = `+m.placeholder(1), version).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+
+	if (count > 0) != applied {
+		return false, nil
+	}
+
+	err = step(ctx, tx)
+	if err != nil {
+		return false, err
+	}
+
+	_, err = tx.ExecContext(ctx, bookkeeping, args...)
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// lock waits for the other processes that are migrating the database,
+// and keeps them waiting until unlock is called. The lock is held by a
+// connection of its own. SQLite is locked by run on every migration
+// instead, and only waits for the database to be unlocked here.
+func (m *Migrator) lock(ctx context.Context) (unlock func(), err error) {
+	if m.dialect == SQLite {
+		_, err := m.db.ExecContext(ctx, sqliteBusyTimeout)
+		if err != nil {
+			return nil, err
+		}
+
+		return func() {}, nil
+	}
+
+	c, err := m.db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var release string
+	var arg interface{}
+	switch m.dialect {
+	case Postgres:
+		_, err = c.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, lockKey)
+		release, arg = `SELECT pg_advisory_unlock($1)`, lockKey
+	case MySQL:
+		var locked sql.NullInt64
+		err = c.QueryRowContext(ctx, `SELECT GET_LOCK(?, ?)`, lockName, int64(lockTimeout.Seconds())).Scan(&locked)
+		if err == nil && locked.Int64 != 1 {
+			err = fmt.Errorf("timed out waiting for the migration lock after %s", lockTimeout)
+		}
+		release, arg = `SELECT RELEASE_LOCK(?)`, lockName
+	}
+	if err != nil {
+		_ = c.Close()
+		return nil, fmt.Errorf("taking the migration lock: %w", err)
+	}
+
+	return func() {
+		// The context of the migration might be done already.
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+		defer cancel()
+
+		// Both locks belong to the session. If releasing fails, the
+		// session is closed rather than returned to the pool with it.
+		_, err := c.ExecContext(ctx, release, arg)
+		if err != nil {
+			_ = c.Raw(func(driverConn interface{}) error { return driver.ErrBadConn })
+		}
+
+		_ = c.Close()
+	}, nil
+}
+
+// applied creates the schema_migrations table if it does not exists,
+// and returns when each of the applied versions was applied.
+func (m *Migrator) applied(ctx context.Context) (map[int64]time.Time, error) {
+	timestampType := "DATETIME"
+	if m.dialect == Postgres {
+		timestampType = "TIMESTAMP"
+	}
+
+	_, err := m.db.ExecContext(
+		ctx,
+		`CREATE TABLE IF NOT EXISTS schema_migrations (
+			version BIGINT PRIMARY KEY,
+			name VARCHAR(255) NOT NULL,
+			applied_at `+timestampType+` NOT NULL
+		)`,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := m.db.QueryContext(ctx, `SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	applied := make(map[int64]time.Time)
+	for rows.Next() {
+		var version int64
+		var appliedAt time.Time
+		err := rows.Scan(&version, &appliedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		applied[version] = appliedAt
+	}
+
+	return applied, rows.Err()
+}
+
+func (m *Migrator) placeholder(n int) string {
+	if m.dialect == Postgres {
+		return "$" + strconv.Itoa(n)
+	}
+
+	return "?"
+}
@@ -0,0 +1,289 @@
+package migration_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"captcha-lite/underattack/datastore/migration"
+
+	_ "modernc.org/sqlite"
+)
+
+var migrations = []migration.Migration{
+	{
+		Version: 2,
+		Name:    "add b",
+		Up:      migration.Exec(`ALTER TABLE a ADD COLUMN b INTEGER NOT NULL DEFAULT 0`),
+		Down:    migration.Exec(`ALTER TABLE a DROP COLUMN b`),
+	},
+	{
+		Version: 1,
+		Name:    "create a",
+		Up:      migration.Exec(`CREATE TABLE a (id INTEGER PRIMARY KEY)`),
+		Down:    migration.Exec(`DROP TABLE a`),
+	},
+}
+
+func open(t *testing.T) *sql.DB {
+	t.Helper()
+
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("opening sqlite: %s", err.Error())
+	}
+	// Every connection to :memory: is a new database.
+	db.SetMaxOpenConns(1)
+
+	t.Cleanup(func() {
+		err := db.Close()
+		if err != nil {
+			t.Errorf("closing sqlite: %s", err.Error())
+		}
+	})
+
+	return db
+}
+
+func TestNew(t *testing.T) {
+	t.Run("Nil DB", func(t *testing.T) {
+		_, err := migration.New(nil, migration.SQLite, migrations)
+		if err == nil || err.Error() != "nil db" {
+			t.Errorf("expecting an error of 'nil db', instead got %v", err)
+		}
+	})
+
+	t.Run("Duplicate version", func(t *testing.T) {
+		_, err := migration.New(&sql.DB{}, migration.SQLite, append([]migration.Migration{migrations[0]}, migrations...))
+		if err == nil {
+			t.Error("expecting an error, got nil")
+		}
+	})
+
+	t.Run("Non positive version", func(t *testing.T) {
+		_, err := migration.New(&sql.DB{}, migration.SQLite, []migration.Migration{{Name: "zero", Up: migration.Exec()}})
+		if err == nil {
+			t.Error("expecting an error, got nil")
+		}
+	})
+
+	t.Run("No up step", func(t *testing.T) {
+		_, err := migration.New(&sql.DB{}, migration.SQLite, []migration.Migration{{Version: 1, Name: "nothing"}})
+		if err == nil {
+			t.Error("expecting an error, got nil")
+		}
+	})
+}
+
+func TestMigrator(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+	defer cancel()
+
+	db := open(t)
+
+	migrator, err := migration.New(db, migration.SQLite, migrations)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	applied, err := migrator.Up(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(applied) != 2 || applied[0].Version != 1 || applied[1].Version != 2 {
+		t.Fatalf("expecting version 1 then 2 to be applied, got %v", applied)
+	}
+
+	_, err = db.ExecContext(ctx, `INSERT INTO a (id, b) VALUES (1, 2)`)
+	if err != nil {
+		t.Errorf("expecting the schema to be migrated, got %v", err)
+	}
+
+	applied, err = migrator.Up(ctx)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if len(applied) != 0 {
+		t.Errorf("expecting nothing to be applied twice, got %v", applied)
+	}
+
+	statuses, err := migrator.Status(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, status := range statuses {
+		if !status.Applied || status.AppliedAt.IsZero() {
+			t.Errorf("expecting migration %d to be applied, got %+v", status.Version, status)
+		}
+	}
+
+	rolledBack, ok, err := migrator.Down(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !ok || rolledBack.Version != 2 {
+		t.Errorf("expecting version 2 to be rolled back, got %d (%t)", rolledBack.Version, ok)
+	}
+
+	statuses, err = migrator.Status(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !statuses[0].Applied || statuses[1].Applied {
+		t.Errorf("expecting only version 1 to be applied, got %+v", statuses)
+	}
+
+	_, ok, err = migrator.Down(ctx)
+	if err != nil || !ok {
+		t.Errorf("expecting version 1 to be rolled back, got %v (%t)", err, ok)
+	}
+
+	_, ok, err = migrator.Down(ctx)
+	if err != nil || ok {
+		t.Errorf("expecting nothing to be rolled back, got %v (%t)", err, ok)
+	}
+}
+
+func TestMigrator_Failure(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+	defer cancel()
+
+	db := open(t)
+
+	migrator, err := migration.New(db, migration.SQLite, []migration.Migration{
+		migrations[1],
+		{
+			Version: 2,
+			Name:    "broken",
+			Up:      migration.Exec(`ALTER TABLE a ADD COLUMN c INTEGER`, `THIS IS NOT SQL`),
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	applied, err := migrator.Up(ctx)
+	if err == nil {
+		t.Fatal("expecting an error, got nil")
+	}
+
+	if len(applied) != 1 || applied[0].Version != 1 {
+		t.Errorf("expecting only version 1 to be applied, got %v", applied)
+	}
+
+	// The failed migration is rolled back as a whole.
+	_, err = db.ExecContext(ctx, `SELECT c FROM a`)
+	if err == nil {
+		t.Error("expecting the column of the failed migration not to exist")
+	}
+
+	_, _, err = migrator.Down(ctx)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	irreversible, err := migration.New(db, migration.SQLite, []migration.Migration{
+		{Version: 1, Name: "create a", Up: migration.Exec(`CREATE TABLE a (id INTEGER PRIMARY KEY)`)},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	_, err = irreversible.Up(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	_, _, err = irreversible.Down(ctx)
+	if !errors.Is(err, migration.ErrIrreversible) {
+		t.Errorf("expecting ErrIrreversible, got %v", err)
+	}
+}
+
+func TestMigrator_Concurrent(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+	defer cancel()
+
+	path := filepath.Join(t.TempDir(), "migrations.db")
+
+	// Like two replicas starting together, each with a database of its own.
+	const processes = 4
+	var wg sync.WaitGroup
+	applied := make([][]migration.Migration, processes)
+	errs := make([]error, processes)
+	for i := 0; i < processes; i++ {
+		db, err := sql.Open("sqlite", path)
+		if err != nil {
+			t.Fatalf("opening sqlite: %s", err.Error())
+		}
+		db.SetMaxOpenConns(1)
+		t.Cleanup(func() {
+			_ = db.Close()
+		})
+
+		migrator, err := migration.New(db, migration.SQLite, migrations)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			applied[i], errs[i] = migrator.Up(ctx)
+		}(i)
+	}
+	wg.Wait()
+
+	count := 0
+	for i := 0; i < processes; i++ {
+		if errs[i] != nil {
+			t.Errorf("unexpected error on process %d: %v", i, errs[i])
+		}
+
+		count += len(applied[i])
+	}
+
+	if count != len(migrations) {
+		t.Errorf("expecting every migration to be applied once, got %d applied", count)
+	}
+}
+
+func TestExecIgnoring(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+	defer cancel()
+
+	db := open(t)
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	step := migration.ExecIgnoring(
+		"duplicate column name",
+		`CREATE TABLE a (id INTEGER PRIMARY KEY, b INTEGER)`,
+		`ALTER TABLE a ADD COLUMN b INTEGER`,
+	)
+
+	err = step(ctx, tx)
+	if err != nil {
+		t.Errorf("expecting the duplicate column to be ignored, got %v", err)
+	}
+
+	err = migration.ExecIgnoring("duplicate column name", `THIS IS NOT SQL`)(ctx, tx)
+	if err == nil {
+		t.Error("expecting other errors not to be ignored")
+	}
+}
@@ -8,17 +8,23 @@ import (
 	"testing"
 	"time"
 
+	"captcha-lite/leader"
 	"captcha-lite/logger/noop"
 	"captcha-lite/underattack"
 	"captcha-lite/underattack/datastore/mysql"
+	"captcha-lite/underattack/datastoretest"
 
 	_ "github.com/go-sql-driver/mysql"
 )
 
 var dependency underattack.Datastore
 
+// mysqlUrl is where the datastores of the tests connect to.
+var mysqlUrl string
+
 func TestMain(m *testing.M) {
-	mysqlUrl, ok := os.LookupEnv("MYSQL_URL")
+	var ok bool
+	mysqlUrl, ok = os.LookupEnv("MYSQL_URL")
 	if !ok {
 		mysqlUrl = "captcha:password@tcp(localhost:3306)/captcha?parseTime=true"
 	}
@@ -154,33 +160,53 @@ func TestGetUnderAttackEntry(t *testing.T) {
 		t.Errorf("expecting NotificationMessageID to be 1002, got: %v", entry.NotificationMessageID)
 	}
 }
+func TestDatastore(t *testing.T) {
+	datastoretest.Run(t, func(t *testing.T) underattack.Datastore {
+		db, err := sql.Open("mysql", mysqlUrl)
+		if err != nil {
+			t.Fatalf("opening mysql: %s", err.Error())
+		}
 
-func TestGetUnderAttackEntry_NotExists(t *testing.T) {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
-	defer cancel()
+		datastore, err := mysql.NewMySQLDatastore(db, noop.New())
+		if err != nil {
+			t.Fatalf("creating new mysql datastore: %s", err.Error())
+		}
 
-	_, err := dependency.GetUnderAttackEntry(ctx, 20)
-	if err != nil {
-		t.Errorf("unexpected error: %v", err)
-	}
-}
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+		defer cancel()
 
-func TestCreateNewEntry(t *testing.T) {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
-	defer cancel()
+		err = datastore.Migrate(ctx)
+		if err != nil {
+			t.Fatalf("migrating tables: %s", err.Error())
+		}
 
-	err := dependency.CreateNewEntry(ctx, 2)
-	if err != nil {
-		t.Errorf("unexpected error: %v", err)
-	}
+		return datastore
+	})
 }
 
-func TestSetUnderAttackStatus(t *testing.T) {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
-	defer cancel()
+func TestLease(t *testing.T) {
+	datastoretest.RunLease(t, func(t *testing.T) leader.Lease {
+		db, err := sql.Open("mysql", mysqlUrl)
+		if err != nil {
+			t.Fatalf("opening mysql: %s", err.Error())
+		}
 
-	err := dependency.SetUnderAttackStatus(ctx, 3, true, time.Now().Add(time.Minute*30), 1003)
-	if err != nil {
-		t.Errorf("unexpected error: %v", err)
-	}
+		datastore, err := mysql.NewMySQLDatastore(db, noop.New())
+		if err != nil {
+			t.Fatalf("creating new mysql datastore: %s", err.Error())
+		}
+		t.Cleanup(func() {
+			_ = datastore.Close()
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+		defer cancel()
+
+		err = datastore.Migrate(ctx)
+		if err != nil {
+			t.Fatalf("migrating tables: %s", err.Error())
+		}
+
+		return datastore
+	})
 }
@@ -0,0 +1,143 @@
+package postgres
+
+import "captcha-lite/underattack/datastore/migration"
+
+// migrations are the schema of the datastore, oldest first. The tables
+// used to be created without recording the migrations, so the ones that
+// existed back then must still check whether they have been created.
+var migrations = []migration.Migration{
+	{
+		Version: 1,
+		Name:    "create under_attack",
+		Up: migration.Exec(
+			`CREATE TABLE IF NOT EXISTS under_attack (
+				group_id BIGINT PRIMARY KEY,
+				is_under_attack BOOLEAN NOT NULL,
+				expires_at TIMESTAMP NOT NULL,
+				notification_message_id BIGINT NOT NULL,
+				updated_at TIMESTAMP NOT NULL
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_updated_at ON under_attack (updated_at)`,
+		),
+		Down: migration.Exec(`DROP TABLE IF EXISTS under_attack`),
+	},
+	{
+		Version: 2,
+		Name:    "index under_attack expiry",
+		Up:      migration.Exec(`CREATE INDEX IF NOT EXISTS idx_is_under_attack_expires_at ON under_attack (is_under_attack, expires_at)`),
+		Down:    migration.Exec(`DROP INDEX IF EXISTS idx_is_under_attack_expires_at`),
+	},
+	{
+		Version: 3,
+		Name:    "add under_attack.action",
+		Up:      migration.Exec(`ALTER TABLE under_attack ADD COLUMN IF NOT EXISTS action VARCHAR(20) NOT NULL DEFAULT ''`),
+		Down:    migration.Exec(`ALTER TABLE under_attack DROP COLUMN IF EXISTS action`),
+	},
+	{
+		Version: 4,
+		Name:    "create under_attack_bans",
+		Up: migration.Exec(
+			`CREATE TABLE IF NOT EXISTS under_attack_bans (
+				group_id BIGINT NOT NULL,
+				user_id BIGINT NOT NULL,
+				name VARCHAR(255) NOT NULL,
+				username VARCHAR(64) NOT NULL,
+				attack_id BIGINT NOT NULL,
+				banned_at TIMESTAMP NOT NULL,
+				PRIMARY KEY (group_id, user_id)
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_group_id_banned_at ON under_attack_bans (group_id, banned_at)`,
+		),
+		Down: migration.Exec(`DROP TABLE IF EXISTS under_attack_bans`),
+	},
+	{
+		Version: 5,
+		Name:    "add under_attack.enabled_by",
+		Up:      migration.Exec(`ALTER TABLE under_attack ADD COLUMN IF NOT EXISTS enabled_by BIGINT NOT NULL DEFAULT 0`),
+		Down:    migration.Exec(`ALTER TABLE under_attack DROP COLUMN IF EXISTS enabled_by`),
+	},
+	{
+		Version: 6,
+		Name:    "create under_attack_subscribers",
+		Up: migration.Exec(
+			`CREATE TABLE IF NOT EXISTS under_attack_subscribers (
+				group_id BIGINT NOT NULL,
+				user_id BIGINT NOT NULL,
+				created_at TIMESTAMP NOT NULL,
+				PRIMARY KEY (group_id, user_id)
+			)`,
+		),
+		Down: migration.Exec(`DROP TABLE IF EXISTS under_attack_subscribers`),
+	},
+	{
+		Version: 7,
+		Name:    "add under_attack.lockdown_permissions",
+		Up:      migration.Exec(`ALTER TABLE under_attack ADD COLUMN IF NOT EXISTS lockdown_permissions VARCHAR(1024) NOT NULL DEFAULT ''`),
+		Down:    migration.Exec(`ALTER TABLE under_attack DROP COLUMN IF EXISTS lockdown_permissions`),
+	},
+	{
+		Version: 8,
+		Name:    "add under_attack.invite_link",
+		Up:      migration.Exec(`ALTER TABLE under_attack ADD COLUMN IF NOT EXISTS invite_link VARCHAR(255) NOT NULL DEFAULT ''`),
+		Down:    migration.Exec(`ALTER TABLE under_attack DROP COLUMN IF EXISTS invite_link`),
+	},
+	{
+		Version: 9,
+		Name:    "add under_attack.timezone",
+		Up:      migration.Exec(`ALTER TABLE under_attack ADD COLUMN IF NOT EXISTS timezone VARCHAR(64) NOT NULL DEFAULT ''`),
+		Down:    migration.Exec(`ALTER TABLE under_attack DROP COLUMN IF EXISTS timezone`),
+	},
+	{
+		Version: 10,
+		Name:    "create under_attack_schedules",
+		Up: migration.Exec(
+			`CREATE TABLE IF NOT EXISTS under_attack_schedules (
+				id BIGSERIAL PRIMARY KEY,
+				group_id BIGINT NOT NULL,
+				start_minute INT NOT NULL,
+				end_minute INT NOT NULL,
+				timezone VARCHAR(64) NOT NULL,
+				created_by BIGINT NOT NULL,
+				last_run_at TIMESTAMP NOT NULL,
+				created_at TIMESTAMP NOT NULL
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_schedules_group_id ON under_attack_schedules (group_id)`,
+		),
+		Down: migration.Exec(`DROP TABLE IF EXISTS under_attack_schedules`),
+	},
+	{
+		Version: 11,
+		Name:    "create leader_leases",
+		Up: migration.Exec(
+			`CREATE TABLE IF NOT EXISTS leader_leases (
+				name VARCHAR(64) PRIMARY KEY,
+				holder VARCHAR(255) NOT NULL,
+				expires_at TIMESTAMP NOT NULL
+			)`,
+		),
+		Down: migration.Exec(`DROP TABLE IF EXISTS leader_leases`),
+	},
+	{
+		Version: 12,
+		Name:    "create under_attack_invalidations",
+		Up: migration.Exec(
+			`CREATE TABLE IF NOT EXISTS under_attack_invalidations (
+				group_id BIGINT PRIMARY KEY,
+				invalidated_at TIMESTAMP NOT NULL
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_invalidations_invalidated_at ON under_attack_invalidations (invalidated_at)`,
+		),
+		Down: migration.Exec(`DROP TABLE IF EXISTS under_attack_invalidations`),
+	},
+	{
+		Version: 13,
+		Name:    "add under_attack.active_action",
+		Up: migration.Exec(
+			`ALTER TABLE under_attack ADD COLUMN IF NOT EXISTS active_action VARCHAR(20) NOT NULL DEFAULT ''`,
+			// The action used to be recorded on the action column when
+			// the mode started, so it's the one in force for now.
+			`UPDATE under_attack SET active_action = action WHERE is_under_attack = TRUE`,
+		),
+		Down: migration.Exec(`ALTER TABLE under_attack DROP COLUMN IF EXISTS active_action`),
+	},
+}
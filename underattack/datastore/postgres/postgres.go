@@ -9,11 +9,13 @@ import (
 
 	"captcha-lite/logger"
 	"captcha-lite/underattack"
+	"captcha-lite/underattack/datastore/migration"
 )
 
 type postgresDatastore struct {
-	db     *sql.DB
-	logger logger.Logger
+	db       *sql.DB
+	logger   logger.Logger
+	migrator *migration.Migrator
 }
 
 func NewPostgresDatastore(db *sql.DB, logger logger.Logger) (*postgresDatastore, error) {
@@ -25,66 +27,24 @@ func NewPostgresDatastore(db *sql.DB, logger logger.Logger) (*postgresDatastore,
 		return nil, fmt.Errorf("nil logger")
 	}
 
-	return &postgresDatastore{db: db, logger: logger}, nil
-}
-
-// Migrate will migrates database tables for under attack domain.
-func (p *postgresDatastore) Migrate(ctx context.Context) error {
-	c, err := p.db.Conn(ctx)
-	if err != nil {
-		return err
-	}
-	defer func() {
-		err := c.Close()
-		if err != nil && !errors.Is(err, sql.ErrConnDone) {
-			p.logger.HandleError(err)
-		}
-	}()
-
-	tx, err := c.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable})
-	if err != nil {
-		return err
-	}
-
-	_, err = tx.ExecContext(
-		ctx,
-		`CREATE TABLE IF NOT EXISTS under_attack (
-			group_id BIGINT PRIMARY KEY,
-			is_under_attack BOOLEAN NOT NULL,
-			expires_at TIMESTAMP NOT NULL,
-			notification_message_id BIGINT NOT NULL,
-			updated_at TIMESTAMP NOT NULL
-		)`,
-	)
-	if err != nil {
-		if e := tx.Rollback(); e != nil {
-			return err
-		}
-
-		return err
-	}
-	_, err = tx.ExecContext(
-		ctx,
-		`CREATE INDEX IF NOT EXISTS idx_updated_at ON under_attack (updated_at)`,
-	)
+	migrator, err := migration.New(db, migration.Postgres, migrations)
 	if err != nil {
-		if e := tx.Rollback(); e != nil {
-			return err
-		}
-
-		return err
+		return nil, err
 	}
 
-	err = tx.Commit()
-	if err != nil {
-		if e := tx.Rollback(); e != nil {
-			return err
-		}
+	return &postgresDatastore{db: db, logger: logger, migrator: migrator}, nil
+}
 
-		return err
-	}
+// Migrate will apply the schema migrations that have not been applied.
+func (p *postgresDatastore) Migrate(ctx context.Context) error {
+	_, err := p.migrator.Up(ctx)
+	return err
+}
 
-	return nil
+// Migrator returns the schema migrations of the datastore, to be
+// applied, rolled back or inspected one at a time.
+func (p *postgresDatastore) Migrator() *migration.Migrator {
+	return p.migrator
 }
 
 // GetUnderAttackEntry will acquire under attack entry for specified groupID.
@@ -114,7 +74,13 @@ func (p *postgresDatastore) GetUnderAttackEntry(ctx context.Context, groupID int
     	is_under_attack,
     	expires_at,
     	notification_message_id,
-    	updated_at
+    	updated_at,
+    	action,
+    	active_action,
+    	enabled_by,
+    	lockdown_permissions,
+    	invite_link,
+    	timezone
     FROM
         under_attack
     WHERE
@@ -128,6 +94,12 @@ func (p *postgresDatastore) GetUnderAttackEntry(ctx context.Context, groupID int
 		&entry.ExpiresAt,
 		&entry.NotificationMessageID,
 		&entry.UpdatedAt,
+		&entry.Action,
+		&entry.ActiveAction,
+		&entry.EnabledBy,
+		&entry.LockdownPermissions,
+		&entry.InviteLink,
+		&entry.Timezone,
 	)
 	if err != nil {
 		if e := tx.Rollback(); e != nil {
@@ -221,7 +193,7 @@ func (p *postgresDatastore) CreateNewEntry(ctx context.Context, groupID int64) e
 
 // SetUnderAttackStatus will update the given groupID entry to the given parameters.
 // If the groupID entry does not exists, it will create a new one.
-func (p *postgresDatastore) SetUnderAttackStatus(ctx context.Context, groupID int64, underAttack bool, expiresAt time.Time, notificationMessageID int64) error {
+func (p *postgresDatastore) SetUnderAttackStatus(ctx context.Context, groupID int64, underAttack bool, expiresAt time.Time, notificationMessageID int64, activeAction underattack.Action) error {
 	c, err := p.db.Conn(ctx)
 	if err != nil {
 		return err
@@ -242,21 +214,141 @@ func (p *postgresDatastore) SetUnderAttackStatus(ctx context.Context, groupID in
 		ctx,
 		`INSERT INTO
 			under_attack
-			(group_id, is_under_attack, expires_at, notification_message_id, updated_at)
+			(group_id, is_under_attack, expires_at, notification_message_id, updated_at, active_action)
 		VALUES
-			($1, $2, $3, $4, $5)
+			($1, $2, $3, $4, $5, $6)
 		ON CONFLICT (group_id)
 		DO UPDATE
 		SET
 			is_under_attack = $2,
 			expires_at = $3,
 			notification_message_id = $4,
-			updated_at = $5`,
+			updated_at = $5,
+			active_action = $6`,
 		groupID,
 		underAttack,
 		expiresAt,
 		notificationMessageID,
 		time.Now(),
+		string(activeAction),
+	)
+	if err != nil {
+		if e := tx.Rollback(); e != nil {
+			return e
+		}
+
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		if e := tx.Rollback(); e != nil {
+			return e
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// SetUnderAttackAction will set the action of the given groupID entry.
+// If the groupID entry does not exists, it will create a new one.
+func (p *postgresDatastore) SetUnderAttackAction(ctx context.Context, groupID int64, action underattack.Action) error {
+	c, err := p.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err := c.Close()
+		if err != nil && !errors.Is(err, sql.ErrConnDone) {
+			p.logger.HandleError(err)
+		}
+	}()
+
+	tx, err := c.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelReadCommitted})
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`INSERT INTO
+			under_attack
+			(group_id, is_under_attack, expires_at, notification_message_id, updated_at, action)
+		VALUES
+			($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (group_id)
+		DO UPDATE
+		SET
+			action = $6,
+			updated_at = $5`,
+		groupID,
+		false,
+		time.Time{},
+		0,
+		time.Now(),
+		string(action),
+	)
+	if err != nil {
+		if e := tx.Rollback(); e != nil {
+			return e
+		}
+
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		if e := tx.Rollback(); e != nil {
+			return e
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// CreateBan will record a user that was banned during the under attack mode.
+// If the user was already recorded on the group, it will be replaced.
+func (p *postgresDatastore) CreateBan(ctx context.Context, ban underattack.Ban) error {
+	c, err := p.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err := c.Close()
+		if err != nil && !errors.Is(err, sql.ErrConnDone) {
+			p.logger.HandleError(err)
+		}
+	}()
+
+	tx, err := c.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelReadCommitted})
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`INSERT INTO
+			under_attack_bans
+			(group_id, user_id, name, username, attack_id, banned_at)
+		VALUES
+			($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (group_id, user_id)
+		DO UPDATE
+		SET
+			name = $3,
+			username = $4,
+			attack_id = $5,
+			banned_at = $6`,
+		ban.GroupID,
+		ban.UserID,
+		ban.Name,
+		ban.Username,
+		ban.AttackID,
+		ban.BannedAt,
 	)
 	if err != nil {
 		if e := tx.Rollback(); e != nil {
@@ -278,6 +370,874 @@ func (p *postgresDatastore) SetUnderAttackStatus(ctx context.Context, groupID in
 	return nil
 }
 
+// GetBans will acquire the users that were banned during the under attack
+// mode on the given groupID, latest first.
+func (p *postgresDatastore) GetBans(ctx context.Context, groupID int64, limit int, offset int) ([]underattack.Ban, error) {
+	c, err := p.db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		err := c.Close()
+		if err != nil && !errors.Is(err, sql.ErrConnDone) {
+			p.logger.HandleError(err)
+		}
+	}()
+
+	rows, err := c.QueryContext(
+		ctx,
+		`SELECT
+			group_id,
+			user_id,
+			name,
+			username,
+			attack_id,
+			banned_at
+		FROM
+			under_attack_bans
+		WHERE
+			group_id = $1
+		ORDER BY
+			banned_at DESC,
+			user_id ASC
+		LIMIT $2
+		OFFSET $3`,
+		groupID,
+		limit,
+		offset,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		err := rows.Close()
+		if err != nil {
+			p.logger.HandleError(err)
+		}
+	}()
+
+	var bans []underattack.Ban
+	for rows.Next() {
+		var ban underattack.Ban
+		err := rows.Scan(
+			&ban.GroupID,
+			&ban.UserID,
+			&ban.Name,
+			&ban.Username,
+			&ban.AttackID,
+			&ban.BannedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		bans = append(bans, ban)
+	}
+
+	return bans, rows.Err()
+}
+
+// GetBan will acquire the record of the given userID on the given groupID.
+// The bool is false if the user was not banned during the under attack mode.
+func (p *postgresDatastore) GetBan(ctx context.Context, groupID int64, userID int64) (underattack.Ban, bool, error) {
+	c, err := p.db.Conn(ctx)
+	if err != nil {
+		return underattack.Ban{}, false, err
+	}
+	defer func() {
+		err := c.Close()
+		if err != nil && !errors.Is(err, sql.ErrConnDone) {
+			p.logger.HandleError(err)
+		}
+	}()
+
+	var ban underattack.Ban
+	err = c.QueryRowContext(
+		ctx,
+		`SELECT
+			group_id,
+			user_id,
+			name,
+			username,
+			attack_id,
+			banned_at
+		FROM
+			under_attack_bans
+		WHERE
+			group_id = $1
+			AND user_id = $2`,
+		groupID,
+		userID,
+	).Scan(
+		&ban.GroupID,
+		&ban.UserID,
+		&ban.Name,
+		&ban.Username,
+		&ban.AttackID,
+		&ban.BannedAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return underattack.Ban{}, false, nil
+		}
+
+		return underattack.Ban{}, false, err
+	}
+
+	return ban, true, nil
+}
+
+// CountBans will count the users that were banned during the under attack
+// mode on the given groupID.
+func (p *postgresDatastore) CountBans(ctx context.Context, groupID int64) (int, error) {
+	c, err := p.db.Conn(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		err := c.Close()
+		if err != nil && !errors.Is(err, sql.ErrConnDone) {
+			p.logger.HandleError(err)
+		}
+	}()
+
+	var count int
+	err = c.QueryRowContext(
+		ctx,
+		`SELECT COUNT(*) FROM under_attack_bans WHERE group_id = $1`,
+		groupID,
+	).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// DeleteBan will remove the record of the given userID on the given groupID.
+// It does nothing if the record does not exists.
+func (p *postgresDatastore) DeleteBan(ctx context.Context, groupID int64, userID int64) error {
+	c, err := p.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err := c.Close()
+		if err != nil && !errors.Is(err, sql.ErrConnDone) {
+			p.logger.HandleError(err)
+		}
+	}()
+
+	_, err = c.ExecContext(
+		ctx,
+		`DELETE FROM under_attack_bans WHERE group_id = $1 AND user_id = $2`,
+		groupID,
+		userID,
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// CountBansByAttack will count the users that were banned by the given
+// attackID on the given groupID.
+func (p *postgresDatastore) CountBansByAttack(ctx context.Context, groupID int64, attackID int64) (int, error) {
+	c, err := p.db.Conn(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		err := c.Close()
+		if err != nil && !errors.Is(err, sql.ErrConnDone) {
+			p.logger.HandleError(err)
+		}
+	}()
+
+	var count int
+	err = c.QueryRowContext(
+		ctx,
+		`SELECT COUNT(*) FROM under_attack_bans WHERE group_id = $1 AND attack_id = $2`,
+		groupID,
+		attackID,
+	).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// SetUnderAttackEnabledBy will set who turned on the under attack mode of the given groupID.
+// If the groupID entry does not exists, it will create a new one.
+func (p *postgresDatastore) SetUnderAttackEnabledBy(ctx context.Context, groupID int64, userID int64) error {
+	c, err := p.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err := c.Close()
+		if err != nil && !errors.Is(err, sql.ErrConnDone) {
+			p.logger.HandleError(err)
+		}
+	}()
+
+	_, err = c.ExecContext(
+		ctx,
+		`INSERT INTO
+			under_attack
+			(group_id, is_under_attack, expires_at, notification_message_id, updated_at, enabled_by)
+		VALUES
+			($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (group_id)
+		DO UPDATE
+		SET
+			enabled_by = $6,
+			updated_at = $5`,
+		groupID,
+		false,
+		time.Time{},
+		0,
+		time.Now(),
+		userID,
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// SetNotificationSubscription will subscribe or unsubscribe the given userID
+// to the private notifications of the under attack mode of the given groupID.
+func (p *postgresDatastore) SetNotificationSubscription(ctx context.Context, groupID int64, userID int64, subscribed bool) error {
+	c, err := p.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err := c.Close()
+		if err != nil && !errors.Is(err, sql.ErrConnDone) {
+			p.logger.HandleError(err)
+		}
+	}()
+
+	if !subscribed {
+		_, err = c.ExecContext(
+			ctx,
+			`DELETE FROM under_attack_subscribers WHERE group_id = $1 AND user_id = $2`,
+			groupID,
+			userID,
+		)
+		return err
+	}
+
+	_, err = c.ExecContext(
+		ctx,
+		`INSERT INTO
+			under_attack_subscribers
+			(group_id, user_id, created_at)
+		VALUES
+			($1, $2, $3)
+		ON CONFLICT (group_id, user_id)
+		DO NOTHING`,
+		groupID,
+		userID,
+		time.Now(),
+	)
+	return err
+}
+
+// GetNotificationSubscribers will acquire the ID of the users that are
+// subscribed to the private notifications of the given groupID.
+func (p *postgresDatastore) GetNotificationSubscribers(ctx context.Context, groupID int64) ([]int64, error) {
+	c, err := p.db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		err := c.Close()
+		if err != nil && !errors.Is(err, sql.ErrConnDone) {
+			p.logger.HandleError(err)
+		}
+	}()
+
+	rows, err := c.QueryContext(
+		ctx,
+		`SELECT user_id FROM under_attack_subscribers WHERE group_id = $1 ORDER BY created_at ASC`,
+		groupID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		err := rows.Close()
+		if err != nil {
+			p.logger.HandleError(err)
+		}
+	}()
+
+	var userIDs []int64
+	for rows.Next() {
+		var userID int64
+		err := rows.Scan(&userID)
+		if err != nil {
+			return nil, err
+		}
+
+		userIDs = append(userIDs, userID)
+	}
+
+	return userIDs, rows.Err()
+}
+
+// SetLockdownPermissions will set the snapshot of the group permissions before the lockdown.
+// If the groupID entry does not exists, it will create a new one.
+func (p *postgresDatastore) SetLockdownPermissions(ctx context.Context, groupID int64, permissions string) error {
+	c, err := p.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err := c.Close()
+		if err != nil && !errors.Is(err, sql.ErrConnDone) {
+			p.logger.HandleError(err)
+		}
+	}()
+
+	_, err = c.ExecContext(
+		ctx,
+		`INSERT INTO
+			under_attack
+			(group_id, is_under_attack, expires_at, notification_message_id, updated_at, lockdown_permissions)
+		VALUES
+			($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (group_id)
+		DO UPDATE
+		SET
+			lockdown_permissions = $6,
+			updated_at = $5`,
+		groupID,
+		false,
+		time.Time{},
+		0,
+		time.Now(),
+		permissions,
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// SetInviteLink will set the invite link that was created for the attack.
+// If the groupID entry does not exists, it will create a new one.
+func (p *postgresDatastore) SetInviteLink(ctx context.Context, groupID int64, inviteLink string) error {
+	c, err := p.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err := c.Close()
+		if err != nil && !errors.Is(err, sql.ErrConnDone) {
+			p.logger.HandleError(err)
+		}
+	}()
+
+	_, err = c.ExecContext(
+		ctx,
+		`INSERT INTO
+			under_attack
+			(group_id, is_under_attack, expires_at, notification_message_id, updated_at, invite_link)
+		VALUES
+			($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (group_id)
+		DO UPDATE
+		SET
+			invite_link = $6,
+			updated_at = $5`,
+		groupID,
+		false,
+		time.Time{},
+		0,
+		time.Now(),
+		inviteLink,
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// SetTimezone will set the timezone that the times are shown in for the group.
+// If the groupID entry does not exists, it will create a new one.
+func (p *postgresDatastore) SetTimezone(ctx context.Context, groupID int64, timezone string) error {
+	c, err := p.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err := c.Close()
+		if err != nil && !errors.Is(err, sql.ErrConnDone) {
+			p.logger.HandleError(err)
+		}
+	}()
+
+	_, err = c.ExecContext(
+		ctx,
+		`INSERT INTO
+			under_attack
+			(group_id, is_under_attack, expires_at, notification_message_id, updated_at, timezone)
+		VALUES
+			($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (group_id)
+		DO UPDATE
+		SET
+			timezone = $6,
+			updated_at = $5`,
+		groupID,
+		false,
+		time.Time{},
+		0,
+		time.Now(),
+		timezone,
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// CreateSchedule will create a new under attack schedule, and returns its ID.
+func (p *postgresDatastore) CreateSchedule(ctx context.Context, schedule underattack.Schedule) (int64, error) {
+	c, err := p.db.Conn(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		err := c.Close()
+		if err != nil && !errors.Is(err, sql.ErrConnDone) {
+			p.logger.HandleError(err)
+		}
+	}()
+
+	var id int64
+	err = c.QueryRowContext(
+		ctx,
+		`INSERT INTO
+			under_attack_schedules
+			(group_id, start_minute, end_minute, timezone, created_by, last_run_at, created_at)
+		VALUES
+			($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id`,
+		schedule.GroupID,
+		schedule.StartMinute,
+		schedule.EndMinute,
+		schedule.Timezone,
+		schedule.CreatedBy,
+		schedule.LastRunAt,
+		schedule.CreatedAt,
+	).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+// GetSchedules will acquire the under attack schedules of the given groupID.
+func (p *postgresDatastore) GetSchedules(ctx context.Context, groupID int64) ([]underattack.Schedule, error) {
+	c, err := p.db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		err := c.Close()
+		if err != nil && !errors.Is(err, sql.ErrConnDone) {
+			p.logger.HandleError(err)
+		}
+	}()
+
+	rows, err := c.QueryContext(
+		ctx,
+		`SELECT
+			id,
+			group_id,
+			start_minute,
+			end_minute,
+			timezone,
+			created_by,
+			last_run_at,
+			created_at
+		FROM
+			under_attack_schedules
+		WHERE
+			group_id = $1
+		ORDER BY
+			id ASC`,
+		groupID,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return p.scanSchedules(rows)
+}
+
+// GetAllSchedules will acquire the under attack schedules of every group.
+func (p *postgresDatastore) GetAllSchedules(ctx context.Context) ([]underattack.Schedule, error) {
+	c, err := p.db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		err := c.Close()
+		if err != nil && !errors.Is(err, sql.ErrConnDone) {
+			p.logger.HandleError(err)
+		}
+	}()
+
+	rows, err := c.QueryContext(
+		ctx,
+		`SELECT
+			id,
+			group_id,
+			start_minute,
+			end_minute,
+			timezone,
+			created_by,
+			last_run_at,
+			created_at
+		FROM
+			under_attack_schedules
+		ORDER BY
+			id ASC`,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return p.scanSchedules(rows)
+}
+
+func (p *postgresDatastore) scanSchedules(rows *sql.Rows) ([]underattack.Schedule, error) {
+	defer func() {
+		err := rows.Close()
+		if err != nil {
+			p.logger.HandleError(err)
+		}
+	}()
+
+	var schedules []underattack.Schedule
+	for rows.Next() {
+		var schedule underattack.Schedule
+		err := rows.Scan(
+			&schedule.ID,
+			&schedule.GroupID,
+			&schedule.StartMinute,
+			&schedule.EndMinute,
+			&schedule.Timezone,
+			&schedule.CreatedBy,
+			&schedule.LastRunAt,
+			&schedule.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		schedules = append(schedules, schedule)
+	}
+
+	return schedules, rows.Err()
+}
+
+// DeleteSchedule will delete the under attack schedule of the given groupID.
+// It returns false if the schedule does not exists on the group.
+func (p *postgresDatastore) DeleteSchedule(ctx context.Context, groupID int64, scheduleID int64) (bool, error) {
+	c, err := p.db.Conn(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		err := c.Close()
+		if err != nil && !errors.Is(err, sql.ErrConnDone) {
+			p.logger.HandleError(err)
+		}
+	}()
+
+	result, err := c.ExecContext(
+		ctx,
+		`DELETE FROM under_attack_schedules WHERE group_id = $1 AND id = $2`,
+		groupID,
+		scheduleID,
+	)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return affected > 0, nil
+}
+
+// SetScheduleLastRunAt will set when the under attack schedule was last run.
+func (p *postgresDatastore) SetScheduleLastRunAt(ctx context.Context, scheduleID int64, lastRunAt time.Time) error {
+	c, err := p.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err := c.Close()
+		if err != nil && !errors.Is(err, sql.ErrConnDone) {
+			p.logger.HandleError(err)
+		}
+	}()
+
+	_, err = c.ExecContext(
+		ctx,
+		`UPDATE under_attack_schedules SET last_run_at = $1 WHERE id = $2`,
+		lastRunAt,
+		scheduleID,
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetExpiredUnderAttackEntries will acquire every entry that is still on under
+// attack mode, but already expired before the given time.
+func (p *postgresDatastore) GetExpiredUnderAttackEntries(ctx context.Context, before time.Time) ([]underattack.UnderAttack, error) {
+	c, err := p.db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		err := c.Close()
+		if err != nil && !errors.Is(err, sql.ErrConnDone) {
+			p.logger.HandleError(err)
+		}
+	}()
+
+	rows, err := c.QueryContext(
+		ctx,
+		`SELECT
+			group_id,
+			is_under_attack,
+			expires_at,
+			notification_message_id,
+			updated_at,
+			action,
+			active_action,
+			enabled_by,
+			lockdown_permissions,
+			invite_link,
+			timezone
+		FROM
+			under_attack
+		WHERE
+			is_under_attack = TRUE
+			AND expires_at <= $1
+		ORDER BY
+			expires_at ASC`,
+		before,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		err := rows.Close()
+		if err != nil {
+			p.logger.HandleError(err)
+		}
+	}()
+
+	var entries []underattack.UnderAttack
+	for rows.Next() {
+		var entry underattack.UnderAttack
+		err := rows.Scan(
+			&entry.GroupID,
+			&entry.IsUnderAttack,
+			&entry.ExpiresAt,
+			&entry.NotificationMessageID,
+			&entry.UpdatedAt,
+			&entry.Action,
+			&entry.ActiveAction,
+			&entry.EnabledBy,
+			&entry.LockdownPermissions,
+			&entry.InviteLink,
+			&entry.Timezone,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, rows.Err()
+}
+
+// InvalidateGroup records that the cached state of the group is stale,
+// for the other instances that share the database.
+func (p *postgresDatastore) InvalidateGroup(ctx context.Context, groupID int64) error {
+	c, err := p.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err := c.Close()
+		if err != nil && !errors.Is(err, sql.ErrConnDone) {
+			p.logger.HandleError(err)
+		}
+	}()
+
+	// The instances compare the times that were written by each other,
+	// so they must agree on the timezone.
+	invalidatedAt := time.Now().UTC()
+
+	_, err = c.ExecContext(
+		ctx,
+		`INSERT INTO
+			under_attack_invalidations
+			(group_id, invalidated_at)
+		VALUES
+			($1, $2)
+		ON CONFLICT (group_id)
+		DO UPDATE
+		SET
+			invalidated_at = $2`,
+		groupID,
+		invalidatedAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetInvalidatedGroups returns the groups that were invalidated since the given time.
+func (p *postgresDatastore) GetInvalidatedGroups(ctx context.Context, since time.Time) ([]int64, error) {
+	c, err := p.db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		err := c.Close()
+		if err != nil && !errors.Is(err, sql.ErrConnDone) {
+			p.logger.HandleError(err)
+		}
+	}()
+
+	rows, err := c.QueryContext(ctx, `SELECT group_id FROM under_attack_invalidations WHERE invalidated_at >= $1`, since.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		err := rows.Close()
+		if err != nil {
+			p.logger.HandleError(err)
+		}
+	}()
+
+	var groupIDs []int64
+	for rows.Next() {
+		var groupID int64
+		err := rows.Scan(&groupID)
+		if err != nil {
+			return nil, err
+		}
+
+		groupIDs = append(groupIDs, groupID)
+	}
+
+	return groupIDs, rows.Err()
+}
+
+// AcquireLease takes the lease with the given name for the holder, if it's
+// free or expired, or extends it if the holder already has it. It returns
+// true if the holder has the lease afterwards.
+func (p *postgresDatastore) AcquireLease(ctx context.Context, name string, holder string, duration time.Duration) (bool, error) {
+	c, err := p.db.Conn(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		err := c.Close()
+		if err != nil && !errors.Is(err, sql.ErrConnDone) {
+			p.logger.HandleError(err)
+		}
+	}()
+
+	// The instances compare the times that were written by each other,
+	// so they must agree on the timezone.
+	now := time.Now().UTC()
+	expiresAt := now.Add(duration)
+
+	_, err = c.ExecContext(
+		ctx,
+		`INSERT INTO leader_leases (name, holder, expires_at) VALUES ($1, $2, $3) ON CONFLICT (name) DO NOTHING`,
+		name,
+		holder,
+		expiresAt,
+	)
+	if err != nil {
+		return false, err
+	}
+
+	// A single statement, so only one of the instances
+	// can take over an expired lease.
+	_, err = c.ExecContext(
+		ctx,
+		`UPDATE leader_leases SET holder = $2, expires_at = $3 WHERE name = $1 AND (holder = $2 OR expires_at < $4)`,
+		name,
+		holder,
+		expiresAt,
+		now,
+	)
+	if err != nil {
+		return false, err
+	}
+
+	var current string
+	err = c.QueryRowContext(ctx, `SELECT holder FROM leader_leases WHERE name = $1`, name).Scan(&current)
+	if err != nil {
+		return false, err
+	}
+
+	return current == holder, nil
+}
+
+// ReleaseLease gives up the lease with the given name, if the holder has it.
+func (p *postgresDatastore) ReleaseLease(ctx context.Context, name string, holder string) error {
+	c, err := p.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err := c.Close()
+		if err != nil && !errors.Is(err, sql.ErrConnDone) {
+			p.logger.HandleError(err)
+		}
+	}()
+
+	_, err = c.ExecContext(ctx, `DELETE FROM leader_leases WHERE name = $1 AND holder = $2`, name, holder)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
 func (p *postgresDatastore) Close() error {
 	return p.db.Close()
 }
@@ -0,0 +1,658 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"captcha-lite/logger"
+	"captcha-lite/underattack"
+
+	goredis "github.com/redis/go-redis/v9"
+)
+
+// Every key is prefixed, so the server can be shared with the captcha store.
+const (
+	// entryKeyPrefix prefixes the hash of the under attack entry of a group.
+	entryKeyPrefix = "underattack:entry:"
+	// bansKeyPrefix prefixes the hash of the bans of a group, by user ID.
+	bansKeyPrefix = "underattack:bans:"
+	// subscribersKeyPrefix prefixes the set of the subscribers of a group.
+	subscribersKeyPrefix = "underattack:subscribers:"
+	// schedulesKeyPrefix prefixes the hash of the schedules of a group, by ID.
+	schedulesKeyPrefix = "underattack:schedules:"
+	// scheduleGroupsKey maps the ID of every schedule to its group.
+	scheduleGroupsKey = "underattack:schedule_groups"
+	// scheduleIDKey is the ID of the last created schedule.
+	scheduleIDKey = "underattack:schedule_id"
+	// expiringKey keeps the groups that are under attack,
+	// scored by when it expires.
+	expiringKey = "underattack:expiring"
+	// invalidationsKey keeps when each group was last invalidated.
+	invalidationsKey = "underattack:invalidations"
+	// leaseKeyPrefix prefixes the name of a leader lease.
+	leaseKeyPrefix = "leader:"
+)
+
+// The fields of the hash of an under attack entry.
+const (
+	fieldGroupID               = "group_id"
+	fieldIsUnderAttack         = "is_under_attack"
+	fieldNotificationMessageID = "notification_message_id"
+	fieldExpiresAt             = "expires_at"
+	fieldUpdatedAt             = "updated_at"
+	fieldAction                = "action"
+	fieldActiveAction          = "active_action"
+	fieldEnabledBy             = "enabled_by"
+	fieldLockdownPermissions   = "lockdown_permissions"
+	fieldInviteLink            = "invite_link"
+	fieldTimezone              = "timezone"
+)
+
+// maxUpdateAttempts is how many times a read-modify-write is retried when
+// the record is changed by someone else in the middle of it.
+const maxUpdateAttempts = 10
+
+// acquireLeaseScript takes the lease if it's free, or extends it if the
+// holder already has it. Expired leases are dropped by the server.
+var acquireLeaseScript = goredis.NewScript(`
+local current = redis.call("GET", KEYS[1])
+if current == false or current == ARGV[1] then
+	redis.call("SET", KEYS[1], ARGV[1], "PX", ARGV[2])
+	return 1
+end
+return 0
+`)
+
+// releaseLeaseScript drops the lease if the holder has it.
+var releaseLeaseScript = goredis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// redisDatastore keeps the under attack entries on a Redis compatible
+// server. Every field of an entry is its own hash field, so the writers
+// of different fields never overwrite each other.
+type redisDatastore struct {
+	client goredis.UniversalClient
+	logger logger.Logger
+}
+
+func NewRedisDatastore(client goredis.UniversalClient, logger logger.Logger) (*redisDatastore, error) {
+	if client == nil {
+		return nil, fmt.Errorf("nil client")
+	}
+
+	if logger == nil {
+		return nil, fmt.Errorf("nil logger")
+	}
+
+	return &redisDatastore{client: client, logger: logger}, nil
+}
+
+// Migrate does nothing, as there is no schema.
+func (r *redisDatastore) Migrate(ctx context.Context) error {
+	return nil
+}
+
+// GetUnderAttackEntry will acquire under attack entry for specified groupID.
+// A group without one gets an empty entry.
+func (r *redisDatastore) GetUnderAttackEntry(ctx context.Context, groupID int64) (underattack.UnderAttack, error) {
+	fields, err := r.client.HGetAll(ctx, entryKey(groupID)).Result()
+	if err != nil {
+		return underattack.UnderAttack{}, err
+	}
+
+	return parseEntry(fields)
+}
+
+// CreateNewEntry will create a new entry for the given groupID, if it does not exist.
+func (r *redisDatastore) CreateNewEntry(ctx context.Context, groupID int64) error {
+	created, err := r.client.HSetNX(ctx, entryKey(groupID), fieldGroupID, groupID).Result()
+	if err != nil {
+		return err
+	}
+
+	if !created {
+		return nil
+	}
+
+	return r.client.HSet(ctx, entryKey(groupID), fieldUpdatedAt, formatTime(time.Now())).Err()
+}
+
+// SetUnderAttackStatus will update the given groupID with the under attack status.
+func (r *redisDatastore) SetUnderAttackStatus(ctx context.Context, groupID int64, underAttack bool, expiresAt time.Time, notificationMessageID int64, activeAction underattack.Action) error {
+	_, err := r.client.TxPipelined(ctx, func(pipe goredis.Pipeliner) error {
+		pipe.HSet(
+			ctx,
+			entryKey(groupID),
+			fieldGroupID, groupID,
+			fieldIsUnderAttack, underAttack,
+			fieldExpiresAt, formatTime(expiresAt),
+			fieldNotificationMessageID, notificationMessageID,
+			fieldUpdatedAt, formatTime(time.Now()),
+			fieldActiveAction, string(activeAction),
+		)
+
+		if underAttack {
+			pipe.ZAdd(ctx, expiringKey, goredis.Z{Score: float64(expiresAt.UnixMicro()), Member: groupID})
+		} else {
+			pipe.ZRem(ctx, expiringKey, groupID)
+		}
+
+		return nil
+	})
+
+	return err
+}
+
+// GetExpiredUnderAttackEntries returns the entries that are under attack,
+// and expire at or before the given time, the earliest first.
+func (r *redisDatastore) GetExpiredUnderAttackEntries(ctx context.Context, before time.Time) ([]underattack.UnderAttack, error) {
+	members, err := r.client.ZRangeByScore(ctx, expiringKey, &goredis.ZRangeBy{
+		Min: "-inf",
+		Max: strconv.FormatInt(before.UnixMicro(), 10),
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []underattack.UnderAttack
+	for _, member := range members {
+		groupID, err := strconv.ParseInt(member, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+
+		entry, err := r.GetUnderAttackEntry(ctx, groupID)
+		if err != nil {
+			return nil, err
+		}
+
+		// The scores are rounded to the microsecond.
+		if entry.IsUnderAttack && !entry.ExpiresAt.After(before) {
+			entries = append(entries, entry)
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].ExpiresAt.Before(entries[j].ExpiresAt)
+	})
+
+	return entries, nil
+}
+
+// SetUnderAttackAction will set the action for the users that join
+// the given groupID during the under attack mode.
+func (r *redisDatastore) SetUnderAttackAction(ctx context.Context, groupID int64, action underattack.Action) error {
+	return r.setField(ctx, groupID, fieldAction, string(action))
+}
+
+// SetUnderAttackEnabledBy will record who turned on the under attack mode.
+func (r *redisDatastore) SetUnderAttackEnabledBy(ctx context.Context, groupID int64, userID int64) error {
+	return r.setField(ctx, groupID, fieldEnabledBy, userID)
+}
+
+// SetLockdownPermissions will keep the permissions of the group from before it was locked down.
+func (r *redisDatastore) SetLockdownPermissions(ctx context.Context, groupID int64, permissions string) error {
+	return r.setField(ctx, groupID, fieldLockdownPermissions, permissions)
+}
+
+// SetInviteLink will keep the invite link that the bot created for the group.
+func (r *redisDatastore) SetInviteLink(ctx context.Context, groupID int64, inviteLink string) error {
+	return r.setField(ctx, groupID, fieldInviteLink, inviteLink)
+}
+
+// SetTimezone will set the timezone that the times are shown in for the group.
+func (r *redisDatastore) SetTimezone(ctx context.Context, groupID int64, timezone string) error {
+	return r.setField(ctx, groupID, fieldTimezone, timezone)
+}
+
+// setField sets a single field of the entry, and creates the entry if it does not exist.
+func (r *redisDatastore) setField(ctx context.Context, groupID int64, field string, value interface{}) error {
+	return r.client.HSet(
+		ctx,
+		entryKey(groupID),
+		fieldGroupID, groupID,
+		field, value,
+		fieldUpdatedAt, formatTime(time.Now()),
+	).Err()
+}
+
+// CreateBan records a user that was banned during the under attack mode.
+// Banning the same user again replaces the previous ban.
+func (r *redisDatastore) CreateBan(ctx context.Context, ban underattack.Ban) error {
+	value, err := json.Marshal(ban)
+	if err != nil {
+		return err
+	}
+
+	return r.client.HSet(ctx, bansKey(ban.GroupID), strconv.FormatInt(ban.UserID, 10), value).Err()
+}
+
+// GetBans returns the bans of the group, the latest first.
+func (r *redisDatastore) GetBans(ctx context.Context, groupID int64, limit int, offset int) ([]underattack.Ban, error) {
+	bans, err := r.getBans(ctx, groupID)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(bans, func(i, j int) bool {
+		if bans[i].BannedAt.Equal(bans[j].BannedAt) {
+			return bans[i].UserID < bans[j].UserID
+		}
+
+		return bans[i].BannedAt.After(bans[j].BannedAt)
+	})
+
+	if offset >= len(bans) {
+		return nil, nil
+	}
+
+	bans = bans[offset:]
+	if limit < len(bans) {
+		bans = bans[:limit]
+	}
+
+	return bans, nil
+}
+
+// GetBan returns the ban of the user on the group. The bool is false if
+// the user was not banned during the under attack mode.
+func (r *redisDatastore) GetBan(ctx context.Context, groupID int64, userID int64) (underattack.Ban, bool, error) {
+	value, err := r.client.HGet(ctx, bansKey(groupID), strconv.FormatInt(userID, 10)).Bytes()
+	if err != nil {
+		if errors.Is(err, goredis.Nil) {
+			return underattack.Ban{}, false, nil
+		}
+
+		return underattack.Ban{}, false, err
+	}
+
+	var ban underattack.Ban
+	err = json.Unmarshal(value, &ban)
+	if err != nil {
+		return underattack.Ban{}, false, err
+	}
+
+	return ban, true, nil
+}
+
+// CountBans returns the number of bans of the group.
+func (r *redisDatastore) CountBans(ctx context.Context, groupID int64) (int, error) {
+	count, err := r.client.HLen(ctx, bansKey(groupID)).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(count), nil
+}
+
+// DeleteBan removes the ban of the user from the group.
+func (r *redisDatastore) DeleteBan(ctx context.Context, groupID int64, userID int64) error {
+	return r.client.HDel(ctx, bansKey(groupID), strconv.FormatInt(userID, 10)).Err()
+}
+
+// CountBansByAttack returns the number of bans of the group during the given attack.
+func (r *redisDatastore) CountBansByAttack(ctx context.Context, groupID int64, attackID int64) (int, error) {
+	bans, err := r.getBans(ctx, groupID)
+	if err != nil {
+		return 0, err
+	}
+
+	var count int
+	for _, ban := range bans {
+		if ban.AttackID == attackID {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+func (r *redisDatastore) getBans(ctx context.Context, groupID int64) ([]underattack.Ban, error) {
+	values, err := r.client.HVals(ctx, bansKey(groupID)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	bans := make([]underattack.Ban, 0, len(values))
+	for _, value := range values {
+		var ban underattack.Ban
+		err := json.Unmarshal([]byte(value), &ban)
+		if err != nil {
+			return nil, err
+		}
+
+		bans = append(bans, ban)
+	}
+
+	return bans, nil
+}
+
+// SetNotificationSubscription will subscribe or unsubscribe the given userID
+// to the private notifications of the under attack mode of the given groupID.
+func (r *redisDatastore) SetNotificationSubscription(ctx context.Context, groupID int64, userID int64, subscribed bool) error {
+	if subscribed {
+		return r.client.SAdd(ctx, subscribersKey(groupID), userID).Err()
+	}
+
+	return r.client.SRem(ctx, subscribersKey(groupID), userID).Err()
+}
+
+// GetNotificationSubscribers returns the users that are subscribed to the
+// private notifications of the under attack mode of the given groupID.
+func (r *redisDatastore) GetNotificationSubscribers(ctx context.Context, groupID int64) ([]int64, error) {
+	members, err := r.client.SMembers(ctx, subscribersKey(groupID)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	subscribers := make([]int64, 0, len(members))
+	for _, member := range members {
+		userID, err := strconv.ParseInt(member, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+
+		subscribers = append(subscribers, userID)
+	}
+
+	sort.Slice(subscribers, func(i, j int) bool {
+		return subscribers[i] < subscribers[j]
+	})
+
+	return subscribers, nil
+}
+
+// CreateSchedule stores a new schedule and returns its ID.
+func (r *redisDatastore) CreateSchedule(ctx context.Context, schedule underattack.Schedule) (int64, error) {
+	id, err := r.client.Incr(ctx, scheduleIDKey).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	schedule.ID = id
+
+	value, err := json.Marshal(schedule)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = r.client.TxPipelined(ctx, func(pipe goredis.Pipeliner) error {
+		pipe.HSet(ctx, schedulesKey(schedule.GroupID), strconv.FormatInt(id, 10), value)
+		pipe.HSet(ctx, scheduleGroupsKey, strconv.FormatInt(id, 10), schedule.GroupID)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+// GetSchedules returns the schedules of the group, in the order they were created.
+func (r *redisDatastore) GetSchedules(ctx context.Context, groupID int64) ([]underattack.Schedule, error) {
+	values, err := r.client.HVals(ctx, schedulesKey(groupID)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	schedules := make([]underattack.Schedule, 0, len(values))
+	for _, value := range values {
+		var schedule underattack.Schedule
+		err := json.Unmarshal([]byte(value), &schedule)
+		if err != nil {
+			return nil, err
+		}
+
+		schedules = append(schedules, schedule)
+	}
+
+	sort.Slice(schedules, func(i, j int) bool {
+		return schedules[i].ID < schedules[j].ID
+	})
+
+	return schedules, nil
+}
+
+// GetAllSchedules returns the schedules of every group, in the order they were created.
+func (r *redisDatastore) GetAllSchedules(ctx context.Context) ([]underattack.Schedule, error) {
+	groups, err := r.client.HVals(ctx, scheduleGroupsKey).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	var schedules []underattack.Schedule
+	for _, group := range groups {
+		if seen[group] {
+			continue
+		}
+		seen[group] = true
+
+		groupID, err := strconv.ParseInt(group, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+
+		groupSchedules, err := r.GetSchedules(ctx, groupID)
+		if err != nil {
+			return nil, err
+		}
+
+		schedules = append(schedules, groupSchedules...)
+	}
+
+	sort.Slice(schedules, func(i, j int) bool {
+		return schedules[i].ID < schedules[j].ID
+	})
+
+	return schedules, nil
+}
+
+// DeleteSchedule removes the schedule of the group. It returns false
+// if the group has no schedule with the given ID.
+func (r *redisDatastore) DeleteSchedule(ctx context.Context, groupID int64, scheduleID int64) (bool, error) {
+	removed, err := r.client.HDel(ctx, schedulesKey(groupID), strconv.FormatInt(scheduleID, 10)).Result()
+	if err != nil {
+		return false, err
+	}
+
+	if removed == 0 {
+		return false, nil
+	}
+
+	err = r.client.HDel(ctx, scheduleGroupsKey, strconv.FormatInt(scheduleID, 10)).Err()
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// SetScheduleLastRunAt records when the scheduler last ran the schedule.
+func (r *redisDatastore) SetScheduleLastRunAt(ctx context.Context, scheduleID int64, lastRunAt time.Time) error {
+	id := strconv.FormatInt(scheduleID, 10)
+
+	groupID, err := r.client.HGet(ctx, scheduleGroupsKey, id).Int64()
+	if err != nil {
+		if errors.Is(err, goredis.Nil) {
+			return nil
+		}
+
+		return err
+	}
+
+	key := schedulesKey(groupID)
+	for attempt := 0; attempt < maxUpdateAttempts; attempt++ {
+		// The transaction fails if the schedules of the
+		// group were changed after they were read.
+		err := r.client.Watch(ctx, func(tx *goredis.Tx) error {
+			value, err := tx.HGet(ctx, key, id).Bytes()
+			if err != nil {
+				if errors.Is(err, goredis.Nil) {
+					return nil
+				}
+
+				return err
+			}
+
+			var schedule underattack.Schedule
+			err = json.Unmarshal(value, &schedule)
+			if err != nil {
+				return err
+			}
+
+			schedule.LastRunAt = lastRunAt
+
+			value, err = json.Marshal(schedule)
+			if err != nil {
+				return err
+			}
+
+			_, err = tx.TxPipelined(ctx, func(pipe goredis.Pipeliner) error {
+				pipe.HSet(ctx, key, id, value)
+				return nil
+			})
+			return err
+		}, key)
+		if errors.Is(err, goredis.TxFailedErr) {
+			continue
+		}
+
+		return err
+	}
+
+	return fmt.Errorf("setting last run of schedule %d: too many concurrent changes", scheduleID)
+}
+
+// InvalidateGroup records that the cached state of the group is stale.
+func (r *redisDatastore) InvalidateGroup(ctx context.Context, groupID int64) error {
+	return r.client.ZAdd(ctx, invalidationsKey, goredis.Z{Score: float64(time.Now().UnixMicro()), Member: groupID}).Err()
+}
+
+// GetInvalidatedGroups returns the groups that were invalidated since the given time.
+func (r *redisDatastore) GetInvalidatedGroups(ctx context.Context, since time.Time) ([]int64, error) {
+	members, err := r.client.ZRangeByScore(ctx, invalidationsKey, &goredis.ZRangeBy{
+		Min: strconv.FormatInt(since.UnixMicro(), 10),
+		Max: "+inf",
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	groupIDs := make([]int64, 0, len(members))
+	for _, member := range members {
+		groupID, err := strconv.ParseInt(member, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+
+		groupIDs = append(groupIDs, groupID)
+	}
+
+	return groupIDs, nil
+}
+
+// AcquireLease takes the lease with the given name for the holder, if it's
+// free or expired, or extends it if the holder already has it. It returns
+// true if the holder has the lease afterwards.
+func (r *redisDatastore) AcquireLease(ctx context.Context, name string, holder string, duration time.Duration) (bool, error) {
+	acquired, err := acquireLeaseScript.Run(ctx, r.client, []string{leaseKeyPrefix + name}, holder, duration.Milliseconds()).Int()
+	if err != nil {
+		return false, err
+	}
+
+	return acquired == 1, nil
+}
+
+// ReleaseLease gives up the lease with the given name, if the holder has it.
+func (r *redisDatastore) ReleaseLease(ctx context.Context, name string, holder string) error {
+	return releaseLeaseScript.Run(ctx, r.client, []string{leaseKeyPrefix + name}, holder).Err()
+}
+
+func (r *redisDatastore) Close() error {
+	return r.client.Close()
+}
+
+// parseEntry reads the under attack entry from the fields of its hash.
+// The missing fields are left empty.
+func parseEntry(fields map[string]string) (underattack.UnderAttack, error) {
+	var entry underattack.UnderAttack
+	var err error
+
+	for field, value := range fields {
+		switch field {
+		case fieldGroupID:
+			entry.GroupID, err = strconv.ParseInt(value, 10, 64)
+		case fieldIsUnderAttack:
+			entry.IsUnderAttack = value == "1"
+		case fieldNotificationMessageID:
+			entry.NotificationMessageID, err = strconv.ParseInt(value, 10, 64)
+		case fieldExpiresAt:
+			entry.ExpiresAt, err = parseTime(value)
+		case fieldUpdatedAt:
+			entry.UpdatedAt, err = parseTime(value)
+		case fieldAction:
+			entry.Action = underattack.Action(value)
+		case fieldActiveAction:
+			entry.ActiveAction = underattack.Action(value)
+		case fieldEnabledBy:
+			entry.EnabledBy, err = strconv.ParseInt(value, 10, 64)
+		case fieldLockdownPermissions:
+			entry.LockdownPermissions = value
+		case fieldInviteLink:
+			entry.InviteLink = value
+		case fieldTimezone:
+			entry.Timezone = value
+		}
+
+		if err != nil {
+			return underattack.UnderAttack{}, fmt.Errorf("parsing %s: %w", field, err)
+		}
+	}
+
+	return entry, nil
+}
+
+// formatTime converts the time into how it's stored. The zero time is
+// stored as an empty string.
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// parseTime is the reverse of formatTime.
+func parseTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	return time.Parse(time.RFC3339Nano, value)
+}
+
+func entryKey(groupID int64) string {
+	return entryKeyPrefix + strconv.FormatInt(groupID, 10)
+}
+
+func bansKey(groupID int64) string {
+	return bansKeyPrefix + strconv.FormatInt(groupID, 10)
+}
+
+func subscribersKey(groupID int64) string {
+	return subscribersKeyPrefix + strconv.FormatInt(groupID, 10)
+}
+
+func schedulesKey(groupID int64) string {
+	return schedulesKeyPrefix + strconv.FormatInt(groupID, 10)
+}
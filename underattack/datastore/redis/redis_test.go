@@ -0,0 +1,110 @@
+package redis_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"captcha-lite/logger/noop"
+	"captcha-lite/underattack"
+	"captcha-lite/underattack/datastore/redis"
+	"captcha-lite/underattack/datastoretest"
+
+	"github.com/alicebob/miniredis/v2"
+	goredis "github.com/redis/go-redis/v9"
+)
+
+func newClient(t *testing.T) (*goredis.Client, *miniredis.Miniredis) {
+	server := miniredis.RunT(t)
+	return goredis.NewClient(&goredis.Options{Addr: server.Addr()}), server
+}
+
+func TestNewRedisDatastore(t *testing.T) {
+	_, err := redis.NewRedisDatastore(nil, noop.New())
+	if err == nil || err.Error() != "nil client" {
+		t.Errorf("expecting an error of 'nil client', instead got %v", err)
+	}
+
+	client, _ := newClient(t)
+	defer func() {
+		_ = client.Close()
+	}()
+
+	_, err = redis.NewRedisDatastore(client, nil)
+	if err == nil || err.Error() != "nil logger" {
+		t.Errorf("expecting an error of 'nil logger', instead got %v", err)
+	}
+}
+
+func TestDatastore(t *testing.T) {
+	datastoretest.Run(t, func(t *testing.T) underattack.Datastore {
+		client, _ := newClient(t)
+
+		datastore, err := redis.NewRedisDatastore(client, noop.New())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		return datastore
+	})
+}
+
+// The server only expires the keys when the clock is moved forward, so
+// this can't use datastoretest.RunLease, which waits in real time.
+func TestLease(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	client, server := newClient(t)
+
+	datastore, err := redis.NewRedisDatastore(client, noop.New())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer func() {
+		_ = datastore.Close()
+	}()
+
+	ok, err := datastore.AcquireLease(ctx, "bot", "primary", time.Second*15)
+	if err != nil || !ok {
+		t.Fatalf("expecting a free lease to be acquired, got %t (%v)", ok, err)
+	}
+
+	ok, err = datastore.AcquireLease(ctx, "bot", "primary", time.Second*15)
+	if err != nil || !ok {
+		t.Errorf("expecting the holder to renew the lease, got %t (%v)", ok, err)
+	}
+
+	ok, err = datastore.AcquireLease(ctx, "bot", "standby", time.Second*15)
+	if err != nil || ok {
+		t.Errorf("expecting a held lease not to be acquired, got %t (%v)", ok, err)
+	}
+
+	// Someone that does not hold it can't release it.
+	err = datastore.ReleaseLease(ctx, "bot", "standby")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ok, err = datastore.AcquireLease(ctx, "bot", "standby", time.Second*15)
+	if err != nil || ok {
+		t.Errorf("expecting the lease to be kept, got %t (%v)", ok, err)
+	}
+
+	server.FastForward(time.Second * 16)
+
+	ok, err = datastore.AcquireLease(ctx, "bot", "standby", time.Second*15)
+	if err != nil || !ok {
+		t.Errorf("expecting an expired lease to be taken over, got %t (%v)", ok, err)
+	}
+
+	err = datastore.ReleaseLease(ctx, "bot", "standby")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ok, err = datastore.AcquireLease(ctx, "bot", "primary", time.Second*15)
+	if err != nil || !ok {
+		t.Errorf("expecting a released lease to be acquired, got %t (%v)", ok, err)
+	}
+}
@@ -0,0 +1,1255 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"captcha-lite/logger"
+	"captcha-lite/underattack"
+	"captcha-lite/underattack/datastore/migration"
+)
+
+// timestampLayout is how the times are stored. SQLite has no time type,
+// so they are stored as text in UTC with a fixed width, which keeps the
+// comparison of the texts the same as the comparison of the times.
+const timestampLayout = "2006-01-02 15:04:05.000000000"
+
+type sqliteDatastore struct {
+	db       *sql.DB
+	logger   logger.Logger
+	migrator *migration.Migrator
+}
+
+func NewSQLiteDatastore(db *sql.DB, logger logger.Logger) (*sqliteDatastore, error) {
+	if db == nil {
+		return nil, fmt.Errorf("nil db")
+	}
+
+	if logger == nil {
+		return nil, fmt.Errorf("nil logger")
+	}
+
+	// SQLite only allows one writer at a time. Sharing a single
+	// connection avoids "database is locked" errors between our
+	// own goroutines, and keeps an in-memory database alive.
+	db.SetMaxOpenConns(1)
+
+	migrator, err := migration.New(db, migration.SQLite, migrations)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sqliteDatastore{db: db, logger: logger, migrator: migrator}, nil
+}
+
+// Migrate will apply the schema migrations that have not been applied.
+func (s *sqliteDatastore) Migrate(ctx context.Context) error {
+	_, err := s.migrator.Up(ctx)
+	return err
+}
+
+// Migrator returns the schema migrations of the datastore, to be
+// applied, rolled back or inspected one at a time.
+func (s *sqliteDatastore) Migrator() *migration.Migrator {
+	return s.migrator
+}
+
+// GetUnderAttackEntry will acquire under attack entry for specified groupID.
+func (s *sqliteDatastore) GetUnderAttackEntry(ctx context.Context, groupID int64) (underattack.UnderAttack, error) {
+	c, err := s.db.Conn(ctx)
+	if err != nil {
+		return underattack.UnderAttack{}, err
+	}
+	defer func() {
+		err := c.Close()
+		if err != nil && !errors.Is(err, sql.ErrConnDone) {
+			s.logger.HandleError(err)
+		}
+	}()
+
+	tx, err := c.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelReadCommitted, ReadOnly: true})
+	if err != nil {
+		return underattack.UnderAttack{}, err
+	}
+
+	var entry underattack.UnderAttack
+
+	err = tx.QueryRowContext(
+		ctx,
+		`SELECT
+    	group_id,
+    	is_under_attack,
+    	expires_at,
+    	notification_message_id,
+    	updated_at,
+    	action,
+    	active_action,
+    	enabled_by,
+    	lockdown_permissions,
+    	invite_link,
+    	timezone
+    FROM
+        under_attack
+    WHERE
+        group_id = ?
+    ORDER BY
+        updated_at DESC`,
+		groupID,
+	).Scan(
+		&entry.GroupID,
+		&entry.IsUnderAttack,
+		&entry.ExpiresAt,
+		&entry.NotificationMessageID,
+		&entry.UpdatedAt,
+		&entry.Action,
+		&entry.ActiveAction,
+		&entry.EnabledBy,
+		&entry.LockdownPermissions,
+		&entry.InviteLink,
+		&entry.Timezone,
+	)
+	if err != nil {
+		if e := tx.Rollback(); e != nil {
+			return underattack.UnderAttack{}, e
+		}
+
+		if errors.Is(err, sql.ErrNoRows) {
+			go func(groupID int64) {
+				time.Sleep(time.Second * 5)
+				ctx, cancel := context.WithTimeout(context.Background(), time.Second*15)
+				defer cancel()
+
+				err := s.CreateNewEntry(ctx, groupID)
+				if err != nil {
+					s.logger.HandleError(err)
+				}
+			}(groupID)
+
+			return underattack.UnderAttack{}, nil
+		}
+
+		return underattack.UnderAttack{}, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		if e := tx.Rollback(); e != nil {
+			return underattack.UnderAttack{}, e
+		}
+
+		return underattack.UnderAttack{}, err
+	}
+
+	return entry, nil
+}
+
+// CreateNewEntry will create a new entry for given groupID.
+// This should only be executed if the group entry does not exists on the database.
+// If it already exists, it will do nothing.
+func (s *sqliteDatastore) CreateNewEntry(ctx context.Context, groupID int64) error {
+	c, err := s.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err := c.Close()
+		if err != nil && !errors.Is(err, sql.ErrConnDone) {
+			s.logger.HandleError(err)
+		}
+	}()
+
+	tx, err := c.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelReadCommitted})
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`INSERT INTO
+			under_attack
+			(group_id, is_under_attack, expires_at, notification_message_id, updated_at)
+		VALUES
+			(?, ?, ?, ?, ?)
+		ON CONFLICT (group_id)
+		DO NOTHING`,
+		groupID,
+		false,
+		timestamp(time.Time{}),
+		0,
+		timestamp(time.Now()),
+	)
+	if err != nil {
+		if e := tx.Rollback(); e != nil {
+			return e
+		}
+
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		if e := tx.Rollback(); e != nil {
+			return e
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// SetUnderAttackStatus will update the given groupID entry to the given parameters.
+// If the groupID entry does not exists, it will create a new one.
+func (s *sqliteDatastore) SetUnderAttackStatus(ctx context.Context, groupID int64, underAttack bool, expiresAt time.Time, notificationMessageID int64, activeAction underattack.Action) error {
+	c, err := s.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err := c.Close()
+		if err != nil && !errors.Is(err, sql.ErrConnDone) {
+			s.logger.HandleError(err)
+		}
+	}()
+
+	tx, err := c.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelReadCommitted})
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`INSERT INTO
+			under_attack
+			(group_id, is_under_attack, expires_at, notification_message_id, updated_at, active_action)
+		VALUES
+			(?, ?, ?, ?, ?, ?)
+		ON CONFLICT (group_id)
+		DO UPDATE
+		SET
+			is_under_attack = excluded.is_under_attack,
+			expires_at = excluded.expires_at,
+			notification_message_id = excluded.notification_message_id,
+			updated_at = excluded.updated_at,
+			active_action = excluded.active_action`,
+		groupID,
+		underAttack,
+		timestamp(expiresAt),
+		notificationMessageID,
+		timestamp(time.Now()),
+		string(activeAction),
+	)
+	if err != nil {
+		if e := tx.Rollback(); e != nil {
+			return e
+		}
+
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		if e := tx.Rollback(); e != nil {
+			return e
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// SetUnderAttackAction will set the action of the given groupID entry.
+// If the groupID entry does not exists, it will create a new one.
+func (s *sqliteDatastore) SetUnderAttackAction(ctx context.Context, groupID int64, action underattack.Action) error {
+	c, err := s.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err := c.Close()
+		if err != nil && !errors.Is(err, sql.ErrConnDone) {
+			s.logger.HandleError(err)
+		}
+	}()
+
+	tx, err := c.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelReadCommitted})
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`INSERT INTO
+			under_attack
+			(group_id, is_under_attack, expires_at, notification_message_id, updated_at, action)
+		VALUES
+			(?, ?, ?, ?, ?, ?)
+		ON CONFLICT (group_id)
+		DO UPDATE
+		SET
+			action = excluded.action,
+			updated_at = excluded.updated_at`,
+		groupID,
+		false,
+		timestamp(time.Time{}),
+		0,
+		timestamp(time.Now()),
+		string(action),
+	)
+	if err != nil {
+		if e := tx.Rollback(); e != nil {
+			return e
+		}
+
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		if e := tx.Rollback(); e != nil {
+			return e
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// CreateBan will record a user that was banned during the under attack mode.
+// If the user was already recorded on the group, it will be replaced.
+func (s *sqliteDatastore) CreateBan(ctx context.Context, ban underattack.Ban) error {
+	c, err := s.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err := c.Close()
+		if err != nil && !errors.Is(err, sql.ErrConnDone) {
+			s.logger.HandleError(err)
+		}
+	}()
+
+	tx, err := c.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelReadCommitted})
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`INSERT INTO
+			under_attack_bans
+			(group_id, user_id, name, username, attack_id, banned_at)
+		VALUES
+			(?, ?, ?, ?, ?, ?)
+		ON CONFLICT (group_id, user_id)
+		DO UPDATE
+		SET
+			name = excluded.name,
+			username = excluded.username,
+			attack_id = excluded.attack_id,
+			banned_at = excluded.banned_at`,
+		ban.GroupID,
+		ban.UserID,
+		ban.Name,
+		ban.Username,
+		ban.AttackID,
+		timestamp(ban.BannedAt),
+	)
+	if err != nil {
+		if e := tx.Rollback(); e != nil {
+			return e
+		}
+
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		if e := tx.Rollback(); e != nil {
+			return e
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// GetBans will acquire the users that were banned during the under attack
+// mode on the given groupID, latest first.
+func (s *sqliteDatastore) GetBans(ctx context.Context, groupID int64, limit int, offset int) ([]underattack.Ban, error) {
+	c, err := s.db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		err := c.Close()
+		if err != nil && !errors.Is(err, sql.ErrConnDone) {
+			s.logger.HandleError(err)
+		}
+	}()
+
+	rows, err := c.QueryContext(
+		ctx,
+		`SELECT
+			group_id,
+			user_id,
+			name,
+			username,
+			attack_id,
+			banned_at
+		FROM
+			under_attack_bans
+		WHERE
+			group_id = ?
+		ORDER BY
+			banned_at DESC,
+			user_id ASC
+		LIMIT ?
+		OFFSET ?`,
+		groupID,
+		limit,
+		offset,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		err := rows.Close()
+		if err != nil {
+			s.logger.HandleError(err)
+		}
+	}()
+
+	var bans []underattack.Ban
+	for rows.Next() {
+		var ban underattack.Ban
+		err := rows.Scan(
+			&ban.GroupID,
+			&ban.UserID,
+			&ban.Name,
+			&ban.Username,
+			&ban.AttackID,
+			&ban.BannedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		bans = append(bans, ban)
+	}
+
+	return bans, rows.Err()
+}
+
+// GetBan will acquire the record of the given userID on the given groupID.
+// The bool is false if the user was not banned during the under attack mode.
+func (s *sqliteDatastore) GetBan(ctx context.Context, groupID int64, userID int64) (underattack.Ban, bool, error) {
+	c, err := s.db.Conn(ctx)
+	if err != nil {
+		return underattack.Ban{}, false, err
+	}
+	defer func() {
+		err := c.Close()
+		if err != nil && !errors.Is(err, sql.ErrConnDone) {
+			s.logger.HandleError(err)
+		}
+	}()
+
+	var ban underattack.Ban
+	err = c.QueryRowContext(
+		ctx,
+		`SELECT
+			group_id,
+			user_id,
+			name,
+			username,
+			attack_id,
+			banned_at
+		FROM
+			under_attack_bans
+		WHERE
+			group_id = ?
+			AND user_id = ?`,
+		groupID,
+		userID,
+	).Scan(
+		&ban.GroupID,
+		&ban.UserID,
+		&ban.Name,
+		&ban.Username,
+		&ban.AttackID,
+		&ban.BannedAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return underattack.Ban{}, false, nil
+		}
+
+		return underattack.Ban{}, false, err
+	}
+
+	return ban, true, nil
+}
+
+// CountBans will count the users that were banned during the under attack
+// mode on the given groupID.
+func (s *sqliteDatastore) CountBans(ctx context.Context, groupID int64) (int, error) {
+	c, err := s.db.Conn(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		err := c.Close()
+		if err != nil && !errors.Is(err, sql.ErrConnDone) {
+			s.logger.HandleError(err)
+		}
+	}()
+
+	var count int
+	err = c.QueryRowContext(
+		ctx,
+		`SELECT COUNT(*) FROM under_attack_bans WHERE group_id = ?`,
+		groupID,
+	).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// DeleteBan will remove the record of the given userID on the given groupID.
+// It does nothing if the record does not exists.
+func (s *sqliteDatastore) DeleteBan(ctx context.Context, groupID int64, userID int64) error {
+	c, err := s.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err := c.Close()
+		if err != nil && !errors.Is(err, sql.ErrConnDone) {
+			s.logger.HandleError(err)
+		}
+	}()
+
+	_, err = c.ExecContext(
+		ctx,
+		`DELETE FROM under_attack_bans WHERE group_id = ? AND user_id = ?`,
+		groupID,
+		userID,
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// CountBansByAttack will count the users that were banned by the given
+// attackID on the given groupID.
+func (s *sqliteDatastore) CountBansByAttack(ctx context.Context, groupID int64, attackID int64) (int, error) {
+	c, err := s.db.Conn(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		err := c.Close()
+		if err != nil && !errors.Is(err, sql.ErrConnDone) {
+			s.logger.HandleError(err)
+		}
+	}()
+
+	var count int
+	err = c.QueryRowContext(
+		ctx,
+		`SELECT COUNT(*) FROM under_attack_bans WHERE group_id = ? AND attack_id = ?`,
+		groupID,
+		attackID,
+	).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// SetUnderAttackEnabledBy will set who turned on the under attack mode of the given groupID.
+// If the groupID entry does not exists, it will create a new one.
+func (s *sqliteDatastore) SetUnderAttackEnabledBy(ctx context.Context, groupID int64, userID int64) error {
+	c, err := s.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err := c.Close()
+		if err != nil && !errors.Is(err, sql.ErrConnDone) {
+			s.logger.HandleError(err)
+		}
+	}()
+
+	_, err = c.ExecContext(
+		ctx,
+		`INSERT INTO
+			under_attack
+			(group_id, is_under_attack, expires_at, notification_message_id, updated_at, enabled_by)
+		VALUES
+			(?, ?, ?, ?, ?, ?)
+		ON CONFLICT (group_id)
+		DO UPDATE
+		SET
+			enabled_by = excluded.enabled_by,
+			updated_at = excluded.updated_at`,
+		groupID,
+		false,
+		timestamp(time.Time{}),
+		0,
+		timestamp(time.Now()),
+		userID,
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// SetNotificationSubscription will subscribe or unsubscribe the given userID
+// to the private notifications of the under attack mode of the given groupID.
+func (s *sqliteDatastore) SetNotificationSubscription(ctx context.Context, groupID int64, userID int64, subscribed bool) error {
+	c, err := s.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err := c.Close()
+		if err != nil && !errors.Is(err, sql.ErrConnDone) {
+			s.logger.HandleError(err)
+		}
+	}()
+
+	if !subscribed {
+		_, err = c.ExecContext(
+			ctx,
+			`DELETE FROM under_attack_subscribers WHERE group_id = ? AND user_id = ?`,
+			groupID,
+			userID,
+		)
+		return err
+	}
+
+	_, err = c.ExecContext(
+		ctx,
+		`INSERT OR IGNORE INTO
+			under_attack_subscribers
+			(group_id, user_id, created_at)
+		VALUES
+			(?, ?, ?)`,
+		groupID,
+		userID,
+		timestamp(time.Now()),
+	)
+	return err
+}
+
+// GetNotificationSubscribers will acquire the ID of the users that are
+// subscribed to the private notifications of the given groupID.
+func (s *sqliteDatastore) GetNotificationSubscribers(ctx context.Context, groupID int64) ([]int64, error) {
+	c, err := s.db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		err := c.Close()
+		if err != nil && !errors.Is(err, sql.ErrConnDone) {
+			s.logger.HandleError(err)
+		}
+	}()
+
+	rows, err := c.QueryContext(
+		ctx,
+		`SELECT user_id FROM under_attack_subscribers WHERE group_id = ? ORDER BY created_at ASC`,
+		groupID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		err := rows.Close()
+		if err != nil {
+			s.logger.HandleError(err)
+		}
+	}()
+
+	var userIDs []int64
+	for rows.Next() {
+		var userID int64
+		err := rows.Scan(&userID)
+		if err != nil {
+			return nil, err
+		}
+
+		userIDs = append(userIDs, userID)
+	}
+
+	return userIDs, rows.Err()
+}
+
+// SetLockdownPermissions will set the snapshot of the group permissions before the lockdown.
+// If the groupID entry does not exists, it will create a new one.
+func (s *sqliteDatastore) SetLockdownPermissions(ctx context.Context, groupID int64, permissions string) error {
+	c, err := s.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err := c.Close()
+		if err != nil && !errors.Is(err, sql.ErrConnDone) {
+			s.logger.HandleError(err)
+		}
+	}()
+
+	_, err = c.ExecContext(
+		ctx,
+		`INSERT INTO
+			under_attack
+			(group_id, is_under_attack, expires_at, notification_message_id, updated_at, lockdown_permissions)
+		VALUES
+			(?, ?, ?, ?, ?, ?)
+		ON CONFLICT (group_id)
+		DO UPDATE
+		SET
+			lockdown_permissions = excluded.lockdown_permissions,
+			updated_at = excluded.updated_at`,
+		groupID,
+		false,
+		timestamp(time.Time{}),
+		0,
+		timestamp(time.Now()),
+		permissions,
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// SetInviteLink will set the invite link that was created for the attack.
+// If the groupID entry does not exists, it will create a new one.
+func (s *sqliteDatastore) SetInviteLink(ctx context.Context, groupID int64, inviteLink string) error {
+	c, err := s.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err := c.Close()
+		if err != nil && !errors.Is(err, sql.ErrConnDone) {
+			s.logger.HandleError(err)
+		}
+	}()
+
+	_, err = c.ExecContext(
+		ctx,
+		`INSERT INTO
+			under_attack
+			(group_id, is_under_attack, expires_at, notification_message_id, updated_at, invite_link)
+		VALUES
+			(?, ?, ?, ?, ?, ?)
+		ON CONFLICT (group_id)
+		DO UPDATE
+		SET
+			invite_link = excluded.invite_link,
+			updated_at = excluded.updated_at`,
+		groupID,
+		false,
+		timestamp(time.Time{}),
+		0,
+		timestamp(time.Now()),
+		inviteLink,
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// SetTimezone will set the timezone that the times are shown in for the group.
+// If the groupID entry does not exists, it will create a new one.
+func (s *sqliteDatastore) SetTimezone(ctx context.Context, groupID int64, timezone string) error {
+	c, err := s.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err := c.Close()
+		if err != nil && !errors.Is(err, sql.ErrConnDone) {
+			s.logger.HandleError(err)
+		}
+	}()
+
+	_, err = c.ExecContext(
+		ctx,
+		`INSERT INTO
+			under_attack
+			(group_id, is_under_attack, expires_at, notification_message_id, updated_at, timezone)
+		VALUES
+			(?, ?, ?, ?, ?, ?)
+		ON CONFLICT (group_id)
+		DO UPDATE
+		SET
+			timezone = excluded.timezone,
+			updated_at = excluded.updated_at`,
+		groupID,
+		false,
+		timestamp(time.Time{}),
+		0,
+		timestamp(time.Now()),
+		timezone,
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// CreateSchedule will create a new under attack schedule, and returns its ID.
+func (s *sqliteDatastore) CreateSchedule(ctx context.Context, schedule underattack.Schedule) (int64, error) {
+	c, err := s.db.Conn(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		err := c.Close()
+		if err != nil && !errors.Is(err, sql.ErrConnDone) {
+			s.logger.HandleError(err)
+		}
+	}()
+
+	result, err := c.ExecContext(
+		ctx,
+		`INSERT INTO
+			under_attack_schedules
+			(group_id, start_minute, end_minute, timezone, created_by, last_run_at, created_at)
+		VALUES
+			(?, ?, ?, ?, ?, ?, ?)`,
+		schedule.GroupID,
+		schedule.StartMinute,
+		schedule.EndMinute,
+		schedule.Timezone,
+		schedule.CreatedBy,
+		timestamp(schedule.LastRunAt),
+		timestamp(schedule.CreatedAt),
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.LastInsertId()
+}
+
+// GetSchedules will acquire the under attack schedules of the given groupID.
+func (s *sqliteDatastore) GetSchedules(ctx context.Context, groupID int64) ([]underattack.Schedule, error) {
+	c, err := s.db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		err := c.Close()
+		if err != nil && !errors.Is(err, sql.ErrConnDone) {
+			s.logger.HandleError(err)
+		}
+	}()
+
+	rows, err := c.QueryContext(
+		ctx,
+		`SELECT
+			id,
+			group_id,
+			start_minute,
+			end_minute,
+			timezone,
+			created_by,
+			last_run_at,
+			created_at
+		FROM
+			under_attack_schedules
+		WHERE
+			group_id = ?
+		ORDER BY
+			id ASC`,
+		groupID,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.scanSchedules(rows)
+}
+
+// GetAllSchedules will acquire the under attack schedules of every group.
+func (s *sqliteDatastore) GetAllSchedules(ctx context.Context) ([]underattack.Schedule, error) {
+	c, err := s.db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		err := c.Close()
+		if err != nil && !errors.Is(err, sql.ErrConnDone) {
+			s.logger.HandleError(err)
+		}
+	}()
+
+	rows, err := c.QueryContext(
+		ctx,
+		`SELECT
+			id,
+			group_id,
+			start_minute,
+			end_minute,
+			timezone,
+			created_by,
+			last_run_at,
+			created_at
+		FROM
+			under_attack_schedules
+		ORDER BY
+			id ASC`,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.scanSchedules(rows)
+}
+
+func (s *sqliteDatastore) scanSchedules(rows *sql.Rows) ([]underattack.Schedule, error) {
+	defer func() {
+		err := rows.Close()
+		if err != nil {
+			s.logger.HandleError(err)
+		}
+	}()
+
+	var schedules []underattack.Schedule
+	for rows.Next() {
+		var schedule underattack.Schedule
+		err := rows.Scan(
+			&schedule.ID,
+			&schedule.GroupID,
+			&schedule.StartMinute,
+			&schedule.EndMinute,
+			&schedule.Timezone,
+			&schedule.CreatedBy,
+			&schedule.LastRunAt,
+			&schedule.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		schedules = append(schedules, schedule)
+	}
+
+	return schedules, rows.Err()
+}
+
+// DeleteSchedule will delete the under attack schedule of the given groupID.
+// It returns false if the schedule does not exists on the group.
+func (s *sqliteDatastore) DeleteSchedule(ctx context.Context, groupID int64, scheduleID int64) (bool, error) {
+	c, err := s.db.Conn(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		err := c.Close()
+		if err != nil && !errors.Is(err, sql.ErrConnDone) {
+			s.logger.HandleError(err)
+		}
+	}()
+
+	result, err := c.ExecContext(
+		ctx,
+		`DELETE FROM under_attack_schedules WHERE group_id = ? AND id = ?`,
+		groupID,
+		scheduleID,
+	)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return affected > 0, nil
+}
+
+// SetScheduleLastRunAt will set when the under attack schedule was last run.
+func (s *sqliteDatastore) SetScheduleLastRunAt(ctx context.Context, scheduleID int64, lastRunAt time.Time) error {
+	c, err := s.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err := c.Close()
+		if err != nil && !errors.Is(err, sql.ErrConnDone) {
+			s.logger.HandleError(err)
+		}
+	}()
+
+	_, err = c.ExecContext(
+		ctx,
+		`UPDATE under_attack_schedules SET last_run_at = ? WHERE id = ?`,
+		timestamp(lastRunAt),
+		scheduleID,
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetExpiredUnderAttackEntries will acquire every entry that is still on under
+// attack mode, but already expired before the given time.
+func (s *sqliteDatastore) GetExpiredUnderAttackEntries(ctx context.Context, before time.Time) ([]underattack.UnderAttack, error) {
+	c, err := s.db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		err := c.Close()
+		if err != nil && !errors.Is(err, sql.ErrConnDone) {
+			s.logger.HandleError(err)
+		}
+	}()
+
+	rows, err := c.QueryContext(
+		ctx,
+		`SELECT
+			group_id,
+			is_under_attack,
+			expires_at,
+			notification_message_id,
+			updated_at,
+			action,
+			active_action,
+			enabled_by,
+			lockdown_permissions,
+			invite_link,
+			timezone
+		FROM
+			under_attack
+		WHERE
+			is_under_attack = TRUE
+			AND expires_at <= ?
+		ORDER BY
+			expires_at ASC`,
+		timestamp(before),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		err := rows.Close()
+		if err != nil {
+			s.logger.HandleError(err)
+		}
+	}()
+
+	var entries []underattack.UnderAttack
+	for rows.Next() {
+		var entry underattack.UnderAttack
+		err := rows.Scan(
+			&entry.GroupID,
+			&entry.IsUnderAttack,
+			&entry.ExpiresAt,
+			&entry.NotificationMessageID,
+			&entry.UpdatedAt,
+			&entry.Action,
+			&entry.ActiveAction,
+			&entry.EnabledBy,
+			&entry.LockdownPermissions,
+			&entry.InviteLink,
+			&entry.Timezone,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, rows.Err()
+}
+
+// InvalidateGroup records that the cached state of the group is stale,
+// for the other instances that share the database.
+func (s *sqliteDatastore) InvalidateGroup(ctx context.Context, groupID int64) error {
+	c, err := s.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err := c.Close()
+		if err != nil && !errors.Is(err, sql.ErrConnDone) {
+			s.logger.HandleError(err)
+		}
+	}()
+
+	// The instances compare the times that were written by each other,
+	// so they must agree on the timezone.
+	invalidatedAt := time.Now().UTC()
+
+	_, err = c.ExecContext(
+		ctx,
+		`INSERT INTO
+			under_attack_invalidations
+			(group_id, invalidated_at)
+		VALUES
+			(?, ?)
+		ON CONFLICT (group_id)
+		DO UPDATE
+		SET
+			invalidated_at = excluded.invalidated_at`,
+		groupID,
+		timestamp(invalidatedAt),
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetInvalidatedGroups returns the groups that were invalidated since the given time.
+func (s *sqliteDatastore) GetInvalidatedGroups(ctx context.Context, since time.Time) ([]int64, error) {
+	c, err := s.db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		err := c.Close()
+		if err != nil && !errors.Is(err, sql.ErrConnDone) {
+			s.logger.HandleError(err)
+		}
+	}()
+
+	rows, err := c.QueryContext(ctx, `SELECT group_id FROM under_attack_invalidations WHERE invalidated_at >= ?`, timestamp(since.UTC()))
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		err := rows.Close()
+		if err != nil {
+			s.logger.HandleError(err)
+		}
+	}()
+
+	var groupIDs []int64
+	for rows.Next() {
+		var groupID int64
+		err := rows.Scan(&groupID)
+		if err != nil {
+			return nil, err
+		}
+
+		groupIDs = append(groupIDs, groupID)
+	}
+
+	return groupIDs, rows.Err()
+}
+
+// AcquireLease takes the lease with the given name for the holder, if it's
+// free or expired, or extends it if the holder already has it. It returns
+// true if the holder has the lease afterwards.
+func (s *sqliteDatastore) AcquireLease(ctx context.Context, name string, holder string, duration time.Duration) (bool, error) {
+	c, err := s.db.Conn(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		err := c.Close()
+		if err != nil && !errors.Is(err, sql.ErrConnDone) {
+			s.logger.HandleError(err)
+		}
+	}()
+
+	// The instances compare the times that were written by each other,
+	// so they must agree on the timezone.
+	now := time.Now().UTC()
+	expiresAt := now.Add(duration)
+
+	_, err = c.ExecContext(
+		ctx,
+		`INSERT OR IGNORE INTO leader_leases (name, holder, expires_at) VALUES (?, ?, ?)`,
+		name,
+		holder,
+		timestamp(expiresAt),
+	)
+	if err != nil {
+		return false, err
+	}
+
+	// A single statement, so only one of the instances
+	// can take over an expired lease.
+	_, err = c.ExecContext(
+		ctx,
+		`UPDATE leader_leases SET holder = ?, expires_at = ? WHERE name = ? AND (holder = ? OR expires_at < ?)`,
+		holder,
+		timestamp(expiresAt),
+		name,
+		holder,
+		timestamp(now),
+	)
+	if err != nil {
+		return false, err
+	}
+
+	var current string
+	err = c.QueryRowContext(ctx, `SELECT holder FROM leader_leases WHERE name = ?`, name).Scan(&current)
+	if err != nil {
+		return false, err
+	}
+
+	return current == holder, nil
+}
+
+// ReleaseLease gives up the lease with the given name, if the holder has it.
+func (s *sqliteDatastore) ReleaseLease(ctx context.Context, name string, holder string) error {
+	c, err := s.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err := c.Close()
+		if err != nil && !errors.Is(err, sql.ErrConnDone) {
+			s.logger.HandleError(err)
+		}
+	}()
+
+	_, err = c.ExecContext(ctx, `DELETE FROM leader_leases WHERE name = ? AND holder = ?`, name, holder)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (s *sqliteDatastore) Close() error {
+	return s.db.Close()
+}
+
+// timestamp converts the time into how it's stored, see timestampLayout.
+func timestamp(t time.Time) string {
+	return t.UTC().Format(timestampLayout)
+}
@@ -0,0 +1,295 @@
+package sqlite_test
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"captcha-lite/leader"
+	"captcha-lite/logger/noop"
+	"captcha-lite/underattack"
+	"captcha-lite/underattack/datastore/sqlite"
+	"captcha-lite/underattack/datastoretest"
+
+	_ "modernc.org/sqlite"
+)
+
+var dependency underattack.Datastore
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "captcha-lite-sqlite")
+	if err != nil {
+		log.Fatalf("creating temporary directory: %s", err.Error())
+	}
+
+	db, err := sql.Open("sqlite", filepath.Join(dir, "captcha.db"))
+	if err != nil {
+		log.Fatalf("opening sqlite: %s", err.Error())
+	}
+
+	dependency, err = sqlite.NewSQLiteDatastore(db, noop.New())
+	if err != nil {
+		log.Fatalf("creating new sqlite datastore: %s", err.Error())
+	}
+
+	setupCtx, setupCancel := context.WithTimeout(context.Background(), time.Second*30)
+
+	err = dependency.Migrate(setupCtx)
+	if err != nil {
+		log.Fatalf("migrating tables: %s", err.Error())
+	}
+
+	err = Seed(setupCtx, db)
+	if err != nil {
+		log.Fatalf("seeding data: %s", err.Error())
+	}
+
+	exitCode := m.Run()
+
+	setupCancel()
+
+	err = dependency.Close()
+	if err != nil {
+		log.Printf("closing sqlite database: %s", err.Error())
+	}
+
+	err = os.RemoveAll(dir)
+	if err != nil {
+		log.Printf("removing temporary directory: %s", err.Error())
+	}
+
+	os.Exit(exitCode)
+}
+
+func Seed(ctx context.Context, db *sql.DB) error {
+	c, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err := c.Close()
+		if err != nil {
+			log.Print(err)
+		}
+	}()
+
+	tx, err := c.BeginTx(ctx, &sql.TxOptions{})
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`INSERT INTO
+			under_attack
+			(group_id, is_under_attack, expires_at, notification_message_id, updated_at)
+			VALUES
+			(?, ?, ?, ?, ?)`,
+		1,
+		true,
+		time.Now().Add(time.Hour*1).UTC().Format("2006-01-02 15:04:05.000000000"),
+		1002,
+		time.Now().UTC().Format("2006-01-02 15:04:05.000000000"),
+	)
+	if err != nil {
+		if e := tx.Rollback(); e != nil {
+			return e
+		}
+
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		if e := tx.Rollback(); e != nil {
+			return e
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+func TestNewSQLiteDatastore(t *testing.T) {
+	t.Run("Nil DB", func(t *testing.T) {
+		_, err := sqlite.NewSQLiteDatastore(nil, nil)
+		if err.Error() != "nil db" {
+			t.Errorf("expecting an error of 'nil db', instead got %s", err.Error())
+		}
+	})
+
+	t.Run("Nil logger", func(t *testing.T) {
+		_, err := sqlite.NewSQLiteDatastore(&sql.DB{}, nil)
+		if err.Error() != "nil logger" {
+			t.Errorf("expecting an error of 'nil logger', instead got %s", err.Error())
+		}
+	})
+}
+
+func TestMigrate(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+	defer cancel()
+
+	err := dependency.Migrate(ctx)
+	if err != nil {
+		t.Errorf("migrating database: %s", err.Error())
+	}
+}
+
+func TestGetUnderAttackEntry(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+	defer cancel()
+
+	entry, err := dependency.GetUnderAttackEntry(ctx, 1)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if entry.IsUnderAttack == false {
+		t.Error("expecting IsUnderAttack to be true, got false")
+	}
+
+	if entry.ExpiresAt.Before(time.Now()) {
+		t.Errorf("expecting ExpiresAt to be after now, got: %v", entry.ExpiresAt)
+	}
+
+	if entry.NotificationMessageID != 1002 {
+		t.Errorf("expecting NotificationMessageID to be 1002, got: %v", entry.NotificationMessageID)
+	}
+}
+func TestDatastore(t *testing.T) {
+	datastoretest.Run(t, func(t *testing.T) underattack.Datastore {
+		db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "captcha.db"))
+		if err != nil {
+			t.Fatalf("opening sqlite: %s", err.Error())
+		}
+
+		datastore, err := sqlite.NewSQLiteDatastore(db, noop.New())
+		if err != nil {
+			t.Fatalf("creating new sqlite datastore: %s", err.Error())
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+		defer cancel()
+
+		err = datastore.Migrate(ctx)
+		if err != nil {
+			t.Fatalf("migrating tables: %s", err.Error())
+		}
+
+		return datastore
+	})
+}
+
+func TestLease(t *testing.T) {
+	datastoretest.RunLease(t, func(t *testing.T) leader.Lease {
+		db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "captcha.db"))
+		if err != nil {
+			t.Fatalf("opening sqlite: %s", err.Error())
+		}
+
+		datastore, err := sqlite.NewSQLiteDatastore(db, noop.New())
+		if err != nil {
+			t.Fatalf("creating new sqlite datastore: %s", err.Error())
+		}
+		t.Cleanup(func() {
+			_ = datastore.Close()
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+		defer cancel()
+
+		err = datastore.Migrate(ctx)
+		if err != nil {
+			t.Fatalf("migrating tables: %s", err.Error())
+		}
+
+		return datastore
+	})
+}
+
+func TestMigrator(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+	defer cancel()
+
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "migrations.db"))
+	if err != nil {
+		t.Fatalf("opening sqlite: %s", err.Error())
+	}
+	defer func() {
+		err := db.Close()
+		if err != nil {
+			t.Errorf("closing sqlite: %s", err.Error())
+		}
+	}()
+
+	datastore, err := sqlite.NewSQLiteDatastore(db, noop.New())
+	if err != nil {
+		t.Fatalf("creating new sqlite datastore: %s", err.Error())
+	}
+
+	// A table that was created before the migrations were recorded,
+	// with a column that one of the migrations adds.
+	_, err = db.ExecContext(
+		ctx,
+		`CREATE TABLE under_attack (
+			group_id BIGINT PRIMARY KEY,
+			is_under_attack BOOLEAN NOT NULL,
+			expires_at DATETIME NOT NULL,
+			notification_message_id BIGINT NOT NULL,
+			updated_at DATETIME NOT NULL,
+			action VARCHAR(20) NOT NULL DEFAULT ''
+		)`,
+	)
+	if err != nil {
+		t.Fatalf("creating the old table: %s", err.Error())
+	}
+
+	err = datastore.Migrate(ctx)
+	if err != nil {
+		t.Fatalf("migrating database: %s", err.Error())
+	}
+
+	err = datastore.SetTimezone(ctx, 1, "Asia/Jakarta")
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	statuses, err := datastore.Migrator().Status(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, status := range statuses {
+		if !status.Applied {
+			t.Errorf("expecting migration %d to be applied", status.Version)
+		}
+	}
+
+	for range statuses {
+		_, ok, err := datastore.Migrator().Down(ctx)
+		if err != nil || !ok {
+			t.Fatalf("expecting a migration to be rolled back, got %v (%t)", err, ok)
+		}
+	}
+
+	var tables int
+	err = db.QueryRowContext(ctx, `SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name LIKE 'under_attack%'`).Scan(&tables)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if tables != 0 {
+		t.Errorf("expecting every table to be dropped, got %d", tables)
+	}
+
+	err = datastore.Migrate(ctx)
+	if err != nil {
+		t.Errorf("migrating database again: %s", err.Error())
+	}
+}
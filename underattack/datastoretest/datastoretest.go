@@ -0,0 +1,799 @@
+// Package datastoretest is the behavioral contract of underattack.Datastore.
+// Every datastore runs it from its own tests:
+//
+//	func TestDatastore(t *testing.T) {
+//		datastoretest.Run(t, func(t *testing.T) underattack.Datastore {
+//			// Create and migrate the datastore.
+//		})
+//	}
+//
+// The tests only touch the groups they create, with IDs that are unique
+// on every run, so the datastores may share a database with each other
+// and with the previous runs.
+package datastoretest
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"captcha-lite/underattack"
+)
+
+// lastGroupID is the last group ID that was handed out. It starts from
+// the current time, so the IDs won't collide with the previous runs.
+var lastGroupID = -time.Now().UnixNano() / int64(time.Microsecond)
+
+// newGroupID returns a group ID that has never been used.
+func newGroupID() int64 {
+	return atomic.AddInt64(&lastGroupID, -1)
+}
+
+// now returns the current time in the precision that every datastore
+// can store, so the times can be compared after a round trip.
+func now() time.Time {
+	return time.Now().UTC().Truncate(time.Second)
+}
+
+// Run runs every test of the contract against the datastores created by
+// newDatastore. It's called once for every test, and must return a
+// migrated datastore. Run closes the datastores.
+func Run(t *testing.T, newDatastore func(t *testing.T) underattack.Datastore) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, datastore underattack.Datastore)
+	}{
+		{name: "MissingEntry", test: testMissingEntry},
+		{name: "CreateNewEntry", test: testCreateNewEntry},
+		{name: "StatusTransitions", test: testStatusTransitions},
+		{name: "FieldsSurviveStatusChanges", test: testFieldsSurviveStatusChanges},
+		{name: "Expiry", test: testExpiry},
+		{name: "Bans", test: testBans},
+		{name: "NotificationSubscription", test: testNotificationSubscription},
+		{name: "Schedules", test: testSchedules},
+		{name: "Invalidations", test: testInvalidations},
+		{name: "ConcurrentWriters", test: testConcurrentWriters},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			datastore := newDatastore(t)
+			t.Cleanup(func() {
+				err := datastore.Close()
+				if err != nil {
+					t.Errorf("closing datastore: %v", err)
+				}
+			})
+
+			test.test(t, datastore)
+		})
+	}
+
+	t.Run("Close", func(t *testing.T) {
+		datastore := newDatastore(t)
+
+		err := datastore.Close()
+		if err != nil {
+			t.Errorf("expecting Close to succeed, got %v", err)
+		}
+	})
+}
+
+func testMissingEntry(t *testing.T, datastore underattack.Datastore) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+	defer cancel()
+
+	groupID := newGroupID()
+
+	entry, err := datastore.GetUnderAttackEntry(ctx, groupID)
+	if err != nil {
+		t.Fatalf("expecting a missing entry not to be an error, got %v", err)
+	}
+
+	if entry.IsUnderAttack || entry.NotificationMessageID != 0 || entry.Action != "" {
+		t.Errorf("expecting an empty entry, got %+v", entry)
+	}
+
+	bans, err := datastore.GetBans(ctx, groupID, 10, 0)
+	if err != nil || len(bans) != 0 {
+		t.Errorf("expecting no bans, got %v (%v)", bans, err)
+	}
+
+	count, err := datastore.CountBans(ctx, groupID)
+	if err != nil || count != 0 {
+		t.Errorf("expecting no bans, got %d (%v)", count, err)
+	}
+
+	subscribers, err := datastore.GetNotificationSubscribers(ctx, groupID)
+	if err != nil || len(subscribers) != 0 {
+		t.Errorf("expecting no subscribers, got %v (%v)", subscribers, err)
+	}
+
+	schedules, err := datastore.GetSchedules(ctx, groupID)
+	if err != nil || len(schedules) != 0 {
+		t.Errorf("expecting no schedules, got %v (%v)", schedules, err)
+	}
+
+	err = datastore.DeleteBan(ctx, groupID, 1)
+	if err != nil {
+		t.Errorf("expecting deleting a missing ban not to be an error, got %v", err)
+	}
+
+	removed, err := datastore.DeleteSchedule(ctx, groupID, 1)
+	if err != nil || removed {
+		t.Errorf("expecting nothing to be removed, got %t (%v)", removed, err)
+	}
+}
+
+func testCreateNewEntry(t *testing.T, datastore underattack.Datastore) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+	defer cancel()
+
+	groupID := newGroupID()
+
+	err := datastore.CreateNewEntry(ctx, groupID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	entry, err := datastore.GetUnderAttackEntry(ctx, groupID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if entry.GroupID != groupID {
+		t.Errorf("expecting the entry of group %d to be created, got %+v", groupID, entry)
+	}
+
+	if entry.IsUnderAttack {
+		t.Error("expecting a new entry not to be under attack")
+	}
+
+	expiresAt := now().Add(time.Hour)
+	err = datastore.SetUnderAttackStatus(ctx, groupID, true, expiresAt, 1001, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Creating it again must not touch the existing entry.
+	err = datastore.CreateNewEntry(ctx, groupID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	entry, err = datastore.GetUnderAttackEntry(ctx, groupID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !entry.IsUnderAttack || entry.NotificationMessageID != 1001 || !entry.ExpiresAt.Equal(expiresAt) {
+		t.Errorf("expecting the existing entry to be kept, got %+v", entry)
+	}
+}
+
+func testStatusTransitions(t *testing.T, datastore underattack.Datastore) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+	defer cancel()
+
+	groupID := newGroupID()
+	expiresAt := now().Add(time.Minute * 30)
+
+	// Setting the status creates the entry if it does not exists.
+	err := datastore.SetUnderAttackStatus(ctx, groupID, true, expiresAt, 1002, underattack.ActionKick)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	entry, err := datastore.GetUnderAttackEntry(ctx, groupID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if entry.GroupID != groupID || !entry.IsUnderAttack || entry.NotificationMessageID != 1002 || entry.ActiveAction != underattack.ActionKick {
+		t.Errorf("expecting the entry to be under attack, got %+v", entry)
+	}
+
+	if entry.Action != "" {
+		t.Errorf("expecting Action to be left as is, got %q", entry.Action)
+	}
+
+	if !entry.ExpiresAt.Equal(expiresAt) {
+		t.Errorf("expecting ExpiresAt to be %v, got %v", expiresAt, entry.ExpiresAt)
+	}
+
+	if entry.UpdatedAt.IsZero() {
+		t.Error("expecting UpdatedAt to be set")
+	}
+
+	extended := expiresAt.Add(time.Hour)
+	err = datastore.SetUnderAttackStatus(ctx, groupID, true, extended, 1002, underattack.ActionKick)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	entry, err = datastore.GetUnderAttackEntry(ctx, groupID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !entry.ExpiresAt.Equal(extended) {
+		t.Errorf("expecting ExpiresAt to be extended to %v, got %v", extended, entry.ExpiresAt)
+	}
+
+	err = datastore.SetUnderAttackStatus(ctx, groupID, false, time.Time{}, 0, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	entry, err = datastore.GetUnderAttackEntry(ctx, groupID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if entry.IsUnderAttack || entry.NotificationMessageID != 0 || entry.ActiveAction != "" {
+		t.Errorf("expecting the entry not to be under attack, got %+v", entry)
+	}
+}
+
+func testFieldsSurviveStatusChanges(t *testing.T, datastore underattack.Datastore) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+	defer cancel()
+
+	groupID := newGroupID()
+
+	// Each of these creates the entry if it does not exists.
+	setters := []func() error{
+		func() error { return datastore.SetUnderAttackAction(ctx, groupID, underattack.ActionTemporaryBan) },
+		func() error { return datastore.SetUnderAttackEnabledBy(ctx, groupID, 42) },
+		func() error { return datastore.SetLockdownPermissions(ctx, groupID, `{"can_send_messages":true}`) },
+		func() error { return datastore.SetInviteLink(ctx, groupID, "https://t.me/+abc") },
+		func() error { return datastore.SetTimezone(ctx, groupID, "Asia/Jakarta") },
+	}
+	for _, set := range setters {
+		err := set()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	check := func(when string) {
+		t.Helper()
+
+		entry, err := datastore.GetUnderAttackEntry(ctx, groupID)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if entry.Action != underattack.ActionTemporaryBan {
+			t.Errorf("%s: expecting Action to be %q, got %q", when, underattack.ActionTemporaryBan, entry.Action)
+		}
+
+		if entry.EnabledBy != 42 {
+			t.Errorf("%s: expecting EnabledBy to be 42, got %d", when, entry.EnabledBy)
+		}
+
+		if entry.LockdownPermissions != `{"can_send_messages":true}` {
+			t.Errorf("%s: unexpected LockdownPermissions: %q", when, entry.LockdownPermissions)
+		}
+
+		if entry.InviteLink != "https://t.me/+abc" {
+			t.Errorf("%s: unexpected InviteLink: %q", when, entry.InviteLink)
+		}
+
+		if entry.Timezone != "Asia/Jakarta" {
+			t.Errorf("%s: unexpected Timezone: %q", when, entry.Timezone)
+		}
+	}
+
+	check("before the status changes")
+
+	err := datastore.SetUnderAttackStatus(ctx, groupID, true, now().Add(time.Hour), 1003, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	check("after turning it on")
+
+	err = datastore.SetUnderAttackStatus(ctx, groupID, false, time.Time{}, 0, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	check("after turning it off")
+
+	// Setting a field must not touch the status either.
+	err = datastore.SetUnderAttackStatus(ctx, groupID, true, now().Add(time.Hour), 1003, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	err = datastore.SetTimezone(ctx, groupID, "Asia/Jakarta")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	entry, err := datastore.GetUnderAttackEntry(ctx, groupID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !entry.IsUnderAttack || entry.NotificationMessageID != 1003 {
+		t.Errorf("expecting the status to be kept, got %+v", entry)
+	}
+}
+
+func testExpiry(t *testing.T, datastore underattack.Datastore) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+	defer cancel()
+
+	before := now()
+
+	active := newGroupID()
+	expiredLater := newGroupID()
+	expiredEarlier := newGroupID()
+	expiringNow := newGroupID()
+	turnedOff := newGroupID()
+	// Longer than a day, which some caches keep their entries for.
+	longAttack := newGroupID()
+
+	statuses := []struct {
+		groupID     int64
+		underAttack bool
+		expiresAt   time.Time
+	}{
+		{groupID: active, underAttack: true, expiresAt: before.Add(time.Hour)},
+		{groupID: expiredLater, underAttack: true, expiresAt: before.Add(-time.Minute)},
+		{groupID: expiredEarlier, underAttack: true, expiresAt: before.Add(-time.Hour)},
+		{groupID: expiringNow, underAttack: true, expiresAt: before},
+		{groupID: turnedOff, underAttack: false, expiresAt: before.Add(-time.Hour)},
+		{groupID: longAttack, underAttack: true, expiresAt: before.Add(underattack.MaxDuration)},
+	}
+	for _, status := range statuses {
+		err := datastore.SetUnderAttackStatus(ctx, status.groupID, status.underAttack, status.expiresAt, 1004, "")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	entries, err := datastore.GetExpiredUnderAttackEntries(ctx, before)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The other tests might have left expired entries too,
+	// only ours are checked.
+	var expired []int64
+	for _, entry := range entries {
+		switch entry.GroupID {
+		case active:
+			t.Error("expecting the active entry not to be expired")
+		case turnedOff:
+			t.Error("expecting the entry that is turned off not to be expired")
+		case longAttack:
+			t.Error("expecting the long attack not to be expired yet")
+		case expiredEarlier, expiredLater, expiringNow:
+			expired = append(expired, entry.GroupID)
+		}
+	}
+
+	// Earliest expiry first, and the expiry is inclusive.
+	want := []int64{expiredEarlier, expiredLater, expiringNow}
+	if fmt.Sprint(expired) != fmt.Sprint(want) {
+		t.Errorf("expecting the expired entries to be %v, got %v", want, expired)
+	}
+
+	// The long attack is still there to be ended when it expires.
+	entries, err = datastore.GetExpiredUnderAttackEntries(ctx, before.Add(underattack.MaxDuration))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var found bool
+	for _, entry := range entries {
+		if entry.GroupID == longAttack {
+			found = entry.NotificationMessageID == 1004 && entry.ExpiresAt.Equal(before.Add(underattack.MaxDuration))
+		}
+	}
+
+	if !found {
+		t.Error("expecting the long attack to be expired at the end of it")
+	}
+}
+
+func testBans(t *testing.T, datastore underattack.Datastore) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+	defer cancel()
+
+	groupID := newGroupID()
+	otherGroupID := newGroupID()
+	bannedAt := now()
+
+	for i := int64(1); i <= 3; i++ {
+		err := datastore.CreateBan(ctx, underattack.Ban{
+			GroupID:  groupID,
+			UserID:   100 + i,
+			Name:     "User",
+			Username: "user",
+			AttackID: 1005,
+			BannedAt: bannedAt.Add(time.Duration(i) * time.Second),
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	// Same time as 102, it's ordered by the user ID.
+	err := datastore.CreateBan(ctx, underattack.Ban{GroupID: groupID, UserID: 99, AttackID: 1006, BannedAt: bannedAt.Add(time.Second * 2)})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	err = datastore.CreateBan(ctx, underattack.Ban{GroupID: otherGroupID, UserID: 101, AttackID: 1005, BannedAt: bannedAt})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	count, err := datastore.CountBans(ctx, groupID)
+	if err != nil || count != 4 {
+		t.Errorf("expecting 4 bans, got %d (%v)", count, err)
+	}
+
+	count, err = datastore.CountBansByAttack(ctx, groupID, 1005)
+	if err != nil || count != 3 {
+		t.Errorf("expecting 3 bans on attack 1005, got %d (%v)", count, err)
+	}
+
+	bans, err := datastore.GetBans(ctx, groupID, 10, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var userIDs []int64
+	for _, ban := range bans {
+		userIDs = append(userIDs, ban.UserID)
+	}
+
+	if fmt.Sprint(userIDs) != fmt.Sprint([]int64{103, 99, 102, 101}) {
+		t.Errorf("expecting the latest bans first, got %v", userIDs)
+	}
+
+	if len(bans) > 0 && (bans[0].GroupID != groupID || bans[0].Name != "User" || bans[0].Username != "user" ||
+		bans[0].AttackID != 1005 || !bans[0].BannedAt.Equal(bannedAt.Add(time.Second*3))) {
+		t.Errorf("unexpected ban: %+v", bans[0])
+	}
+
+	page, err := datastore.GetBans(ctx, groupID, 2, 2)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(page) != 2 || page[0].UserID != 102 || page[1].UserID != 101 {
+		t.Errorf("expecting the second page to be 102 and 101, got %v", page)
+	}
+
+	// Banning the same user again replaces the record.
+	err = datastore.CreateBan(ctx, underattack.Ban{GroupID: groupID, UserID: 101, Name: "Renamed", AttackID: 1007, BannedAt: bannedAt.Add(time.Minute)})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	bans, err = datastore.GetBans(ctx, groupID, 1, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(bans) != 1 || bans[0].UserID != 101 || bans[0].Name != "Renamed" || bans[0].AttackID != 1007 {
+		t.Errorf("expecting the ban to be replaced, got %v", bans)
+	}
+
+	count, err = datastore.CountBans(ctx, groupID)
+	if err != nil || count != 4 {
+		t.Errorf("expecting 4 bans after replacing, got %d (%v)", count, err)
+	}
+
+	ban, ok, err := datastore.GetBan(ctx, groupID, 101)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !ok || ban.UserID != 101 || ban.Name != "Renamed" || ban.AttackID != 1007 {
+		t.Errorf("expecting the replaced ban, got %v (%t)", ban, ok)
+	}
+
+	err = datastore.DeleteBan(ctx, groupID, 101)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	_, ok, err = datastore.GetBan(ctx, groupID, 101)
+	if err != nil || ok {
+		t.Errorf("expecting the deleted ban to be missing, got %t (%v)", ok, err)
+	}
+
+	count, err = datastore.CountBans(ctx, groupID)
+	if err != nil || count != 3 {
+		t.Errorf("expecting 3 bans after deletion, got %d (%v)", count, err)
+	}
+
+	count, err = datastore.CountBans(ctx, otherGroupID)
+	if err != nil || count != 1 {
+		t.Errorf("expecting the ban of the other group to be kept, got %d (%v)", count, err)
+	}
+}
+
+func testNotificationSubscription(t *testing.T, datastore underattack.Datastore) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+	defer cancel()
+
+	groupID := newGroupID()
+
+	for _, userID := range []int64{300, 301, 300} {
+		err := datastore.SetNotificationSubscription(ctx, groupID, userID, true)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	subscribers, err := datastore.GetNotificationSubscribers(ctx, groupID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(subscribers) != 2 {
+		t.Errorf("expecting subscribing twice to count once, got %v", subscribers)
+	}
+
+	err = datastore.SetNotificationSubscription(ctx, groupID, 301, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Unsubscribing someone that is not subscribed does nothing.
+	err = datastore.SetNotificationSubscription(ctx, groupID, 302, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	subscribers, err = datastore.GetNotificationSubscribers(ctx, groupID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(subscribers) != 1 || subscribers[0] != 300 {
+		t.Errorf("expecting only 300 to be subscribed, got %v", subscribers)
+	}
+}
+
+func testSchedules(t *testing.T, datastore underattack.Datastore) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+	defer cancel()
+
+	groupID := newGroupID()
+	otherGroupID := newGroupID()
+	createdAt := now()
+
+	id, err := datastore.CreateSchedule(ctx, underattack.Schedule{
+		GroupID:     groupID,
+		StartMinute: 0,
+		EndMinute:   360,
+		Timezone:    "Asia/Jakarta",
+		CreatedBy:   42,
+		CreatedAt:   createdAt,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	otherID, err := datastore.CreateSchedule(ctx, underattack.Schedule{
+		GroupID:     groupID,
+		StartMinute: 1320,
+		EndMinute:   60,
+		Timezone:    "UTC",
+		CreatedAt:   createdAt,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if id == otherID {
+		t.Errorf("expecting the schedules to have different IDs, got %d", id)
+	}
+
+	lastRunAt := now()
+	err = datastore.SetScheduleLastRunAt(ctx, id, lastRunAt)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	schedules, err := datastore.GetSchedules(ctx, groupID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(schedules) != 2 || schedules[0].ID != id || schedules[1].ID != otherID {
+		t.Fatalf("expecting both schedules in the order they were created, got %v", schedules)
+	}
+
+	schedule := schedules[0]
+	if schedule.GroupID != groupID || schedule.StartMinute != 0 || schedule.EndMinute != 360 ||
+		schedule.Timezone != "Asia/Jakarta" || schedule.CreatedBy != 42 || !schedule.CreatedAt.Equal(createdAt) {
+		t.Errorf("unexpected schedule: %+v", schedule)
+	}
+
+	if !schedule.LastRunAt.Equal(lastRunAt) {
+		t.Errorf("expecting LastRunAt to be %v, got %v", lastRunAt, schedule.LastRunAt)
+	}
+
+	all, err := datastore.GetAllSchedules(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var found int
+	for _, schedule := range all {
+		if schedule.GroupID == groupID {
+			found++
+		}
+	}
+
+	if found != 2 {
+		t.Errorf("expecting both schedules among every schedule, got %d", found)
+	}
+
+	removed, err := datastore.DeleteSchedule(ctx, otherGroupID, id)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if removed {
+		t.Error("must not remove the schedule of another group")
+	}
+
+	removed, err = datastore.DeleteSchedule(ctx, groupID, id)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !removed {
+		t.Error("expecting the schedule to be removed")
+	}
+
+	schedules, err = datastore.GetSchedules(ctx, groupID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(schedules) != 1 || schedules[0].ID != otherID {
+		t.Errorf("expecting only the other schedule to be left, got %v", schedules)
+	}
+}
+
+func testInvalidations(t *testing.T, datastore underattack.Datastore) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+	defer cancel()
+
+	// Some databases only store the seconds.
+	since := now().Add(-time.Second)
+
+	groupID := newGroupID()
+	otherGroupID := newGroupID()
+
+	for _, id := range []int64{groupID, otherGroupID, groupID} {
+		err := datastore.InvalidateGroup(ctx, id)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	invalidated, err := datastore.GetInvalidatedGroups(ctx, since)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The other tests might have invalidated groups too,
+	// only ours are checked.
+	seen := make(map[int64]int)
+	for _, id := range invalidated {
+		seen[id]++
+	}
+
+	if seen[groupID] != 1 || seen[otherGroupID] != 1 {
+		t.Errorf("expecting both groups to be invalidated once, got %v", invalidated)
+	}
+
+	invalidated, err = datastore.GetInvalidatedGroups(ctx, now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, id := range invalidated {
+		if id == groupID || id == otherGroupID {
+			t.Errorf("expecting group %d not to be invalidated after an hour from now", id)
+		}
+	}
+}
+
+func testConcurrentWriters(t *testing.T, datastore underattack.Datastore) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+	defer cancel()
+
+	groupID := newGroupID()
+	const writers = 10
+
+	var wg sync.WaitGroup
+	errs := make(chan error, writers*4+5)
+
+	// Writers of different fields of the same entry must not
+	// overwrite each other.
+	fields := []func() error{
+		func() error {
+			return datastore.SetUnderAttackStatus(ctx, groupID, true, now().Add(time.Hour), 1008, underattack.ActionRestrict)
+		},
+		func() error { return datastore.SetUnderAttackAction(ctx, groupID, underattack.ActionKick) },
+		func() error { return datastore.SetUnderAttackEnabledBy(ctx, groupID, 42) },
+		func() error { return datastore.SetInviteLink(ctx, groupID, "https://t.me/+abc") },
+		func() error { return datastore.SetTimezone(ctx, groupID, "Asia/Jakarta") },
+	}
+	for _, set := range fields {
+		wg.Add(1)
+		go func(set func() error) {
+			defer wg.Done()
+			errs <- set()
+		}(set)
+	}
+
+	for i := int64(0); i < writers; i++ {
+		wg.Add(3)
+		go func(userID int64) {
+			defer wg.Done()
+			errs <- datastore.CreateBan(ctx, underattack.Ban{GroupID: groupID, UserID: userID, AttackID: 1008, BannedAt: now()})
+		}(400 + i)
+		go func(userID int64) {
+			defer wg.Done()
+			errs <- datastore.SetNotificationSubscription(ctx, groupID, userID, true)
+		}(400 + i)
+		go func(minute int) {
+			defer wg.Done()
+			_, err := datastore.CreateSchedule(ctx, underattack.Schedule{GroupID: groupID, StartMinute: minute, EndMinute: minute + 60, Timezone: "UTC", CreatedAt: now()})
+			errs <- err
+		}(int(i))
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+
+	entry, err := datastore.GetUnderAttackEntry(ctx, groupID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !entry.IsUnderAttack || entry.NotificationMessageID != 1008 || entry.Action != underattack.ActionKick ||
+		entry.ActiveAction != underattack.ActionRestrict || entry.EnabledBy != 42 || entry.InviteLink != "https://t.me/+abc" || entry.Timezone != "Asia/Jakarta" {
+		t.Errorf("expecting every field to be written, got %+v", entry)
+	}
+
+	count, err := datastore.CountBans(ctx, groupID)
+	if err != nil || count != writers {
+		t.Errorf("expecting %d bans, got %d (%v)", writers, count, err)
+	}
+
+	subscribers, err := datastore.GetNotificationSubscribers(ctx, groupID)
+	if err != nil || len(subscribers) != writers {
+		t.Errorf("expecting %d subscribers, got %v (%v)", writers, subscribers, err)
+	}
+
+	schedules, err := datastore.GetSchedules(ctx, groupID)
+	if err != nil || len(schedules) != writers {
+		t.Errorf("expecting %d schedules, got %d (%v)", writers, len(schedules), err)
+	}
+}
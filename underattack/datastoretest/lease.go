@@ -0,0 +1,81 @@
+package datastoretest
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"captcha-lite/leader"
+)
+
+// RunLease runs the contract of leader.Lease against the lease returned by
+// newLease. The datastores that hold the leases run it next to Run.
+func RunLease(t *testing.T, newLease func(t *testing.T) leader.Lease) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+	defer cancel()
+
+	l := newLease(t)
+
+	// Unique on every run, as the database might be shared.
+	name := "test-" + strconv.FormatInt(newGroupID(), 10)
+
+	ok, err := l.AcquireLease(ctx, name, "primary", time.Hour)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !ok {
+		t.Fatal("expecting a free lease to be acquired")
+	}
+
+	ok, err = l.AcquireLease(ctx, name, "primary", time.Hour)
+	if err != nil || !ok {
+		t.Errorf("expecting the holder to renew the lease, got %t (%v)", ok, err)
+	}
+
+	ok, err = l.AcquireLease(ctx, name, "standby", time.Hour)
+	if err != nil || ok {
+		t.Errorf("expecting a held lease not to be acquired, got %t (%v)", ok, err)
+	}
+
+	// Another name is another lease.
+	ok, err = l.AcquireLease(ctx, name+"-other", "standby", time.Hour)
+	if err != nil || !ok {
+		t.Errorf("expecting another lease to be acquired, got %t (%v)", ok, err)
+	}
+
+	// Someone that does not hold it can't release it.
+	err = l.ReleaseLease(ctx, name, "standby")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ok, err = l.AcquireLease(ctx, name, "standby", time.Hour)
+	if err != nil || ok {
+		t.Errorf("expecting the lease to be kept, got %t (%v)", ok, err)
+	}
+
+	err = l.ReleaseLease(ctx, name, "primary")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ok, err = l.AcquireLease(ctx, name, "standby", time.Second*2)
+	if err != nil || !ok {
+		t.Errorf("expecting a released lease to be acquired, got %t (%v)", ok, err)
+	}
+
+	// Some databases only store the seconds.
+	time.Sleep(time.Second * 4)
+
+	ok, err = l.AcquireLease(ctx, name, "primary", time.Hour)
+	if err != nil || !ok {
+		t.Errorf("expecting an expired lease to be taken over, got %t (%v)", ok, err)
+	}
+
+	ok, err = l.AcquireLease(ctx, name, "standby", time.Hour)
+	if err != nil || ok {
+		t.Errorf("expecting the previous holder to have lost the lease, got %t (%v)", ok, err)
+	}
+}
@@ -0,0 +1,106 @@
+package underattack
+
+import (
+	"sync"
+	"time"
+)
+
+// JoinRateDetector keeps a sliding window of user joins for every chat,
+// and trips when there are too many joins within the window.
+//
+// After tripping, it won't trip again for the same chat until the
+// cooldown has passed, so the under attack mode doesn't flap on and off
+// during a long raid.
+type JoinRateDetector struct {
+	// Threshold is the number of joins within Window that trips the detector.
+	Threshold int
+	// Window is the length of the sliding window.
+	Window time.Duration
+	// Cooldown is the minimum time between two trips on the same chat.
+	Cooldown time.Duration
+
+	mu          sync.Mutex
+	joins       map[int64][]time.Time
+	lastTrips   map[int64]time.Time
+	lastCleanup time.Time
+}
+
+// NewJoinRateDetector creates a new JoinRateDetector.
+func NewJoinRateDetector(threshold int, window time.Duration, cooldown time.Duration) *JoinRateDetector {
+	return &JoinRateDetector{
+		Threshold: threshold,
+		Window:    window,
+		Cooldown:  cooldown,
+		joins:     make(map[int64][]time.Time),
+		lastTrips: make(map[int64]time.Time),
+	}
+}
+
+// Record records a join on the given chat at the given time, and returns
+// the number of joins within the window along with whether the detector
+// has tripped. The trip only counts once it's committed with Trip, so the
+// join that comes after a failed activation trips it again.
+func (j *JoinRateDetector) Record(chatID int64, at time.Time) (int, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	// Slide the window.
+	joins := j.joins[chatID]
+	start := 0
+	for start < len(joins) && at.Sub(joins[start]) >= j.Window {
+		start++
+	}
+	joins = append(joins[start:], at)
+	j.joins[chatID] = joins
+
+	j.cleanup(at)
+
+	if len(joins) < j.Threshold {
+		return len(joins), false
+	}
+
+	if lastTrip, ok := j.lastTrips[chatID]; ok && at.Sub(lastTrip) < j.Cooldown {
+		return len(joins), false
+	}
+
+	return len(joins), true
+}
+
+// Trip commits the trip on the given chat at the given time, which starts
+// the cooldown.
+func (j *JoinRateDetector) Trip(chatID int64, at time.Time) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.lastTrips[chatID] = at
+	// Start over, the next trip should count the joins from now on.
+	delete(j.joins, chatID)
+}
+
+// cleanup removes the chats that can't affect anything anymore,
+// so the maps don't grow forever. It scans every chat, so it only
+// runs once per window or cooldown, whichever is longer, instead of
+// on every join.
+func (j *JoinRateDetector) cleanup(now time.Time) {
+	interval := j.Window
+	if j.Cooldown > interval {
+		interval = j.Cooldown
+	}
+
+	if now.Sub(j.lastCleanup) < interval {
+		return
+	}
+	j.lastCleanup = now
+
+	for chatID, joins := range j.joins {
+		if len(joins) == 0 || now.Sub(joins[len(joins)-1]) >= j.Window {
+			delete(j.joins, chatID)
+		}
+	}
+
+	for chatID, lastTrip := range j.lastTrips {
+		if now.Sub(lastTrip) >= j.Cooldown {
+			delete(j.lastTrips, chatID)
+		}
+	}
+}
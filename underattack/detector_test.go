@@ -0,0 +1,66 @@
+package underattack_test
+
+import (
+	"testing"
+	"time"
+
+	"captcha-lite/underattack"
+)
+
+func TestJoinRateDetector(t *testing.T) {
+	detector := underattack.NewJoinRateDetector(3, time.Minute, time.Minute*30)
+	now := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	if _, tripped := detector.Record(1, now); tripped {
+		t.Error("must not trip on the first join")
+	}
+
+	// Slides out of the window.
+	if _, tripped := detector.Record(1, now.Add(time.Second*61)); tripped {
+		t.Error("must not trip after the window slides")
+	}
+
+	if _, tripped := detector.Record(1, now.Add(time.Second*62)); tripped {
+		t.Error("must not trip below the threshold")
+	}
+
+	// Another chat must not be affected.
+	if _, tripped := detector.Record(2, now.Add(time.Second*62)); tripped {
+		t.Error("must not trip for another chat")
+	}
+
+	joins, tripped := detector.Record(1, now.Add(time.Second*63))
+	if !tripped {
+		t.Error("must trip on the threshold")
+	}
+
+	if joins != 3 {
+		t.Errorf("expecting 3 joins, got %d", joins)
+	}
+
+	// Until the trip is committed, it trips on every join.
+	if _, tripped := detector.Record(1, now.Add(time.Second*63)); !tripped {
+		t.Error("must trip again before the trip is committed")
+	}
+
+	detector.Trip(1, now.Add(time.Second*63))
+
+	// Cooldown
+	for i := 0; i < 5; i++ {
+		if _, tripped := detector.Record(1, now.Add(time.Second*time.Duration(64+i))); tripped {
+			t.Error("must not trip during the cooldown")
+		}
+	}
+
+	later := now.Add(time.Minute * 32)
+	detector.Record(1, later)
+	detector.Record(1, later)
+	if _, tripped := detector.Record(1, later); !tripped {
+		t.Error("must trip again after the cooldown")
+	}
+
+	detector.Trip(1, later)
+	if _, tripped := detector.Record(1, later); tripped {
+		t.Error("must count the joins from the trip on")
+	}
+}
@@ -2,12 +2,13 @@ package underattack
 
 import (
 	"context"
+	"fmt"
 	"strconv"
 	"strings"
 	"time"
 
 	"captcha-lite/locale"
-	"captcha-lite/utils"
+	"captcha-lite/logger"
 
 	tb "gopkg.in/telebot.v3"
 )
@@ -18,95 +19,187 @@ func (d *Dependency) EnableUnderAttackModeHandler(c tb.Context) error {
 		return nil
 	}
 
-	admins, err := c.Bot().AdminsOf(c.Chat())
-	if err != nil {
-		d.Logger.HandleBotError(err, d.Bot, c.Message())
+	if !d.requireAdmin(c) {
 		return nil
 	}
 
-	if !utils.IsAdmin(admins, c.Sender()) {
-		_, err := c.Bot().Send(
-			c.Chat(),
-			d.Locale[locale.MessageUnderAttackOnlyAdmin],
-			&tb.SendOptions{
-				ReplyTo:           c.Message(),
-				AllowWithoutReply: true,
-			},
-		)
+	// Sender must be an admin here.
+	// The command is either "/underattack [lockdown] [revokelinks] [duration]",
+	// "/underattack extend <duration>", "/underattack action [action]"
+	// "/underattack schedule add|list|remove" or "/underattack timezone [timezone]".
+	args := c.Args()
+	if len(args) > 0 && strings.EqualFold(args[0], "action") {
+		return d.actionHandler(c, args[1:])
+	}
+
+	if len(args) > 0 && strings.EqualFold(args[0], "schedule") {
+		return d.scheduleHandler(c, args[1:])
+	}
+
+	if len(args) > 0 && strings.EqualFold(args[0], "timezone") {
+		return d.timezoneHandler(c, args[1:])
+	}
+
+	extend := len(args) > 0 && strings.EqualFold(args[0], "extend")
+	if extend {
+		args = args[1:]
+	}
+
+	var lockdown, revokeLinks bool
+	for !extend && len(args) > 0 {
+		if strings.EqualFold(args[0], "lockdown") {
+			lockdown = true
+		} else if strings.EqualFold(args[0], "revokelinks") {
+			revokeLinks = true
+		} else {
+			break
+		}
+
+		args = args[1:]
+	}
+
+	duration := DefaultDuration
+	switch {
+	case len(args) == 1:
+		var err error
+		duration, err = parseDuration(args[0])
 		if err != nil {
-			d.Logger.HandleBotError(err, d.Bot, c.Message())
+			d.reply(c, d.usage())
 			return nil
 		}
-
+	case len(args) > 1, extend && len(args) == 0:
+		d.reply(c, d.usage())
 		return nil
 	}
 
-	// Sender must be an admin here.
 	ctx, cancel := context.WithTimeout(context.Background(), time.Minute*1)
 	defer cancel()
 
 	// Check if we are on the under attack mode right now.
-	underAttackModeEnabled, err := d.AreWe(ctx, c.Chat().ID)
+	entry, underAttackModeEnabled, err := d.state(ctx, c.Chat().ID)
 	if err != nil {
 		d.Logger.HandleBotError(err, d.Bot, c.Message())
 		return nil
 	}
 
-	if underAttackModeEnabled {
-		_, err := c.Bot().Send(
-			c.Chat(),
-			d.Locale[locale.MessageUnderAttackAlreadyEnabled],
-			&tb.SendOptions{
-				ReplyTo:           c.Message(),
-				AllowWithoutReply: true,
-			},
-		)
+	if extend {
+		if !underAttackModeEnabled {
+			d.reply(c, d.Locale[locale.MessageUnderAttackNotEnabled])
+			return nil
+		}
+
+		expiresAt, err := d.extend(ctx, c.Chat(), duration)
 		if err != nil {
 			d.Logger.HandleBotError(err, d.Bot, c.Message())
 			return nil
 		}
 
+		d.reply(c, strings.Replace(d.Locale[locale.MessageUnderAttackExtended], "{{expiresAt}}", d.formatTime(expiresAt, entry.Timezone), 1))
+
+		d.Logger.Info(
+			"under attack mode extended",
+			logger.ChatID(c.Chat().ID),
+			logger.UserID(c.Sender().ID),
+			logger.F("expires_at", expiresAt),
+		)
 		return nil
 	}
 
-	expiresAt := time.Now().Add(time.Minute * 30)
+	if underAttackModeEnabled {
+		if lockdown || revokeLinks {
+			// Applying the options on the group that is already on under attack mode.
+			if lockdown {
+				d.lockdownHandler(ctx, c)
+			}
 
-	notificationMessage, err := c.Bot().Send(
-		c.Chat(),
-		strings.Replace(
-			d.Locale[locale.MessageUnderAttackStarting],
-			"{{expiresAt}}",
-			expiresAt.In(time.UTC).Format("15:04 MST"),
-			1,
-		),
+			if revokeLinks {
+				d.revokeInviteLinksHandler(ctx, c)
+			}
+
+			return nil
+		}
+
+		d.reply(c, d.Locale[locale.MessageUnderAttackAlreadyEnabled])
+		return nil
+	}
+
+	expiresAt := time.Now().Add(duration)
+
+	_, err = d.enable(ctx, c.Chat(), expiresAt, "", c.Sender().ID)
+	if err != nil {
+		d.Logger.HandleBotError(err, d.Bot, c.Message())
+		return nil
+	}
+
+	if lockdown {
+		d.lockdownHandler(ctx, c)
+	}
+
+	if revokeLinks {
+		d.revokeInviteLinksHandler(ctx, c)
+	}
+
+	d.notifySubscribers(ctx, c.Chat(), strings.NewReplacer(
+		"{{user}}", d.memberName(c.Chat(), c.Sender().ID),
+		"{{expiresAt}}", d.formatTime(expiresAt, entry.Timezone),
+	).Replace(d.Locale[locale.MessageUnderAttackNotifyEnabled]))
+
+	d.Logger.Info(
+		"under attack mode enabled",
+		logger.ChatID(c.Chat().ID),
+		logger.UserID(c.Sender().ID),
+		logger.F("expires_at", expiresAt),
+	)
+
+	return nil
+}
+
+// enable turns on the under attack mode on the given chat until expiresAt,
+// then sends and pins the notification message. The prefix, if any, is
+// prepended to the notification message. The enabledBy is the ID of the
+// admin that turned it on, or zero if it's turned on automatically.
+func (d *Dependency) enable(ctx context.Context, chat *tb.Chat, expiresAt time.Time, prefix string, enabledBy int64) (*tb.Message, error) {
+	entry, err := d.Datastore.GetUnderAttackEntry(ctx, chat.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	// The action that is in force is recorded along with the status,
+	// so it won't change halfway if the default action is changed.
+	action := d.action(entry)
+
+	notificationMessage, err := d.Bot.Send(
+		chat,
+		prefix+d.startingMessage(expiresAt, action, entry.Timezone),
 		&tb.SendOptions{
 			ParseMode: tb.ModeDefault,
 		},
 	)
 	if err != nil {
-		d.Logger.HandleBotError(err, d.Bot, c.Message())
-		return nil
+		return nil, err
 	}
 
-	err = d.Datastore.SetUnderAttackStatus(ctx, c.Chat().ID, true, time.Now().Add(time.Minute*30), int64(notificationMessage.ID))
+	err = d.Datastore.SetUnderAttackStatus(ctx, chat.ID, true, expiresAt, int64(notificationMessage.ID), action)
 	if err != nil {
-		d.Logger.HandleBotError(err, d.Bot, c.Message())
-		return nil
+		return nil, err
 	}
 
-	err = d.Memory.Delete("underattack:" + strconv.FormatInt(c.Chat().ID, 10))
+	err = d.Datastore.SetUnderAttackEnabledBy(ctx, chat.ID, enabledBy)
 	if err != nil {
-		d.Logger.HandleBotError(err, d.Bot, c.Message())
-		return nil
+		return nil, err
 	}
 
-	err = c.Bot().Pin(notificationMessage)
+	err = d.invalidate(ctx, chat.ID)
 	if err != nil {
-		d.Logger.HandleBotError(err, d.Bot, c.Message())
-		return nil
+		return nil, err
 	}
 
-	return nil
+	err = d.Bot.Pin(notificationMessage)
+	if err != nil {
+		return nil, err
+	}
+
+	return notificationMessage, nil
 }
 
 // DisableUnderAttackModeHandler provides a handler for /disableunderattack command.
@@ -115,67 +208,254 @@ func (d *Dependency) DisableUnderAttackModeHandler(c tb.Context) error {
 		return nil
 	}
 
-	admins, err := c.Bot().AdminsOf(c.Chat())
+	if !d.requireAdmin(c) {
+		return nil
+	}
+
+	// Sender must be an admin here.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute*1)
+	defer cancel()
+
+	// Check if we are on the under attack mode right now.
+	underAttackModeEnabled, err := d.AreWe(ctx, c.Chat().ID)
 	if err != nil {
 		d.Logger.HandleBotError(err, d.Bot, c.Message())
 		return nil
 	}
 
-	if !utils.IsAdmin(admins, c.Sender()) {
-		_, err := c.Bot().Send(
-			c.Chat(),
-			d.Locale[locale.MessageUnderAttackOnlyAdmin],
-			&tb.SendOptions{
-				ReplyTo:           c.Message(),
-				AllowWithoutReply: true,
-			},
-		)
+	if !underAttackModeEnabled {
+		return nil
+	}
+
+	// A window of a schedule that the scheduler hasn't run yet would
+	// turn it back on, so it's marked as run.
+	schedule, _, pending, err := d.pendingSchedule(ctx, c.Chat().ID)
+	if err != nil {
+		d.Logger.HandleBotError(err, d.Bot, c.Message())
+		return nil
+	}
+
+	if pending {
+		err = d.Datastore.SetScheduleLastRunAt(ctx, schedule.ID, time.Now())
 		if err != nil {
 			d.Logger.HandleBotError(err, d.Bot, c.Message())
 			return nil
 		}
+	}
 
+	underAttackEntry, err := d.Datastore.GetUnderAttackEntry(ctx, c.Chat().ID)
+	if err != nil {
+		d.Logger.HandleBotError(err, d.Bot, c.Message())
 		return nil
 	}
+	// The entry might not exist yet, if it's only on by the schedule.
+	underAttackEntry.GroupID = c.Chat().ID
 
-	// Sender must be an admin here.
-	ctx, cancel := context.WithTimeout(context.Background(), time.Minute*1)
-	defer cancel()
-
-	// Check if we are on the under attack mode right now.
-	underAttackModeEnabled, err := d.AreWe(ctx, c.Chat().ID)
+	err = d.end(ctx, underAttackEntry)
 	if err != nil {
 		d.Logger.HandleBotError(err, d.Bot, c.Message())
 		return nil
 	}
 
-	if !underAttackModeEnabled {
-		return nil
+	d.Logger.Info("under attack mode disabled", logger.ChatID(c.Chat().ID), logger.UserID(c.Sender().ID))
+
+	return nil
+}
+
+// extend pushes the expiry of the currently active under attack mode by
+// the given duration, and edits the pinned notification to show the new
+// expiry. It returns the new expiry.
+func (d *Dependency) extend(ctx context.Context, chat *tb.Chat, duration time.Duration) (time.Time, error) {
+	entry, err := d.Datastore.GetUnderAttackEntry(ctx, chat.ID)
+	if err != nil {
+		return time.Time{}, err
 	}
 
-	underAttackEntry, err := d.Datastore.GetUnderAttackEntry(ctx, c.Chat().ID)
+	if !entry.active() {
+		// It's only on because a window of a schedule is running,
+		// which the scheduler hasn't run yet. Run it now, until the
+		// end of the window pushed by the duration.
+		schedule, end, pending, err := d.pendingSchedule(ctx, chat.ID)
+		if err != nil {
+			return time.Time{}, err
+		}
+
+		if !pending {
+			return time.Time{}, fmt.Errorf("under attack mode is not enabled on %d", chat.ID)
+		}
+
+		expiresAt := limitExpiry(end.Add(duration))
+
+		err = d.runSchedule(ctx, schedule, expiresAt)
+		if err != nil {
+			return time.Time{}, err
+		}
+
+		return expiresAt, nil
+	}
+
+	expiresAt := limitExpiry(entry.ExpiresAt.Add(duration))
+
+	err = d.Datastore.SetUnderAttackStatus(ctx, chat.ID, true, expiresAt, entry.NotificationMessageID, entry.ActiveAction)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	err = d.invalidate(ctx, chat.ID)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if entry.NotificationMessageID != 0 {
+		_, err = d.Bot.Edit(
+			&tb.StoredMessage{ChatID: chat.ID, MessageID: strconv.FormatInt(entry.NotificationMessageID, 10)},
+			d.startingMessage(expiresAt, d.activeAction(entry), entry.Timezone),
+		)
+		if err != nil && !strings.Contains(err.Error(), "message is not modified") {
+			return time.Time{}, err
+		}
+	}
+
+	return expiresAt, nil
+}
+
+// limitExpiry caps the expiry to MaxDuration from now.
+func limitExpiry(expiresAt time.Time) time.Time {
+	if limit := time.Now().Add(MaxDuration); expiresAt.After(limit) {
+		return limit
+	}
+
+	return expiresAt
+}
+
+// lockdownHandler locks down the group and tells the group about it.
+func (d *Dependency) lockdownHandler(ctx context.Context, c tb.Context) {
+	err := d.lockdown(ctx, c.Chat())
 	if err != nil {
 		d.Logger.HandleBotError(err, d.Bot, c.Message())
+		return
+	}
+
+	d.reply(c, d.Locale[locale.MessageUnderAttackLockdown])
+}
+
+// actionHandler handles "/underattack action [action]". Without the
+// argument, it replies with the current action of the group.
+func (d *Dependency) actionHandler(c tb.Context, args []string) error {
+	if len(args) > 1 {
+		d.reply(c, d.usage())
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute*1)
+	defer cancel()
+
+	if len(args) == 0 {
+		entry, err := d.entry(ctx, c.Chat().ID)
+		if err != nil {
+			d.Logger.HandleBotError(err, d.Bot, c.Message())
+			return nil
+		}
+
+		d.reply(c, d.actionMessage(d.action(entry)))
 		return nil
 	}
 
-	err = d.Datastore.SetUnderAttackStatus(ctx, c.Chat().ID, false, time.Now(), 0)
+	// "default" goes back to the default action.
+	var action Action
+	if !strings.EqualFold(args[0], "default") {
+		var err error
+		action, err = ParseAction(args[0])
+		if err != nil {
+			d.reply(c, d.usage())
+			return nil
+		}
+	}
+
+	err := d.Datastore.SetUnderAttackAction(ctx, c.Chat().ID, action)
 	if err != nil {
 		d.Logger.HandleBotError(err, d.Bot, c.Message())
 		return nil
 	}
 
-	err = d.Memory.Delete("underattack:" + strconv.FormatInt(c.Chat().ID, 10))
+	entry, err := d.Datastore.GetUnderAttackEntry(ctx, c.Chat().ID)
 	if err != nil {
 		d.Logger.HandleBotError(err, d.Bot, c.Message())
 		return nil
 	}
 
-	err = c.Bot().Unpin(c.Chat(), int(underAttackEntry.NotificationMessageID))
+	// The action that an admin chooses during the under
+	// attack mode is in force right away.
+	if entry.IsUnderAttack {
+		err = d.Datastore.SetUnderAttackStatus(ctx, c.Chat().ID, true, entry.ExpiresAt, entry.NotificationMessageID, d.action(entry))
+		if err != nil {
+			d.Logger.HandleBotError(err, d.Bot, c.Message())
+			return nil
+		}
+	}
+
+	err = d.invalidate(ctx, c.Chat().ID)
 	if err != nil {
 		d.Logger.HandleBotError(err, d.Bot, c.Message())
 		return nil
 	}
 
+	d.reply(c, d.actionMessage(d.action(entry)))
+
+	d.Logger.Info(
+		"under attack action changed",
+		logger.ChatID(c.Chat().ID),
+		logger.UserID(c.Sender().ID),
+		logger.F("action", string(action)),
+	)
+
 	return nil
 }
+
+func (d *Dependency) startingMessage(expiresAt time.Time, action Action, timezone string) string {
+	return strings.NewReplacer(
+		"{{expiresAt}}", d.formatTime(expiresAt, timezone),
+		"{{action}}", d.describe(action),
+	).Replace(d.Locale[locale.MessageUnderAttackStarting])
+}
+
+func (d *Dependency) actionMessage(action Action) string {
+	return strings.Replace(d.Locale[locale.MessageUnderAttackAction], "{{action}}", d.describe(action), 1)
+}
+
+func (d *Dependency) usage() string {
+	return strings.NewReplacer(
+		"{{min}}", MinDuration.String(),
+		"{{max}}", MaxDuration.String(),
+	).Replace(d.Locale[locale.MessageUnderAttackUsage])
+}
+
+// reply replies to the command message.
+func (d *Dependency) reply(c tb.Context, text string) {
+	_, err := d.Bot.Send(
+		c.Chat(),
+		text,
+		&tb.SendOptions{
+			ReplyTo:           c.Message(),
+			AllowWithoutReply: true,
+		},
+	)
+	if err != nil {
+		d.Logger.HandleBotError(err, d.Bot, c.Message())
+	}
+}
+
+// parseDuration parses the duration argument of the command,
+// and makes sure it's within MinDuration and MaxDuration.
+func parseDuration(s string) (time.Duration, error) {
+	duration, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, err
+	}
+
+	if duration < MinDuration || duration > MaxDuration {
+		return 0, fmt.Errorf("duration must be between %s and %s", MinDuration, MaxDuration)
+	}
+
+	return duration, nil
+}
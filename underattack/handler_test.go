@@ -0,0 +1,538 @@
+package underattack_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"captcha-lite/cache"
+	"captcha-lite/locale"
+	"captcha-lite/logger"
+	"captcha-lite/telegram"
+	"captcha-lite/telegram/telegramtest"
+	"captcha-lite/underattack"
+	"captcha-lite/underattack/datastore/memory"
+
+	"github.com/allegro/bigcache/v3"
+	tb "gopkg.in/telebot.v3"
+)
+
+const (
+	chatID  int64 = -100123
+	adminID int64 = 7
+)
+
+// recorder is a logger.Logger that keeps the errors, as the handlers
+// report them instead of returning them.
+type recorder struct {
+	mu     sync.Mutex
+	errors []error
+}
+
+func (r *recorder) HandleError(e error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.errors = append(r.errors, e)
+}
+
+func (r *recorder) HandleBotError(e error, bot telegram.Client, m *tb.Message) { r.HandleError(e) }
+
+func (r *recorder) Debug(msg string, fields ...logger.Field) {}
+
+func (r *recorder) Info(msg string, fields ...logger.Field) {}
+
+func (r *recorder) Warn(msg string, fields ...logger.Field) {}
+
+func (r *recorder) Error(msg string, fields ...logger.Field) {}
+
+func (r *recorder) Errors() []error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]error(nil), r.errors...)
+}
+
+type harness struct {
+	d   *underattack.Dependency
+	bot *telegramtest.Fake
+	log *recorder
+	// contexts are created by an offline bot, the handlers
+	// only call the fake one.
+	offline *tb.Bot
+}
+
+func newHarness(t *testing.T) *harness {
+	t.Helper()
+
+	db, err := bigcache.New(context.Background(), bigcache.DefaultConfig(time.Hour))
+	if err != nil {
+		t.Fatalf("creating bigcache: %s", err.Error())
+	}
+	t.Cleanup(func() { _ = db.Close() })
+
+	log := &recorder{}
+	datastore, err := memory.NewInMemoryDatastore(db, log)
+	if err != nil {
+		t.Fatalf("creating datastore: %s", err.Error())
+	}
+
+	offline, err := tb.NewBot(tb.Settings{Offline: true})
+	if err != nil {
+		t.Fatalf("creating offline bot: %s", err.Error())
+	}
+
+	bot := telegramtest.NewFake()
+	bot.Admins[chatID] = []tb.ChatMember{{User: &tb.User{ID: adminID}, Role: tb.Administrator}}
+
+	return &harness{
+		d: &underattack.Dependency{
+			Datastore: datastore,
+			Memory:    cache.NewMap(),
+			Bot:       bot,
+			Logger:    log,
+			Locale:    locale.EN,
+		},
+		bot:     bot,
+		log:     log,
+		offline: offline,
+	}
+}
+
+// command returns the context of the command sent by the admin.
+func (h *harness) command(text string, payload string) tb.Context {
+	return h.offline.NewContext(tb.Update{Message: &tb.Message{
+		ID:      1000,
+		Chat:    &tb.Chat{ID: chatID, Type: tb.ChatSuperGroup},
+		Sender:  &tb.User{ID: adminID, FirstName: "Admin"},
+		Text:    text,
+		Payload: payload,
+	}})
+}
+
+func (h *harness) entry(t *testing.T) underattack.UnderAttack {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	entry, err := h.d.Datastore.GetUnderAttackEntry(ctx, chatID)
+	if err != nil {
+		t.Fatalf("getting entry: %s", err.Error())
+	}
+
+	return entry
+}
+
+func TestActiveAction(t *testing.T) {
+	h := newHarness(t)
+	h.d.DefaultAction = underattack.ActionKick
+
+	err := h.d.EnableUnderAttackModeHandler(h.command("/underattack", ""))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	entry := h.entry(t)
+	if !entry.IsUnderAttack || entry.ActiveAction != underattack.ActionKick || entry.Action != "" {
+		t.Fatalf("expecting the default action to be in force without being chosen, got %+v", entry)
+	}
+
+	err = h.d.DisableUnderAttackModeHandler(h.command("/disableunderattack", ""))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	entry = h.entry(t)
+	if entry.IsUnderAttack || entry.ActiveAction != "" {
+		t.Fatalf("expecting the action in force to be cleared, got %+v", entry)
+	}
+
+	// The new default applies to the next attack.
+	h.d.DefaultAction = underattack.ActionRestrict
+
+	err = h.d.EnableUnderAttackModeHandler(h.command("/underattack", ""))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	handled, err := h.d.ActOnJoin(ctx, &tb.Chat{ID: chatID}, &tb.User{ID: 100})
+	if err != nil || !handled {
+		t.Fatalf("expecting the join to be handled, got %t and %v", handled, err)
+	}
+
+	if restricts := h.bot.CallsOf("Restrict"); len(restricts) != 1 || restricts[0].UserID != 100 {
+		t.Errorf("expecting the user to be restricted by the new default, got %v", h.bot.Calls())
+	}
+
+	if bans := h.bot.CallsOf("Ban"); len(bans) != 0 {
+		t.Errorf("expecting the user not to be kicked by the old default, got %v", bans)
+	}
+
+	if errs := h.log.Errors(); len(errs) != 0 {
+		t.Errorf("expecting no errors, got %v", errs)
+	}
+}
+
+func TestAttackUnbanHandler(t *testing.T) {
+	h := newHarness(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	err := h.d.Datastore.CreateBan(ctx, underattack.Ban{GroupID: chatID, UserID: 100, AttackID: 1, BannedAt: time.Now()})
+	if err != nil {
+		t.Fatalf("creating ban: %s", err.Error())
+	}
+
+	tests := []struct {
+		name     string
+		userID   string
+		unbanned bool
+		reply    string
+	}{
+		{
+			name:     "Banned during the under attack mode",
+			userID:   "100",
+			unbanned: true,
+			reply:    strings.Replace(locale.EN[locale.MessageAttackUnbanned], "{{count}}", "1", 1),
+		},
+		{
+			name:   "Banned by an admin",
+			userID: "200",
+			reply:  locale.EN[locale.MessageAttackUnbanNotFound],
+		},
+		{
+			name:   "Already unbanned",
+			userID: "100",
+			reply:  locale.EN[locale.MessageAttackUnbanNotFound],
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			before := len(h.bot.Calls())
+
+			err := h.d.AttackUnbanHandler(h.command("/attackunban "+test.userID, test.userID))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			var unbans, replies []telegramtest.Call
+			for _, call := range h.bot.Calls()[before:] {
+				switch call.Method {
+				case "Unban":
+					unbans = append(unbans, call)
+				case "Send":
+					replies = append(replies, call)
+				}
+			}
+
+			if test.unbanned != (len(unbans) == 1) {
+				t.Errorf("expecting unbanned to be %t, got %v", test.unbanned, unbans)
+			}
+
+			if len(replies) != 1 || replies[0].What != test.reply {
+				t.Errorf("expecting the reply %q, got %v", test.reply, replies)
+			}
+		})
+	}
+
+	if errs := h.log.Errors(); len(errs) != 0 {
+		t.Errorf("expecting no errors, got %v", errs)
+	}
+}
+
+func TestScheduleAddedDuringItsWindow(t *testing.T) {
+	h := newHarness(t)
+
+	now := time.Now().UTC()
+	window := now.Add(-time.Hour).Format("15:04") + "-" + now.Add(time.Hour).Format("15:04")
+
+	err := h.d.EnableUnderAttackModeHandler(h.command("/underattack schedule add "+window+" UTC", "schedule add "+window+" UTC"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	entry := h.entry(t)
+	if !entry.IsUnderAttack {
+		t.Fatalf("expecting the under attack mode to be turned on right away, got %+v", entry)
+	}
+
+	if remaining := time.Until(entry.ExpiresAt); remaining <= 0 || remaining > time.Hour {
+		t.Errorf("expecting the under attack mode to end with the window, got %s", remaining)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	schedules, err := h.d.Datastore.GetSchedules(ctx, chatID)
+	if err != nil {
+		t.Fatalf("getting schedules: %s", err.Error())
+	}
+
+	if len(schedules) != 1 || schedules[0].LastRunAt.IsZero() {
+		t.Errorf("expecting the schedule to be marked as run, got %+v", schedules)
+	}
+
+	if errs := h.log.Errors(); len(errs) != 0 {
+		t.Errorf("expecting no errors, got %v", errs)
+	}
+}
+
+func TestEnableJoinBan(t *testing.T) {
+	h := newHarness(t)
+	h.d.DefaultAction = underattack.ActionBan
+
+	err := h.d.EnableUnderAttackModeHandler(h.command("/underattack", ""))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	entry := h.entry(t)
+	if !entry.IsUnderAttack || entry.NotificationMessageID == 0 {
+		t.Fatalf("expecting the under attack mode to be on with a notification, got %+v", entry)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	user := &tb.User{ID: 100, FirstName: "Spam", LastName: "Bot", Username: "spambot"}
+	handled, err := h.d.ActOnJoin(ctx, &tb.Chat{ID: chatID}, user)
+	if err != nil || !handled {
+		t.Fatalf("expecting the join to be handled, got %t and %v", handled, err)
+	}
+
+	bans := h.bot.CallsOf("Ban")
+	if len(bans) != 1 || bans[0].ChatID != chatID || bans[0].UserID != user.ID {
+		t.Fatalf("expecting the user to be banned, got %v", h.bot.Calls())
+	}
+
+	if until := bans[0].Member.RestrictedUntil; until != tb.Forever() {
+		t.Errorf("expecting the user to be banned forever, got %d", until)
+	}
+
+	ban, ok, err := h.d.Datastore.GetBan(ctx, chatID, user.ID)
+	if err != nil || !ok {
+		t.Fatalf("expecting the ban to be recorded, got %t and %v", ok, err)
+	}
+
+	if ban.AttackID != entry.NotificationMessageID || ban.Name != "Spam Bot" || ban.Username != "spambot" {
+		t.Errorf("unexpected ban record: %+v", ban)
+	}
+
+	err = h.d.DisableUnderAttackModeHandler(h.command("/disableunderattack", ""))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	handled, err = h.d.ActOnJoin(ctx, &tb.Chat{ID: chatID}, &tb.User{ID: 101})
+	if err != nil || handled {
+		t.Errorf("expecting the join not to be handled once it's off, got %t and %v", handled, err)
+	}
+
+	if bans := h.bot.CallsOf("Ban"); len(bans) != 1 {
+		t.Errorf("expecting no other ban, got %v", bans)
+	}
+
+	if errs := h.log.Errors(); len(errs) != 0 {
+		t.Errorf("expecting no errors, got %v", errs)
+	}
+}
+
+func TestRecordJoin_EnableFails(t *testing.T) {
+	h := newHarness(t)
+	h.d.Detector = underattack.NewJoinRateDetector(2, time.Minute, time.Minute*30)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	chat := &tb.Chat{ID: chatID, Type: tb.ChatSuperGroup}
+
+	activated, err := h.d.RecordJoin(ctx, chat)
+	if err != nil || activated {
+		t.Fatalf("expecting the first join not to activate, got %t and %v", activated, err)
+	}
+
+	// Telegram is rate limiting the bot in the middle of the raid.
+	h.bot.Errors["Send"] = errors.New("telegram: retry after 5 (429)")
+
+	activated, err = h.d.RecordJoin(ctx, chat)
+	if err == nil || activated {
+		t.Fatalf("expecting the activation to fail, got %t and %v", activated, err)
+	}
+
+	delete(h.bot.Errors, "Send")
+
+	// The next join of the raid tries again, rather than
+	// waiting for the cooldown.
+	activated, err = h.d.RecordJoin(ctx, chat)
+	if err != nil || !activated {
+		t.Fatalf("expecting the next join to activate, got %t and %v", activated, err)
+	}
+
+	if entry := h.entry(t); !entry.IsUnderAttack {
+		t.Errorf("expecting the under attack mode to be on, got %+v", entry)
+	}
+
+	// Only then the cooldown starts.
+	activated, err = h.d.RecordJoin(ctx, chat)
+	if err != nil || activated {
+		t.Errorf("expecting no other activation, got %t and %v", activated, err)
+	}
+}
+
+// runningSchedule creates a schedule whose window runs from an hour ago
+// to an hour from now, which the scheduler hasn't run yet.
+func (h *harness) runningSchedule(t *testing.T) (underattack.Schedule, time.Time) {
+	t.Helper()
+
+	now := time.Now().UTC()
+	schedule, err := underattack.ParseSchedule(now.Add(-time.Hour).Format("15:04")+"-"+now.Add(time.Hour).Format("15:04"), "UTC")
+	if err != nil {
+		t.Fatalf("parsing schedule: %s", err.Error())
+	}
+	schedule.GroupID = chatID
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	schedule.ID, err = h.d.Datastore.CreateSchedule(ctx, schedule)
+	if err != nil {
+		t.Fatalf("creating schedule: %s", err.Error())
+	}
+
+	_, end, ok := schedule.Window(time.Now())
+	if !ok {
+		t.Fatal("expecting the window to be running")
+	}
+
+	return schedule, end
+}
+
+func TestExtendDuringPendingSchedule(t *testing.T) {
+	h := newHarness(t)
+	_, end := h.runningSchedule(t)
+
+	err := h.d.EnableUnderAttackModeHandler(h.command("/underattack extend 1h", "extend 1h"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	entry := h.entry(t)
+	if !entry.IsUnderAttack || !entry.ExpiresAt.Equal(end.Add(time.Hour)) {
+		t.Errorf("expecting the mode to be on until an hour after the window, got %+v", entry)
+	}
+
+	if entry.NotificationMessageID == 0 || len(h.bot.CallsOf("Pin")) != 1 {
+		t.Errorf("expecting the notification to be pinned, got %v", h.bot.Calls())
+	}
+
+	if errs := h.log.Errors(); len(errs) != 0 {
+		t.Errorf("expecting no errors, got %v", errs)
+	}
+}
+
+func TestDisableDuringPendingSchedule(t *testing.T) {
+	h := newHarness(t)
+	schedule, _ := h.runningSchedule(t)
+
+	err := h.d.DisableUnderAttackModeHandler(h.command("/disableunderattack", ""))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	schedules, err := h.d.Datastore.GetSchedules(ctx, chatID)
+	if err != nil {
+		t.Fatalf("getting schedules: %s", err.Error())
+	}
+
+	if len(schedules) != 1 || schedules[0].ID != schedule.ID || schedules[0].LastRunAt.IsZero() {
+		t.Errorf("expecting the schedule to be marked as run, got %+v", schedules)
+	}
+
+	underAttack, err := h.d.AreWe(ctx, chatID)
+	if err != nil || underAttack {
+		t.Errorf("expecting the under attack mode to stay off, got %t and %v", underAttack, err)
+	}
+
+	if errs := h.log.Errors(); len(errs) != 0 {
+		t.Errorf("expecting no errors, got %v", errs)
+	}
+}
+
+func TestHandlers_OnlyAdmin(t *testing.T) {
+	h := newHarness(t)
+
+	for _, handler := range []func(c tb.Context) error{
+		h.d.EnableUnderAttackModeHandler,
+		h.d.DisableUnderAttackModeHandler,
+	} {
+		before := len(h.bot.CallsOf("Send"))
+
+		err := handler(h.offline.NewContext(tb.Update{Message: &tb.Message{
+			ID:     1000,
+			Chat:   &tb.Chat{ID: chatID, Type: tb.ChatSuperGroup},
+			Sender: &tb.User{ID: 100, FirstName: "Member"},
+			Text:   "/underattack",
+		}}))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		replies := h.bot.CallsOf("Send")[before:]
+		if len(replies) != 1 || replies[0].What != locale.EN[locale.MessageUnderAttackOnlyAdmin] {
+			t.Errorf("expecting only the admin reply, got %v", replies)
+		}
+	}
+
+	if entry := h.entry(t); entry.IsUnderAttack {
+		t.Errorf("expecting the under attack mode to stay off, got %+v", entry)
+	}
+}
+
+func TestLockdown_SetGroupPermissionsFails(t *testing.T) {
+	h := newHarness(t)
+	h.bot.Errors["SetGroupPermissions"] = errors.New("telegram: not enough rights to change chat permissions (400)")
+
+	err := h.d.EnableUnderAttackModeHandler(h.command("/underattack lockdown", "lockdown"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if errs := h.log.Errors(); len(errs) != 1 {
+		t.Errorf("expecting the failure to be reported, got %v", errs)
+	}
+
+	if entry := h.entry(t); entry.LockdownPermissions != "" {
+		t.Fatalf("expecting the snapshot to be cleared, got %q", entry.LockdownPermissions)
+	}
+
+	delete(h.bot.Errors, "SetGroupPermissions")
+	before := len(h.bot.CallsOf("Send"))
+
+	err = h.d.EnableUnderAttackModeHandler(h.command("/underattack lockdown", "lockdown"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if calls := h.bot.CallsOf("SetGroupPermissions"); len(calls) != 2 {
+		t.Errorf("expecting the lockdown to be tried again, got %v", calls)
+	}
+
+	replies := h.bot.CallsOf("Send")[before:]
+	if len(replies) != 1 || replies[0].What != locale.EN[locale.MessageUnderAttackLockdown] {
+		t.Errorf("expecting the lockdown reply, got %v", replies)
+	}
+
+	if entry := h.entry(t); entry.LockdownPermissions == "" {
+		t.Error("expecting the snapshot to be kept once locked down")
+	}
+}
@@ -0,0 +1,103 @@
+package underattack
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"captcha-lite/logger"
+)
+
+// DefaultCacheInvalidationInterval is the default interval between two
+// polls of the groups that were invalidated by the other instances.
+const DefaultCacheInvalidationInterval = time.Second * 5
+
+// invalidationOverlap is how far before the previous poll each poll looks
+// back, to cover the clock skew between the instances and the writes that
+// were committed late. Seeing an invalidation twice only costs a cache miss.
+const invalidationOverlap = time.Second * 10
+
+// invalidate drops the cached state of the group, right away on this
+// instance, and on the other instances on their next poll.
+func (d *Dependency) invalidate(ctx context.Context, groupID int64) error {
+	err := d.forget(groupID)
+	if err != nil {
+		return err
+	}
+
+	return d.Datastore.InvalidateGroup(ctx, groupID)
+}
+
+// forget drops the cached state of the group on this instance.
+func (d *Dependency) forget(groupID int64) error {
+	for _, key := range []string{
+		"underattack:" + strconv.FormatInt(groupID, 10),
+		"underattack:schedules:" + strconv.FormatInt(groupID, 10),
+	} {
+		err := d.Memory.Delete(key)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// RunCacheInvalidator drops the cached state of the groups that were
+// invalidated by the other instances that share the datastore, once every
+// interval until ctx is done. Without it, the other instances would only
+// see the changes once their cache expires.
+//
+// It blocks, so run it on its own goroutine.
+func (d *Dependency) RunCacheInvalidator(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultCacheInvalidationInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	// Whatever was cached before this is cached by this instance,
+	// after the invalidations before this.
+	since := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		polledAt := time.Now()
+		err := d.forgetInvalidated(ctx, since.Add(-invalidationOverlap))
+		if err != nil {
+			d.Logger.HandleError(err)
+			// Try the same range again on the next poll.
+			continue
+		}
+
+		since = polledAt
+	}
+}
+
+// forgetInvalidated drops the cached state of the groups that
+// were invalidated since the given time.
+func (d *Dependency) forgetInvalidated(ctx context.Context, since time.Time) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*30)
+	defer cancel()
+
+	groupIDs, err := d.Datastore.GetInvalidatedGroups(ctx, since)
+	if err != nil {
+		return err
+	}
+
+	for _, groupID := range groupIDs {
+		err := d.forget(groupID)
+		if err != nil {
+			return err
+		}
+
+		d.Logger.Debug("dropped the cache of an invalidated group", logger.ChatID(groupID))
+	}
+
+	return nil
+}
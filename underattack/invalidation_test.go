@@ -0,0 +1,84 @@
+package underattack_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"captcha-lite/cache"
+	"captcha-lite/logger/noop"
+	"captcha-lite/underattack"
+	"captcha-lite/underattack/datastore/memory"
+
+	"github.com/allegro/bigcache/v3"
+)
+
+func TestRunCacheInvalidator(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	db, err := bigcache.New(ctx, bigcache.DefaultConfig(time.Hour))
+	if err != nil {
+		t.Fatalf("creating bigcache instance: %s", err.Error())
+	}
+
+	// Shared by every instance.
+	datastore, err := memory.NewInMemoryDatastore(db, noop.New())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer func() {
+		_ = datastore.Close()
+	}()
+
+	// The local cache of another instance.
+	local := cache.NewMap()
+
+	for _, key := range []string{"underattack:10", "underattack:schedules:10", "underattack:11"} {
+		err := local.Set(key, []byte("{}"))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	d := &underattack.Dependency{Datastore: datastore, Memory: local, Logger: noop.New()}
+
+	workerCtx, workerCancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		d.RunCacheInvalidator(workerCtx, time.Millisecond*50)
+	}()
+
+	err = datastore.InvalidateGroup(ctx, 10)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for {
+		_, err := local.Get("underattack:10")
+		if errors.Is(err, cache.ErrNotFound) {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			t.Fatal("expecting the invalidated group to be dropped from the cache")
+		case <-time.After(time.Millisecond * 10):
+		}
+	}
+
+	workerCancel()
+	<-done
+
+	_, err = local.Get("underattack:schedules:10")
+	if !errors.Is(err, cache.ErrNotFound) {
+		t.Errorf("expecting the schedules of the invalidated group to be dropped, got %v", err)
+	}
+
+	_, err = local.Get("underattack:11")
+	if err != nil {
+		t.Errorf("expecting the other group to be kept, got %v", err)
+	}
+}
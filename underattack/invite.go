@@ -0,0 +1,110 @@
+package underattack
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"captcha-lite/locale"
+	"captcha-lite/logger"
+
+	tb "gopkg.in/telebot.v3"
+)
+
+// inviteLinkName is the name of the invite link that is
+// created by the bot when the invite links are revoked.
+const inviteLinkName = "under attack"
+
+// errNoInviteRight is returned when the bot is not allowed
+// to manage the invite links of the chat.
+var errNoInviteRight = errors.New("the bot can't manage the invite links")
+
+// revokeInviteLinks revokes the invite link that the bot created on the
+// previous attack and the primary invite link of the chat, then creates
+// a fresh invite link and sends it privately to the admins.
+//
+// Telegram doesn't let us list the invite links, so the ones that are
+// created by the admins themselves can't be revoked.
+func (d *Dependency) revokeInviteLinks(ctx context.Context, chat *tb.Chat) error {
+	member, err := d.Bot.ChatMemberOf(chat, d.Bot.Me())
+	if err != nil {
+		return err
+	}
+
+	if member.Role != tb.Creator && !member.CanInviteUsers {
+		return errNoInviteRight
+	}
+
+	entry, err := d.Datastore.GetUnderAttackEntry(ctx, chat.ID)
+	if err != nil {
+		return err
+	}
+
+	if entry.InviteLink != "" {
+		_, err = d.Bot.RevokeInviteLink(chat, entry.InviteLink)
+		if err != nil {
+			// It might have been revoked by the admins already.
+			d.Logger.Debug("could not revoke the previous invite link", logger.ChatID(chat.ID), logger.F("error", err.Error()))
+		}
+	}
+
+	// Exporting generates a new primary invite link,
+	// and the previous one is revoked.
+	_, err = d.Bot.InviteLink(chat)
+	if err != nil {
+		return err
+	}
+
+	link, err := d.Bot.CreateInviteLink(chat, &tb.ChatInviteLink{Name: inviteLinkName})
+	if err != nil {
+		return err
+	}
+
+	err = d.Datastore.SetInviteLink(ctx, chat.ID, link.InviteLink)
+	if err != nil {
+		return err
+	}
+
+	d.Logger.Info("invite links revoked", logger.ChatID(chat.ID))
+
+	d.notifyAdmins(chat, strings.NewReplacer(
+		"{{group}}", chat.Title,
+		"{{link}}", link.InviteLink,
+	).Replace(d.Locale[locale.MessageUnderAttackInviteLink]))
+
+	return nil
+}
+
+// restoreInviteLink revokes the invite link that was created for the
+// attack, if the invite links should be restored when the attack ends.
+// The group goes back to its primary invite link.
+func (d *Dependency) restoreInviteLink(ctx context.Context, entry UnderAttack) error {
+	if !d.RestoreInviteLinks || entry.InviteLink == "" {
+		return nil
+	}
+
+	_, err := d.Bot.RevokeInviteLink(&tb.Chat{ID: entry.GroupID}, entry.InviteLink)
+	if err != nil {
+		// Don't hold the end of the attack for it. It might
+		// have been revoked by the admins already.
+		d.Logger.Debug("could not revoke the invite link of the attack", logger.ChatID(entry.GroupID), logger.F("error", err.Error()))
+	}
+
+	return d.Datastore.SetInviteLink(ctx, entry.GroupID, "")
+}
+
+// revokeInviteLinksHandler revokes the invite links and tells the group about it.
+func (d *Dependency) revokeInviteLinksHandler(ctx context.Context, c tb.Context) {
+	err := d.revokeInviteLinks(ctx, c.Chat())
+	if err != nil {
+		if errors.Is(err, errNoInviteRight) {
+			d.reply(c, d.Locale[locale.MessageUnderAttackInviteLinksNoRight])
+			return
+		}
+
+		d.Logger.HandleBotError(err, d.Bot, c.Message())
+		return
+	}
+
+	d.reply(c, d.Locale[locale.MessageUnderAttackInviteLinksRevoked])
+}
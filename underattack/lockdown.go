@@ -0,0 +1,102 @@
+package underattack
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"captcha-lite/logger"
+
+	tb "gopkg.in/telebot.v3"
+)
+
+// lockdown takes a snapshot of the default permissions of the chat, then
+// makes the chat read-only for everyone but the admins. The snapshot is
+// persisted before anything is changed, so the permissions can still be
+// restored after a restart.
+func (d *Dependency) lockdown(ctx context.Context, chat *tb.Chat) error {
+	entry, err := d.Datastore.GetUnderAttackEntry(ctx, chat.ID)
+	if err != nil {
+		return err
+	}
+
+	if entry.LockdownPermissions != "" {
+		// Already locked down. Taking another snapshot now would
+		// snapshot the read-only permissions.
+		return nil
+	}
+
+	fullChat, err := d.Bot.ChatByID(chat.ID)
+	if err != nil {
+		return err
+	}
+
+	permissions := tb.NoRestrictions()
+	if fullChat.Permissions != nil {
+		permissions = *fullChat.Permissions
+	}
+
+	snapshot, err := json.Marshal(permissions)
+	if err != nil {
+		return err
+	}
+
+	err = d.Datastore.SetLockdownPermissions(ctx, chat.ID, string(snapshot))
+	if err != nil {
+		return err
+	}
+
+	err = d.invalidate(ctx, chat.ID)
+	if err != nil {
+		return err
+	}
+
+	err = d.Bot.SetGroupPermissions(chat, tb.NoRights())
+	if err != nil {
+		// The chat was never locked down, the snapshot would make
+		// the next lockdown think it was.
+		return errors.Join(err, d.clearLockdown(ctx, chat.ID))
+	}
+
+	d.Logger.Info("group locked down", logger.ChatID(chat.ID))
+
+	return nil
+}
+
+// clearLockdown removes the snapshot of the permissions of the chat.
+func (d *Dependency) clearLockdown(ctx context.Context, chatID int64) error {
+	err := d.Datastore.SetLockdownPermissions(ctx, chatID, "")
+	if err != nil {
+		return err
+	}
+
+	return d.invalidate(ctx, chatID)
+}
+
+// restoreLockdown gives the chat back the default permissions from the
+// snapshot of the entry, if it was locked down.
+func (d *Dependency) restoreLockdown(ctx context.Context, entry UnderAttack) error {
+	if entry.LockdownPermissions == "" {
+		return nil
+	}
+
+	var permissions tb.Rights
+	err := json.Unmarshal([]byte(entry.LockdownPermissions), &permissions)
+	if err != nil {
+		return err
+	}
+
+	err = d.Bot.SetGroupPermissions(&tb.Chat{ID: entry.GroupID}, permissions)
+	if err != nil {
+		return err
+	}
+
+	err = d.Datastore.SetLockdownPermissions(ctx, entry.GroupID, "")
+	if err != nil {
+		return err
+	}
+
+	d.Logger.Info("group lockdown lifted", logger.ChatID(entry.GroupID))
+
+	return nil
+}
@@ -0,0 +1,406 @@
+package underattack
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"captcha-lite/cache"
+	"captcha-lite/locale"
+	"captcha-lite/logger"
+
+	tb "gopkg.in/telebot.v3"
+)
+
+// MaxSchedules is the maximum number of schedules on a group.
+const MaxSchedules = 10
+
+// Schedule is a recurring daily window in which the under attack
+// mode is turned on automatically.
+type Schedule struct {
+	ID      int64 `db:"id"`
+	GroupID int64 `db:"group_id"`
+	// StartMinute and EndMinute are the minutes since midnight on the
+	// Timezone. If EndMinute is before StartMinute, the window ends on
+	// the next day.
+	StartMinute int    `db:"start_minute"`
+	EndMinute   int    `db:"end_minute"`
+	Timezone    string `db:"timezone"`
+	CreatedBy   int64  `db:"created_by"`
+	// LastRunAt is when the scheduler last turned on the under attack
+	// mode for this schedule, so every window is only run once.
+	LastRunAt time.Time `db:"last_run_at"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+// ParseSchedule parses the window in "HH:MM-HH:MM" format and the IANA
+// timezone name into a Schedule.
+func ParseSchedule(window string, timezone string) (Schedule, error) {
+	startText, endText, ok := strings.Cut(window, "-")
+	if !ok {
+		return Schedule{}, fmt.Errorf("invalid window: %q", window)
+	}
+
+	start, err := parseClock(startText)
+	if err != nil {
+		return Schedule{}, err
+	}
+
+	end, err := parseClock(endText)
+	if err != nil {
+		return Schedule{}, err
+	}
+
+	if start == end {
+		return Schedule{}, fmt.Errorf("the window must not be empty: %q", window)
+	}
+
+	location, err := loadTimezone(timezone)
+	if err != nil {
+		return Schedule{}, err
+	}
+
+	return Schedule{StartMinute: start, EndMinute: end, Timezone: location.String()}, nil
+}
+
+// parseClock parses "HH:MM" into the minutes since midnight.
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, err
+	}
+
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// Window returns the window of the schedule that contains the given
+// time. The ok is false if the given time is outside the schedule.
+func (s Schedule) Window(now time.Time) (start time.Time, end time.Time, ok bool) {
+	location, err := time.LoadLocation(s.Timezone)
+	if err != nil {
+		return time.Time{}, time.Time{}, false
+	}
+
+	local := now.In(location)
+
+	// The window that contains now might have started yesterday.
+	for _, day := range []int{-1, 0} {
+		start = time.Date(local.Year(), local.Month(), local.Day()+day, s.StartMinute/60, s.StartMinute%60, 0, 0, location)
+		end = time.Date(local.Year(), local.Month(), local.Day()+day, s.EndMinute/60, s.EndMinute%60, 0, 0, location)
+		if !end.After(start) {
+			end = end.AddDate(0, 0, 1)
+		}
+
+		if !now.Before(start) && now.Before(end) {
+			return start, end, true
+		}
+	}
+
+	return time.Time{}, time.Time{}, false
+}
+
+// pending returns true if the given time is in a window of the
+// schedule, but the scheduler hasn't run it yet.
+func (s Schedule) pending(now time.Time) (time.Time, bool) {
+	start, end, ok := s.Window(now)
+	if !ok || !s.LastRunAt.Before(start) {
+		return time.Time{}, false
+	}
+
+	return end, true
+}
+
+func (s Schedule) String() string {
+	return fmt.Sprintf("%02d:%02d-%02d:%02d %s", s.StartMinute/60, s.StartMinute%60, s.EndMinute/60, s.EndMinute%60, s.Timezone)
+}
+
+// RunScheduler turns on the under attack mode when a window of the
+// schedules starts. The expiry worker turns it off when the window ends.
+// It checks the schedules right away, and then once every interval until
+// ctx is done.
+//
+// It blocks, so run it on its own goroutine.
+func (d *Dependency) RunScheduler(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultExpiryCheckInterval
+	}
+
+	d.runSchedules(ctx)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			d.runSchedules(ctx)
+		}
+	}
+}
+
+// runSchedules runs every schedule that has a window starting.
+func (d *Dependency) runSchedules(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute*1)
+	defer cancel()
+
+	schedules, err := d.Datastore.GetAllSchedules(ctx)
+	if err != nil {
+		d.Logger.HandleError(err)
+		return
+	}
+
+	now := time.Now()
+	for _, schedule := range schedules {
+		end, ok := schedule.pending(now)
+		if !ok {
+			continue
+		}
+
+		err := d.runSchedule(ctx, schedule, end)
+		if err != nil {
+			d.Logger.HandleError(err)
+		}
+	}
+}
+
+// pendingSchedule returns the schedule of the chat that has a window
+// running which the scheduler hasn't run yet, along with the end of the
+// window. The ok is false if there is none.
+func (d *Dependency) pendingSchedule(ctx context.Context, chatID int64) (Schedule, time.Time, bool, error) {
+	schedules, err := d.schedules(ctx, chatID)
+	if err != nil {
+		return Schedule{}, time.Time{}, false, err
+	}
+
+	now := time.Now()
+	for _, schedule := range schedules {
+		if end, ok := schedule.pending(now); ok {
+			return schedule, end, true, nil
+		}
+	}
+
+	return Schedule{}, time.Time{}, false, nil
+}
+
+// runSchedule turns on the under attack mode until the end of the window.
+// The schedule is marked as run first, so a failure won't make the group
+// receive the notification every minute, and turning the mode off during
+// the window keeps it off.
+func (d *Dependency) runSchedule(ctx context.Context, schedule Schedule, end time.Time) error {
+	err := d.Datastore.SetScheduleLastRunAt(ctx, schedule.ID, time.Now())
+	if err != nil {
+		return err
+	}
+
+	err = d.invalidate(ctx, schedule.GroupID)
+	if err != nil {
+		return err
+	}
+
+	entry, err := d.Datastore.GetUnderAttackEntry(ctx, schedule.GroupID)
+	if err != nil {
+		return err
+	}
+
+	if entry.active() {
+		// It's been turned on manually already.
+		return nil
+	}
+
+	_, err = d.enable(ctx, &tb.Chat{ID: schedule.GroupID}, end, d.Locale[locale.MessageUnderAttackScheduled], 0)
+	if err != nil {
+		return err
+	}
+
+	d.Logger.Info(
+		"under attack mode enabled by schedule",
+		logger.ChatID(schedule.GroupID),
+		logger.F("schedule_id", schedule.ID),
+		logger.F("expires_at", end),
+	)
+
+	return nil
+}
+
+// schedules acquires the schedules of the chat,
+// from the cache if it's there, or from the datastore.
+func (d *Dependency) schedules(ctx context.Context, chatID int64) ([]Schedule, error) {
+	key := "underattack:schedules:" + strconv.FormatInt(chatID, 10)
+
+	cached, err := d.Memory.Get(key)
+	if err != nil && !errors.Is(err, cache.ErrNotFound) {
+		return nil, err
+	}
+
+	if err == nil {
+		var schedules []Schedule
+		err := json.Unmarshal(cached, &schedules)
+		if err != nil {
+			return nil, err
+		}
+
+		return schedules, nil
+	}
+
+	schedules, err := d.Datastore.GetSchedules(ctx, chatID)
+	if err != nil {
+		return nil, err
+	}
+
+	marshaled, err := json.Marshal(schedules)
+	if err != nil {
+		return nil, err
+	}
+
+	err = d.Memory.SetWithTTL(key, marshaled, cacheTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	return schedules, nil
+}
+
+// scheduleHandler handles "/underattack schedule add|list|remove".
+func (d *Dependency) scheduleHandler(c tb.Context, args []string) error {
+	if len(args) == 0 {
+		d.reply(c, d.Locale[locale.MessageUnderAttackScheduleUsage])
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute*1)
+	defer cancel()
+
+	switch strings.ToLower(args[0]) {
+	case "add":
+		if len(args) < 2 || len(args) > 3 {
+			d.reply(c, d.Locale[locale.MessageUnderAttackScheduleUsage])
+			return nil
+		}
+
+		entry, err := d.entry(ctx, c.Chat().ID)
+		if err != nil {
+			d.Logger.HandleBotError(err, d.Bot, c.Message())
+			return nil
+		}
+
+		// The timezone defaults to the one the group is shown in.
+		timezone := d.location(entry.Timezone).String()
+		if len(args) == 3 {
+			timezone = args[2]
+		}
+
+		schedule, err := ParseSchedule(args[1], timezone)
+		if err != nil {
+			d.reply(c, d.Locale[locale.MessageUnderAttackScheduleUsage])
+			return nil
+		}
+
+		schedules, err := d.Datastore.GetSchedules(ctx, c.Chat().ID)
+		if err != nil {
+			d.Logger.HandleBotError(err, d.Bot, c.Message())
+			return nil
+		}
+
+		if len(schedules) >= MaxSchedules {
+			d.reply(c, strings.Replace(d.Locale[locale.MessageUnderAttackScheduleLimit], "{{max}}", strconv.Itoa(MaxSchedules), 1))
+			return nil
+		}
+
+		schedule.GroupID = c.Chat().ID
+		schedule.CreatedBy = c.Sender().ID
+		schedule.CreatedAt = time.Now()
+
+		schedule.ID, err = d.Datastore.CreateSchedule(ctx, schedule)
+		if err != nil {
+			d.Logger.HandleBotError(err, d.Bot, c.Message())
+			return nil
+		}
+
+		d.reply(c, strings.NewReplacer(
+			"{{id}}", strconv.FormatInt(schedule.ID, 10),
+			"{{schedule}}", schedule.String(),
+		).Replace(d.Locale[locale.MessageUnderAttackScheduleAdded]))
+
+		d.Logger.Info(
+			"under attack schedule added",
+			logger.ChatID(c.Chat().ID),
+			logger.UserID(c.Sender().ID),
+			logger.F("schedule", schedule.String()),
+		)
+
+		// A window that is already running is run right away,
+		// rather than on the next check of the scheduler.
+		if end, ok := schedule.pending(schedule.CreatedAt); ok {
+			err := d.runSchedule(ctx, schedule, end)
+			if err != nil {
+				d.Logger.HandleBotError(err, d.Bot, c.Message())
+			}
+		}
+	case "list":
+		schedules, err := d.Datastore.GetSchedules(ctx, c.Chat().ID)
+		if err != nil {
+			d.Logger.HandleBotError(err, d.Bot, c.Message())
+			return nil
+		}
+
+		if len(schedules) == 0 {
+			d.reply(c, d.Locale[locale.MessageUnderAttackScheduleEmpty])
+			return nil
+		}
+
+		var lines []string
+		for _, schedule := range schedules {
+			lines = append(lines, "#"+strconv.FormatInt(schedule.ID, 10)+": "+schedule.String())
+		}
+
+		d.reply(c, strings.Replace(d.Locale[locale.MessageUnderAttackScheduleList], "{{schedules}}", strings.Join(lines, "\n"), 1))
+		return nil
+	case "remove":
+		if len(args) != 2 {
+			d.reply(c, d.Locale[locale.MessageUnderAttackScheduleUsage])
+			return nil
+		}
+
+		id, err := strconv.ParseInt(strings.TrimPrefix(args[1], "#"), 10, 64)
+		if err != nil {
+			d.reply(c, d.Locale[locale.MessageUnderAttackScheduleUsage])
+			return nil
+		}
+
+		removed, err := d.Datastore.DeleteSchedule(ctx, c.Chat().ID, id)
+		if err != nil {
+			d.Logger.HandleBotError(err, d.Bot, c.Message())
+			return nil
+		}
+
+		if !removed {
+			d.reply(c, d.Locale[locale.MessageUnderAttackScheduleNotFound])
+			return nil
+		}
+
+		d.reply(c, d.Locale[locale.MessageUnderAttackScheduleRemoved])
+
+		d.Logger.Info(
+			"under attack schedule removed",
+			logger.ChatID(c.Chat().ID),
+			logger.UserID(c.Sender().ID),
+			logger.F("schedule_id", id),
+		)
+	default:
+		d.reply(c, d.Locale[locale.MessageUnderAttackScheduleUsage])
+		return nil
+	}
+
+	err := d.invalidate(ctx, c.Chat().ID)
+	if err != nil {
+		d.Logger.HandleBotError(err, d.Bot, c.Message())
+	}
+
+	return nil
+}
@@ -0,0 +1,97 @@
+package underattack_test
+
+import (
+	"testing"
+	"time"
+
+	"captcha-lite/underattack"
+)
+
+func TestParseSchedule(t *testing.T) {
+	schedule, err := underattack.ParseSchedule("22:30-06:00", "Asia/Jakarta")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if schedule.StartMinute != 22*60+30 || schedule.EndMinute != 6*60 {
+		t.Errorf("unexpected window: %d-%d", schedule.StartMinute, schedule.EndMinute)
+	}
+
+	if schedule.String() != "22:30-06:00 Asia/Jakarta" {
+		t.Errorf("unexpected string: %q", schedule.String())
+	}
+
+	for _, test := range []struct {
+		window   string
+		timezone string
+	}{
+		{window: "22:30", timezone: "UTC"},
+		{window: "25:00-06:00", timezone: "UTC"},
+		{window: "06:00-06:00", timezone: "UTC"},
+		{window: "00:00-06:00", timezone: "Mars/Olympus"},
+		{window: "00:00-06:00", timezone: ""},
+		{window: "00:00-06:00", timezone: "local"},
+	} {
+		_, err := underattack.ParseSchedule(test.window, test.timezone)
+		if err == nil {
+			t.Errorf("%q %q: expecting an error, got nil", test.window, test.timezone)
+		}
+	}
+}
+
+func TestSchedule_Window(t *testing.T) {
+	jakarta, err := time.LoadLocation("Asia/Jakarta")
+	if err != nil {
+		t.Skipf("timezone database is not available: %v", err)
+	}
+
+	schedule, err := underattack.ParseSchedule("22:00-06:00", "Asia/Jakarta")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		now   time.Time
+		ok    bool
+		start time.Time
+		end   time.Time
+	}{
+		{
+			name:  "before midnight",
+			now:   time.Date(2024, 1, 1, 23, 0, 0, 0, jakarta),
+			ok:    true,
+			start: time.Date(2024, 1, 1, 22, 0, 0, 0, jakarta),
+			end:   time.Date(2024, 1, 2, 6, 0, 0, 0, jakarta),
+		},
+		{
+			name:  "after midnight",
+			now:   time.Date(2024, 1, 2, 5, 59, 0, 0, jakarta),
+			ok:    true,
+			start: time.Date(2024, 1, 1, 22, 0, 0, 0, jakarta),
+			end:   time.Date(2024, 1, 2, 6, 0, 0, 0, jakarta),
+		},
+		{
+			name: "outside, in another timezone",
+			now:  time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC), // 07:00 in Jakarta
+			ok:   false,
+		},
+		{
+			name: "at the end",
+			now:  time.Date(2024, 1, 2, 6, 0, 0, 0, jakarta),
+			ok:   false,
+		},
+	}
+
+	for _, test := range tests {
+		start, end, ok := schedule.Window(test.now)
+		if ok != test.ok {
+			t.Errorf("%s: expecting ok to be %v, got %v", test.name, test.ok, ok)
+			continue
+		}
+
+		if ok && (!start.Equal(test.start) || !end.Equal(test.end)) {
+			t.Errorf("%s: unexpected window %v - %v", test.name, start, end)
+		}
+	}
+}
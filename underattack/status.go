@@ -0,0 +1,167 @@
+package underattack
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"captcha-lite/locale"
+	"captcha-lite/logger"
+	"captcha-lite/utils"
+
+	tb "gopkg.in/telebot.v3"
+)
+
+// StatusHandler provides a handler for /underattackstatus command.
+func (d *Dependency) StatusHandler(c tb.Context) error {
+	if c.Message().Private() || c.Sender().IsBot {
+		return nil
+	}
+
+	if !d.requireAdmin(c) {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute*1)
+	defer cancel()
+
+	underAttackModeEnabled, err := d.AreWe(ctx, c.Chat().ID)
+	if err != nil {
+		d.Logger.HandleBotError(err, d.Bot, c.Message())
+		return nil
+	}
+
+	if !underAttackModeEnabled {
+		d.reply(c, d.Locale[locale.MessageUnderAttackNotEnabled])
+		return nil
+	}
+
+	entry, err := d.Datastore.GetUnderAttackEntry(ctx, c.Chat().ID)
+	if err != nil {
+		d.Logger.HandleBotError(err, d.Bot, c.Message())
+		return nil
+	}
+
+	bans, err := d.Datastore.CountBansByAttack(ctx, c.Chat().ID, entry.NotificationMessageID)
+	if err != nil {
+		d.Logger.HandleBotError(err, d.Bot, c.Message())
+		return nil
+	}
+
+	enabledBy := d.Locale[locale.MessageUnderAttackStatusAutomatic]
+	if entry.EnabledBy != 0 {
+		enabledBy = d.memberName(c.Chat(), entry.EnabledBy)
+	}
+
+	d.reply(c, strings.NewReplacer(
+		"{{enabledBy}}", enabledBy,
+		"{{expiresAt}}", d.formatTime(entry.ExpiresAt, entry.Timezone),
+		"{{action}}", d.describe(d.activeAction(entry)),
+		"{{bans}}", strconv.Itoa(bans),
+	).Replace(d.Locale[locale.MessageUnderAttackStatus]))
+
+	return nil
+}
+
+// NotifyHandler provides a handler for /underattacknotify command,
+// which lets an admin opt in or out of the private notifications
+// when the under attack mode is turned on or off.
+func (d *Dependency) NotifyHandler(c tb.Context) error {
+	if c.Message().Private() || c.Sender().IsBot {
+		return nil
+	}
+
+	if !d.requireAdmin(c) {
+		return nil
+	}
+
+	args := c.Args()
+	if len(args) != 1 || (!strings.EqualFold(args[0], "on") && !strings.EqualFold(args[0], "off")) {
+		d.reply(c, d.Locale[locale.MessageUnderAttackNotifyUsage])
+		return nil
+	}
+
+	subscribed := strings.EqualFold(args[0], "on")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute*1)
+	defer cancel()
+
+	err := d.Datastore.SetNotificationSubscription(ctx, c.Chat().ID, c.Sender().ID, subscribed)
+	if err != nil {
+		d.Logger.HandleBotError(err, d.Bot, c.Message())
+		return nil
+	}
+
+	if subscribed {
+		d.reply(c, d.Locale[locale.MessageUnderAttackNotifyOn])
+	} else {
+		d.reply(c, d.Locale[locale.MessageUnderAttackNotifyOff])
+	}
+
+	d.Logger.Info(
+		"under attack notification subscription changed",
+		logger.ChatID(c.Chat().ID),
+		logger.UserID(c.Sender().ID),
+		logger.F("subscribed", subscribed),
+	)
+
+	return nil
+}
+
+// notifySubscribers sends the message privately to the admins that have
+// opted in to the notifications of the chat. The "{{group}}" on the message
+// is replaced with the title of the chat.
+func (d *Dependency) notifySubscribers(ctx context.Context, chat *tb.Chat, message string) {
+	subscribers, err := d.Datastore.GetNotificationSubscribers(ctx, chat.ID)
+	if err != nil {
+		d.Logger.HandleError(err)
+		return
+	}
+
+	if len(subscribers) == 0 {
+		return
+	}
+
+	title := chat.Title
+	if title == "" {
+		// The expiry worker only knows the chat ID.
+		fullChat, err := d.Bot.ChatByID(chat.ID)
+		if err != nil {
+			d.Logger.HandleError(err)
+			title = strconv.FormatInt(chat.ID, 10)
+		} else {
+			title = fullChat.Title
+		}
+	}
+
+	message = strings.Replace(message, "{{group}}", title, 1)
+
+	for _, userID := range subscribers {
+		_, err := d.Bot.Send(&tb.User{ID: userID}, message, &tb.SendOptions{DisableWebPagePreview: true})
+		if err != nil {
+			d.Logger.Debug(
+				"could not notify subscriber privately",
+				logger.ChatID(chat.ID),
+				logger.UserID(userID),
+				logger.F("error", err.Error()),
+			)
+		}
+	}
+}
+
+// memberName returns the name of the user on the chat,
+// or their ID if they can't be found.
+func (d *Dependency) memberName(chat *tb.Chat, userID int64) string {
+	member, err := d.Bot.ChatMemberOf(chat, &tb.User{ID: userID})
+	if err != nil || member.User == nil {
+		return strconv.FormatInt(userID, 10)
+	}
+
+	name := member.User.FirstName + utils.ShouldAddSpace(member.User) + member.User.LastName
+	if member.User.Username != "" {
+		name += " (@" + member.User.Username + ")"
+	}
+
+	return name
+}
@@ -0,0 +1,113 @@
+package underattack
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"captcha-lite/locale"
+	"captcha-lite/logger"
+
+	tb "gopkg.in/telebot.v3"
+)
+
+// timezoneHandler handles "/underattack timezone [timezone]". Without the
+// argument, it replies with the current timezone of the group.
+func (d *Dependency) timezoneHandler(c tb.Context, args []string) error {
+	if len(args) > 1 {
+		d.reply(c, d.Locale[locale.MessageUnderAttackTimezoneUsage])
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute*1)
+	defer cancel()
+
+	if len(args) == 0 {
+		entry, err := d.entry(ctx, c.Chat().ID)
+		if err != nil {
+			d.Logger.HandleBotError(err, d.Bot, c.Message())
+			return nil
+		}
+
+		d.reply(c, d.timezoneMessage(entry.Timezone))
+		return nil
+	}
+
+	location, err := loadTimezone(args[0])
+	if err != nil {
+		d.reply(c, d.Locale[locale.MessageUnderAttackTimezoneUsage])
+		return nil
+	}
+
+	err = d.Datastore.SetTimezone(ctx, c.Chat().ID, location.String())
+	if err != nil {
+		d.Logger.HandleBotError(err, d.Bot, c.Message())
+		return nil
+	}
+
+	err = d.invalidate(ctx, c.Chat().ID)
+	if err != nil {
+		d.Logger.HandleBotError(err, d.Bot, c.Message())
+		return nil
+	}
+
+	d.reply(c, d.timezoneMessage(location.String()))
+
+	d.Logger.Info(
+		"under attack timezone changed",
+		logger.ChatID(c.Chat().ID),
+		logger.UserID(c.Sender().ID),
+		logger.F("timezone", location.String()),
+	)
+
+	return nil
+}
+
+// loadTimezone loads the IANA timezone that an admin gave. An empty name
+// and "Local" are valid for time.LoadLocation, but they mean UTC and the
+// timezone of the server, which is not what the admin wants.
+func loadTimezone(name string) (*time.Location, error) {
+	if name == "" || strings.EqualFold(name, "Local") {
+		return nil, fmt.Errorf("invalid timezone: %q", name)
+	}
+
+	return time.LoadLocation(name)
+}
+
+func (d *Dependency) timezoneMessage(timezone string) string {
+	return strings.NewReplacer(
+		"{{timezone}}", d.location(timezone).String(),
+		"{{now}}", d.formatTime(time.Now(), timezone),
+	).Replace(d.Locale[locale.MessageUnderAttackTimezone])
+}
+
+// location returns the location of the timezone of the group. An empty
+// or unknown timezone falls back to the default timezone, then UTC.
+func (d *Dependency) location(timezone string) *time.Location {
+	for _, name := range []string{timezone, d.DefaultTimezone} {
+		if name == "" {
+			continue
+		}
+
+		location, err := time.LoadLocation(name)
+		if err == nil {
+			return location
+		}
+	}
+
+	return time.UTC
+}
+
+// formatTime formats the time for the users, in the timezone of the group
+// and with the layouts of the locale. The date is only shown if it's not
+// today in that timezone.
+func (d *Dependency) formatTime(t time.Time, timezone string) string {
+	location := d.location(timezone)
+	t = t.In(location)
+
+	now := time.Now().In(location)
+	today := t.Year() == now.Year() && t.YearDay() == now.YearDay()
+
+	return locale.FormatTime(d.Locale, t, !today)
+}
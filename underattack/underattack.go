@@ -3,21 +3,47 @@ package underattack
 import (
 	"time"
 
+	"captcha-lite/cache"
 	"captcha-lite/locale"
 	"captcha-lite/logger"
+	"captcha-lite/telegram"
+)
 
-	"github.com/allegro/bigcache/v3"
-	tb "gopkg.in/telebot.v3"
+const (
+	// DefaultDuration is how long the under attack mode lasts, if the
+	// duration is not specified on the command.
+	DefaultDuration = 30 * time.Minute
+	// MinDuration is the shortest duration that can be specified.
+	MinDuration = time.Minute
+	// MaxDuration is the longest duration that can be specified. Extending
+	// the under attack mode can't go past this duration from now either.
+	MaxDuration = 7 * 24 * time.Hour
 )
 
+// cacheTTL is how long the state of a group is cached for. The changes
+// made by the other instances are picked up by then at the latest.
+const cacheTTL = 5 * time.Minute
+
 // Dependency contains the dependency injection struct
 // for methods in the UnderAttack package
 type Dependency struct {
 	Datastore Datastore
-	Memory    *bigcache.BigCache
-	Bot       *tb.Bot
+	Memory    cache.Cache
+	Bot       telegram.Client
 	Logger    logger.Logger
 	Locale    map[locale.Message]string
+	// Detector turns on the under attack mode automatically when there
+	// are too many joins in a short time. Nil disables it.
+	Detector *JoinRateDetector
+	// DefaultAction is the action for the groups that have not chosen
+	// one. Empty means ActionBan.
+	DefaultAction Action
+	// RestoreInviteLinks revokes the invite link that was created for
+	// the attack when the attack ends.
+	RestoreInviteLinks bool
+	// DefaultTimezone is the IANA timezone that the times are shown in
+	// for the groups that have not chosen one. Empty means UTC.
+	DefaultTimezone string
 }
 
 // UnderAttack provides a data struct to interact with
@@ -28,4 +54,37 @@ type UnderAttack struct {
 	NotificationMessageID int64     `db:"notification_message_id"`
 	ExpiresAt             time.Time `db:"expires_at"`
 	UpdatedAt             time.Time `db:"updated_at"`
+	// Action is what happens to the users that join during the under
+	// attack mode. Empty means the default action.
+	Action Action `db:"action"`
+	// ActiveAction is the action that is in force during the current
+	// under attack mode. It's recorded when the mode starts, so it won't
+	// change halfway if the default action is changed, and cleared when
+	// it ends. Empty means the mode is not on.
+	ActiveAction Action `db:"active_action"`
+	// EnabledBy is the ID of the admin that turned on the under attack
+	// mode. Zero means it was turned on automatically.
+	EnabledBy int64 `db:"enabled_by"`
+	// LockdownPermissions is the JSON encoded snapshot of the default
+	// permissions of the group (tb.Rights) before it was locked down.
+	// Empty means the group is not locked down.
+	LockdownPermissions string `db:"lockdown_permissions"`
+	// InviteLink is the invite link that the bot created when the
+	// invite links were revoked, so it can be revoked later on.
+	InviteLink string `db:"invite_link"`
+	// Timezone is the IANA timezone that the times are shown in for the
+	// group. Empty means the default timezone.
+	Timezone string `db:"timezone"`
+}
+
+// Ban is a user that was banned during the under attack mode.
+type Ban struct {
+	GroupID  int64  `db:"group_id"`
+	UserID   int64  `db:"user_id"`
+	Name     string `db:"name"`
+	Username string `db:"username"`
+	// AttackID identifies the under attack mode that banned the user.
+	// It's the ID of its notification message, which is unique per group.
+	AttackID int64     `db:"attack_id"`
+	BannedAt time.Time `db:"banned_at"`
 }
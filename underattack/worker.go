@@ -0,0 +1,115 @@
+package underattack
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"captcha-lite/locale"
+	"captcha-lite/logger"
+
+	tb "gopkg.in/telebot.v3"
+)
+
+// DefaultExpiryCheckInterval is the default interval between two scans
+// of the expired under attack entries.
+const DefaultExpiryCheckInterval = time.Minute
+
+// RunExpiryWorker ends every under attack mode that has expired. It scans
+// the datastore right away, so the entries that expired while the bot was
+// down are handled on boot, and then once every interval until ctx is done.
+//
+// It blocks, so run it on its own goroutine.
+func (d *Dependency) RunExpiryWorker(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultExpiryCheckInterval
+	}
+
+	d.expireEntries(ctx)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			d.expireEntries(ctx)
+		}
+	}
+}
+
+// expireEntries ends every expired under attack entry. A failure on one
+// entry does not stop the others from being ended.
+func (d *Dependency) expireEntries(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute*1)
+	defer cancel()
+
+	entries, err := d.Datastore.GetExpiredUnderAttackEntries(ctx, time.Now())
+	if err != nil {
+		d.Logger.HandleError(err)
+		return
+	}
+
+	for _, entry := range entries {
+		err := d.end(ctx, entry)
+		if err != nil {
+			d.Logger.HandleError(err)
+			continue
+		}
+
+		d.Logger.Info(
+			"under attack mode expired",
+			logger.ChatID(entry.GroupID),
+			logger.F("expires_at", entry.ExpiresAt),
+		)
+	}
+}
+
+// end turns off the under attack mode of the given entry, lifts the
+// lockdown, unpins the notification message, and tells the group that
+// it has ended.
+//
+// The lockdown is lifted first, so if it fails, the entry stays on and
+// the expiry worker will retry. The entry is turned off next. If Telegram
+// fails afterwards, the pinned message stays, but new users can join again.
+func (d *Dependency) end(ctx context.Context, entry UnderAttack) error {
+	err := d.restoreLockdown(ctx, entry)
+	if err != nil {
+		return err
+	}
+
+	err = d.restoreInviteLink(ctx, entry)
+	if err != nil {
+		return err
+	}
+
+	err = d.Datastore.SetUnderAttackStatus(ctx, entry.GroupID, false, time.Now(), 0, "")
+	if err != nil {
+		return err
+	}
+
+	err = d.invalidate(ctx, entry.GroupID)
+	if err != nil {
+		return err
+	}
+
+	chat := &tb.Chat{ID: entry.GroupID}
+
+	if entry.NotificationMessageID != 0 {
+		err = d.Bot.Unpin(chat, int(entry.NotificationMessageID))
+		if err != nil && !strings.Contains(err.Error(), "message to unpin not found") {
+			return err
+		}
+	}
+
+	_, err = d.Bot.Send(chat, d.Locale[locale.MessageUnderAttackEnded])
+	if err != nil {
+		return err
+	}
+
+	d.notifySubscribers(ctx, chat, d.Locale[locale.MessageUnderAttackNotifyDisabled])
+
+	return nil
+}